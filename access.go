@@ -0,0 +1,40 @@
+package tiny_rpc
+
+// WithAllowedMethods only dispatch the listed methods, named "Service.Method", other calls fail with
+// MethodNotAllowedError without reaching the handler. The built-in services must be listed as well.
+// Without the option every registered method is dispatched
+func WithAllowedMethods(methods []string) Option {
+	return func(o *options) {
+		if o.allowedMethods == nil {
+			o.allowedMethods = make(map[string]struct{})
+		}
+		for _, method := range methods {
+			o.allowedMethods[method] = struct{}{}
+		}
+	}
+}
+
+// WithDeniedMethods never dispatch the listed methods, named "Service.Method", their calls fail with
+// MethodNotAllowedError without reaching the handler. Denying a method wins over allowing it
+func WithDeniedMethods(methods []string) Option {
+	return func(o *options) {
+		if o.deniedMethods == nil {
+			o.deniedMethods = make(map[string]struct{})
+		}
+		for _, method := range methods {
+			o.deniedMethods[method] = struct{}{}
+		}
+	}
+}
+
+// permitted report whether the server may dispatch calls to method
+func (s *Server) permitted(method string) bool {
+	if _, denied := s.options.deniedMethods[method]; denied {
+		return false
+	}
+	if s.options.allowedMethods == nil {
+		return true
+	}
+	_, allowed := s.options.allowedMethods[method]
+	return allowed
+}
@@ -0,0 +1,54 @@
+package tiny_rpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestServer_MethodAccess .
+func TestServer_MethodAccess(t *testing.T) {
+	var dispatched []string
+	var mutex sync.Mutex
+	record := func(ctx context.Context, method string, req interface{}, next Handler) (interface{}, error) {
+		mutex.Lock()
+		dispatched = append(dispatched, method)
+		mutex.Unlock()
+		return next(ctx, req)
+	}
+	_, listener := startServer(t, WithInterceptors(record),
+		WithAllowedMethods([]string{"ArithService.Add", "ArithService.Mul", "ArithService.Pow"}),
+		WithDeniedMethods([]string{"ArithService.Mul"}))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		err    error
+	}{
+		{"test-1", "ArithService.Add", nil},
+		{"test-2", "ArithService.Sub", &MethodNotAllowedError{Message: "rpc: method ArithService.Sub not allowed"}},
+		// 同时放行和拒绝时以拒绝为准
+		{"test-3", "ArithService.Mul", &MethodNotAllowedError{Message: "rpc: method ArithService.Mul not allowed"}},
+		{"test-4", "ArithService.Pow", &MethodNotFoundError{Message: "rpc: can't find method ArithService.Pow"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := &pb.ArithResponse{}
+			err := client.Call(tt.method, &pb.ArithRequest{A: 2, B: 3}, reply)
+			assert.Equal(t, tt.err, err)
+			if tt.err == nil {
+				assert.Equal(t, 5.0, reply.C)
+			}
+		})
+	}
+	// 被拒绝的调用不会到达处理函数
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"ArithService.Add"}, dispatched)
+}
@@ -0,0 +1,62 @@
+package tiny_rpc
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// WithArgsPool decode the args of method, given as "Service.Method", into values recycled through a pool.
+// newArgs must return a pointer to the args type of the method, values are reset with their Reset
+// method, or zeroed, before reuse. The args are put back once the response is written, handlers must not retain them
+func WithArgsPool(method string, newArgs func() interface{}) Option {
+	return func(o *options) {
+		if o.argsPools == nil {
+			o.argsPools = make(map[string]*sync.Pool)
+		}
+		o.argsPools[method] = &sync.Pool{New: newArgs}
+	}
+}
+
+// resetter is implemented by args which can be cleared for reuse, such as proto messages
+type resetter interface {
+	Reset()
+}
+
+// getArgs allocate the value the request body of method decodes into, from its pool if any
+func (s *Server) getArgs(method string, mtype *methodType) (argv reflect.Value, argIsValue bool, err error) {
+	pool := s.options.argsPools[method]
+	if pool == nil {
+		argv, argIsValue = mtype.newArgv()
+		return argv, argIsValue, nil
+	}
+	args := pool.Get()
+	argv = reflect.ValueOf(args)
+	argIsValue = mtype.ArgType.Kind() != reflect.Pointer
+	want := mtype.ArgType
+	if argIsValue {
+		want = reflect.PointerTo(want)
+	}
+	if argv.Type() != want {
+		return reflect.Value{}, false, fmt.Errorf("rpc: args pool of %s returns %T, want %s", method, args, want)
+	}
+	// 未清空的参数会把上一个请求的数据带入本次调用，如 JSON 合并的 map 和省略的字段
+	if r, ok := args.(resetter); ok {
+		r.Reset()
+	} else {
+		argv.Elem().Set(reflect.Zero(argv.Elem().Type()))
+	}
+	return argv, argIsValue, nil
+}
+
+// putArgs return the args of method to its pool, argv is the value handed to the method
+func (s *Server) putArgs(method string, mtype *methodType, argv reflect.Value) {
+	pool := s.options.argsPools[method]
+	if pool == nil || !argv.IsValid() {
+		return
+	}
+	if mtype.ArgType.Kind() != reflect.Pointer {
+		argv = argv.Addr()
+	}
+	pool.Put(argv.Interface())
+}
@@ -0,0 +1,101 @@
+package tiny_rpc
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"tiny_rpc/serializer"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWithArgsPool .
+func TestWithArgsPool(t *testing.T) {
+	var created int64
+	_, listener := startServer(t, WithArgsPool("ArithService.Add", func() interface{} {
+		atomic.AddInt64(&created, 1)
+		return &pb.ArithRequest{}
+	}))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	for i := 0; i < 100; i++ {
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 1}, reply))
+		assert.Equal(t, float64(i+1), reply.C)
+	}
+	// 参数对象被复用，race 模式下 sync.Pool 会随机丢弃部分对象
+	assert.Less(t, atomic.LoadInt64(&created), int64(50))
+}
+
+// TestWithArgsPool_Allocs .
+func TestWithArgsPool_Allocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops values under the race detector")
+	}
+	allocs := func(opts ...Option) float64 {
+		_, listener := startServer(t, opts...)
+		defer listener.Close()
+		client, err := Dial("tcp", listener.Addr().String())
+		assert.Nil(t, err)
+		defer client.Close()
+		args, reply := &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{}
+		return testing.AllocsPerRun(200, func() {
+			client.Call("ArithService.Add", args, reply)
+		})
+	}
+	unpooled := allocs()
+	pooled := allocs(WithArgsPool("ArithService.Add", func() interface{} { return &pb.ArithRequest{} }))
+	assert.Less(t, pooled, unpooled)
+}
+
+// TestWithArgsPool_WrongType .
+func TestWithArgsPool_WrongType(t *testing.T) {
+	_, listener := startServer(t, WithArgsPool("ArithService.Add", func() interface{} { return &pb.ArithResponse{} }))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "args pool of ArithService.Add"))
+	// 连接仍可继续使用
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Mul", &pb.ArithRequest{A: 2, B: 3}, reply))
+	assert.Equal(t, 6.0, reply.C)
+}
+
+// TaggedArgs args decoded with JSON, which merges maps and skips omitted fields
+type TaggedArgs struct {
+	Tags map[string]int `json:"tags,omitempty"`
+	N    int            `json:"n,omitempty"`
+}
+
+// TagService echoes its args
+type TagService struct{}
+
+// Echo .
+func (TagService) Echo(args *TaggedArgs, reply *TaggedArgs) error {
+	*reply = *args
+	return nil
+}
+
+// TestWithArgsPool_Zeroed .
+func TestWithArgsPool_Zeroed(t *testing.T) {
+	// 单个对象的池，保证第二个请求复用第一个请求的参数
+	args := &TaggedArgs{}
+	server, listener := startServer(t, WithSerializer(serializer.JSON),
+		WithArgsPool("TagService.Echo", func() interface{} { return args }))
+	assert.Nil(t, server.Register(TagService{}))
+	client, err := Dial("tcp", listener.Addr().String(), WithSerializer(serializer.JSON))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &TaggedArgs{}
+	assert.Nil(t, client.Call("TagService.Echo", &TaggedArgs{Tags: map[string]int{"a": 1}, N: 5}, reply))
+	reply = &TaggedArgs{}
+	assert.Nil(t, client.Call("TagService.Echo", &TaggedArgs{Tags: map[string]int{"b": 2}}, reply))
+	assert.Equal(t, &TaggedArgs{Tags: map[string]int{"b": 2}}, reply)
+}
@@ -0,0 +1,210 @@
+package tiny_rpc
+
+import (
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultQuarantine = 5 * time.Second
+
+// Balancer picks the backend of a call among the available addresses
+type Balancer interface {
+	Pick(addrs []string) string
+}
+
+// RoundRobin a Balancer using the addresses in turn
+type RoundRobin struct {
+	next uint64
+}
+
+// Pick .
+func (r *RoundRobin) Pick(addrs []string) string {
+	n := atomic.AddUint64(&r.next, 1) - 1
+	return addrs[n%uint64(len(addrs))]
+}
+
+// Random a Balancer choosing an address at random
+type Random struct{}
+
+// Pick .
+func (Random) Pick(addrs []string) string {
+	return addrs[rand.Intn(len(addrs))]
+}
+
+// Resolver list the addresses of the backends
+type Resolver func() ([]string, error)
+
+// StaticResolver a Resolver always returning addrs
+func StaticResolver(addrs ...string) Resolver {
+	return func() ([]string, error) {
+		return addrs, nil
+	}
+}
+
+// WithQuarantine set how long a BalancedClient skips a backend it failed to dial, a non-positive
+// duration only skips it for the rest of the call which failed to dial it
+func WithQuarantine(d time.Duration) Option {
+	return func(o *options) {
+		o.quarantine = d
+	}
+}
+
+// BalancedClient spreads calls across several backends, each backend gets one connection
+// dialed on its first call
+type BalancedClient struct {
+	network  string
+	resolve  Resolver
+	balancer Balancer
+	opts     []Option
+	options  options
+
+	mutex   sync.Mutex // protects clients, down, closed
+	clients map[string]*Client
+	down    map[string]time.Time // quarantined backends and when they may be dialed again
+	closed  bool
+}
+
+// NewBalancedClient create a client calling the backends returned by resolve,
+// the connections are dialed with Dial(network, addr, opts...)
+func NewBalancedClient(network string, resolve Resolver, balancer Balancer, opts ...Option) *BalancedClient {
+	return &BalancedClient{
+		network:  network,
+		resolve:  resolve,
+		balancer: balancer,
+		opts:     opts,
+		options:  newOptions(opts),
+		clients:  make(map[string]*Client),
+		down:     make(map[string]time.Time),
+	}
+}
+
+// Call synchronously calls the rpc function on a backend picked by the balancer,
+// backends which cannot be dialed are quarantined and another one is picked
+func (b *BalancedClient) Call(serviceMethod string, args interface{}, reply interface{}, opts ...CallOption) error {
+	client, err := b.pick()
+	if err != nil {
+		return err
+	}
+	err = client.Call(serviceMethod, args, reply, opts...)
+	if err != rpc.ErrShutdown {
+		return err
+	}
+	// 连接已断开，请求未发出，重新选择后端重试一次
+	b.drop(client)
+	if client, err = b.pick(); err != nil {
+		return err
+	}
+	return client.Call(serviceMethod, args, reply, opts...)
+}
+
+// pick choose an available backend and get its client, each backend is dialed at most once so that
+// the error of the last dial is returned once every backend failed
+func (b *BalancedClient) pick() (*Client, error) {
+	addrs, err := b.resolve()
+	if err != nil {
+		return nil, err
+	}
+	// 隔离时间不大于零时失败的后端不会被跳过，本次选择中不再重试
+	tried := make(map[string]struct{})
+	var lastErr error
+	for {
+		available := b.available(addrs, tried)
+		if len(available) == 0 {
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w: %v", NoBackendError, lastErr)
+			}
+			return nil, NoBackendError
+		}
+		addr := b.balancer.Pick(available)
+		client, err := b.client(addr)
+		if err == nil || err == rpc.ErrShutdown {
+			return client, err
+		}
+		tried[addr] = struct{}{}
+		lastErr = err
+	}
+}
+
+// available filter out the quarantined addresses and the ones already tried
+func (b *BalancedClient) available(addrs []string, tried map[string]struct{}) []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	available := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if until, ok := b.down[addr]; ok && now.Before(until) {
+			continue
+		}
+		if _, ok := tried[addr]; ok {
+			continue
+		}
+		available = append(available, addr)
+	}
+	return available
+}
+
+// client get the connection of addr, dialing it when needed, a failed dial quarantines addr.
+// Fails with rpc.ErrShutdown once the BalancedClient is closed
+func (b *BalancedClient) client(addr string) (*Client, error) {
+	b.mutex.Lock()
+	client, ok := b.clients[addr]
+	closed := b.closed
+	b.mutex.Unlock()
+	if closed {
+		return nil, rpc.ErrShutdown
+	}
+	if ok {
+		return client, nil
+	}
+
+	client, err := Dial(b.network, addr, b.opts...)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	// 拨号期间已关闭，新连接不再保留
+	if b.closed {
+		if err == nil {
+			client.Close()
+		}
+		return nil, rpc.ErrShutdown
+	}
+	if err != nil {
+		b.down[addr] = time.Now().Add(b.options.quarantine)
+		return nil, err
+	}
+	delete(b.down, addr)
+	// 并发拨号时保留先建立的连接
+	if existing, ok := b.clients[addr]; ok {
+		client.Close()
+		return existing, nil
+	}
+	b.clients[addr] = client
+	return client, nil
+}
+
+// drop forget a shut down client so that its backend is dialed again
+func (b *BalancedClient) drop(client *Client) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for addr, c := range b.clients {
+		if c == client {
+			delete(b.clients, addr)
+		}
+	}
+	client.Close()
+}
+
+// Close close the connections to every backend, later calls fail with rpc.ErrShutdown
+func (b *BalancedClient) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	for addr, client := range b.clients {
+		client.Close()
+		delete(b.clients, addr)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package tiny_rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startCountingServer start a server counting the calls it handles
+func startCountingServer(t *testing.T, calls *int64) net.Listener {
+	count := func(ctx context.Context, method string, req interface{}, next Handler) (interface{}, error) {
+		atomic.AddInt64(calls, 1)
+		return next(ctx, req)
+	}
+	_, listener := startServer(t, WithInterceptors(count))
+	return listener
+}
+
+// TestBalancedClient_RoundRobin .
+func TestBalancedClient_RoundRobin(t *testing.T) {
+	calls := make([]int64, 3)
+	addrs := make([]string, 3)
+	for i := range addrs {
+		addrs[i] = startCountingServer(t, &calls[i]).Addr().String()
+	}
+
+	client := NewBalancedClient("tcp", StaticResolver(addrs...), &RoundRobin{})
+	defer client.Close()
+	for i := 0; i < 30; i++ {
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+		assert.Equal(t, 3.0, reply.C)
+	}
+	for i := range calls {
+		assert.Equal(t, int64(10), atomic.LoadInt64(&calls[i]))
+	}
+}
+
+// TestBalancedClient_SkipDown .
+func TestBalancedClient_SkipDown(t *testing.T) {
+	var calls int64
+	up := startCountingServer(t, &calls).Addr().String()
+	// 关闭监听得到一个无法连接的地址
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	down := listener.Addr().String()
+	listener.Close()
+
+	for _, balancer := range []Balancer{&RoundRobin{}, Random{}} {
+		calls = 0
+		client := NewBalancedClient("tcp", StaticResolver(down, up), balancer, WithQuarantine(time.Minute))
+		for i := 0; i < 10; i++ {
+			assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{}))
+		}
+		assert.Equal(t, int64(10), atomic.LoadInt64(&calls))
+		assert.Contains(t, client.down, down)
+		client.Close()
+	}
+
+	// 全部后端不可用时返回最后一次拨号的错误，不隔离时同样不会一直重试
+	for _, quarantine := range []time.Duration{defaultQuarantine, 0, -1} {
+		client := NewBalancedClient("tcp", StaticResolver(down), &RoundRobin{}, WithQuarantine(quarantine))
+		err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+		assert.True(t, errors.Is(err, NoBackendError))
+		assert.Contains(t, err.Error(), "connection refused")
+	}
+	client := NewBalancedClient("tcp", StaticResolver(down, up), Random{}, WithQuarantine(0))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{}))
+	}
+	client.Close()
+}
+
+// TestBalancedClient_Close .
+func TestBalancedClient_Close(t *testing.T) {
+	var calls int64
+	addr := startCountingServer(t, &calls).Addr().String()
+
+	client := NewBalancedClient("tcp", StaticResolver(addr), &RoundRobin{})
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{}))
+	assert.Nil(t, client.Close())
+
+	// 关闭后的调用不会重新拨号
+	err := client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.Equal(t, rpc.ErrShutdown, err)
+	assert.Len(t, client.clients, 0)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&calls))
+}
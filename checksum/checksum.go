@@ -0,0 +1,27 @@
+package checksum
+
+// ChecksumType type of checksum algorithms supported by rpc
+type ChecksumType uint8
+
+// Checksum is interface, each checksum computes a digest of the body
+type Checksum interface {
+	Sum([]byte) uint64
+}
+
+const (
+	Crc32 ChecksumType = iota
+	Crc64
+	XXHash64
+	// None disables checksums, the body is sent with a zero checksum which is never verified
+	None
+	// Crc32C crc32 with the Castagnoli polynomial, computed with the SSE4.2 and ARM64 CRC
+	// instructions, it is faster than Crc32 on CPUs which do not accelerate the IEEE polynomial
+	Crc32C
+)
+
+var Checksums = map[ChecksumType]Checksum{
+	Crc32:    Crc32Checksum{},
+	Crc64:    Crc64Checksum{},
+	XXHash64: XXHash64Checksum{},
+	Crc32C:   Crc32CChecksum{},
+}
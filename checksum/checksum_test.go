@@ -0,0 +1,39 @@
+package checksum
+
+import (
+	"bytes"
+	"hash/crc32"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCrc32CChecksum .
+func TestCrc32CChecksum(t *testing.T) {
+	data := []byte("tinyrpc")
+	assert.Equal(t, uint64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))), Crc32CChecksum{}.Sum(data))
+	assert.NotEqual(t, Crc32Checksum{}.Sum(data), Crc32CChecksum{}.Sum(data))
+}
+
+// BenchmarkSum .
+func BenchmarkSum(b *testing.B) {
+	data := bytes.Repeat([]byte("tinyrpc "), 128<<10)
+	cases := []struct {
+		name string
+		cs   Checksum
+	}{
+		{"crc32", Crc32Checksum{}},
+		{"crc32c", Crc32CChecksum{}},
+		{"crc64", Crc64Checksum{}},
+		{"xxhash64", XXHash64Checksum{}},
+	}
+	for _, c := range cases {
+		cs := c.cs
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				cs.Sum(data)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package checksum
+
+import "hash/crc32"
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Crc32Checksum implements the Checksum interface
+type Crc32Checksum struct {
+}
+
+// Sum .
+func (_ Crc32Checksum) Sum(data []byte) uint64 {
+	return uint64(crc32.ChecksumIEEE(data))
+}
+
+// Crc32CChecksum implements the Checksum interface with the Castagnoli polynomial
+type Crc32CChecksum struct {
+}
+
+// Sum .
+func (_ Crc32CChecksum) Sum(data []byte) uint64 {
+	return uint64(crc32.Checksum(data, castagnoliTable))
+}
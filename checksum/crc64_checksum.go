@@ -0,0 +1,14 @@
+package checksum
+
+import "hash/crc64"
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// Crc64Checksum implements the Checksum interface
+type Crc64Checksum struct {
+}
+
+// Sum .
+func (_ Crc64Checksum) Sum(data []byte) uint64 {
+	return crc64.Checksum(data, crc64Table)
+}
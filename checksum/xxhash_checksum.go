@@ -0,0 +1,12 @@
+package checksum
+
+import "github.com/cespare/xxhash/v2"
+
+// XXHash64Checksum implements the Checksum interface
+type XXHash64Checksum struct {
+}
+
+// Sum .
+func (_ XXHash64Checksum) Sum(data []byte) uint64 {
+	return xxhash.Sum64(data)
+}
@@ -1,8 +1,14 @@
 package tiny_rpc
 
 import (
+	"crypto/tls"
 	"io"
+	"net"
 	"net/rpc"
+	"strconv"
+	"sync"
+	"time"
+	"tiny_rpc/checksum"
 	"tiny_rpc/codec"
 	"tiny_rpc/compressor"
 	"tiny_rpc/serializer"
@@ -11,6 +17,7 @@ import (
 // Client rpc client based on net/rpc implementation
 type Client struct {
 	*rpc.Client
+	codec rpc.ClientCodec
 }
 
 // Option provides options for rpc
@@ -18,7 +25,211 @@ type Option func(o *options)
 
 type options struct {
 	compressType compressor.CompressType
+	checksumType checksum.ChecksumType
 	serializer   serializer.Serializer
+	connEvents   chan<- ConnEvent
+	tlsConfig    *tls.Config
+	dialTimeout  time.Duration
+
+	maxMessageSize uint32
+	handshake      bool
+	interceptors   []Interceptor
+	panicHandler   PanicHandler
+	batchWindow    time.Duration
+	maxBatch       int
+	respWindow     time.Duration
+	maxRespBatch   int
+
+	maxConns              int
+	serveWorkers          int
+	maxConcurrentRequests int
+	methodRateLimits      map[string]rateLimit
+	allowedMethods        map[string]struct{} // nil allows every method
+	deniedMethods         map[string]struct{}
+	singleflight          map[string]struct{} // methods whose identical calls share their reply
+	requestTimeout        time.Duration
+
+	idleTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	debugFirstRequest bool
+	headerCodec       codec.HeaderCodec
+
+	reconnectBackoff    time.Duration
+	reconnectMaxBackoff time.Duration
+	reconnectAttempts   int
+
+	quarantine time.Duration
+
+	statsHandler codec.StatsHandler
+	logger       Logger
+
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+
+	rejectDuplicateIDs bool
+
+	argsPools map[string]*sync.Pool
+
+	maxPendingRequests int
+
+	healthCheck bool
+	reflection  bool
+
+	maxRequestSize  uint32
+	maxResponseSize uint32
+
+	readBufferSize  int
+	frameBufferSize int
+	streamBacklog   int
+	streamTimeout   time.Duration
+	writeBufferSize int
+	unbuffered      bool
+
+	compressPreference  []compressor.CompressType
+	serializePreference []serializer.SerializeType
+	compressFallback    bool
+	compressDictionary  []byte
+
+	connWrapper    func(net.Conn) net.Conn
+	connAuthorizer func(net.Conn) error
+
+	serviceSerializers map[string]serializer.SerializeType
+	idGenerator        func() uint64
+	registry           *codec.Registry
+	hmacKey            []byte
+	serverName         string
+	padding            int
+	fallbackSerializer serializer.Serializer
+}
+
+// wrapConn apply the connection wrapper, if any
+func (o *options) wrapConn(conn net.Conn) net.Conn {
+	if o.connWrapper == nil {
+		return conn
+	}
+	return o.connWrapper(conn)
+}
+
+// codecOptions collect the options applied by the codecs
+func (o *options) codecOptions() []codec.Option {
+	opts := []codec.Option{
+		codec.WithChecksum(o.checksumType),
+		codec.WithMaxMessageSize(o.maxMessageSize),
+	}
+	if o.handshake {
+		opts = append(opts, codec.WithHandshake())
+	}
+	if o.idleTimeout > 0 {
+		opts = append(opts, codec.WithIdleTimeout(o.idleTimeout))
+	}
+	if o.readTimeout > 0 {
+		opts = append(opts, codec.WithReadTimeout(o.readTimeout))
+	}
+	if o.writeTimeout > 0 {
+		opts = append(opts, codec.WithWriteTimeout(o.writeTimeout))
+	}
+	if o.batchWindow > 0 {
+		opts = append(opts, codec.WithBatching(o.batchWindow, o.maxBatch))
+	}
+	if o.respWindow > 0 {
+		opts = append(opts, codec.WithResponseBatching(o.respWindow, o.maxRespBatch))
+	}
+	if o.headerCodec != nil {
+		opts = append(opts, codec.WithHeaderCodec(o.headerCodec))
+	}
+	if o.compressPreference != nil {
+		opts = append(opts, codec.WithCompressNegotiation(o.compressPreference...))
+	}
+	if o.serializePreference != nil {
+		opts = append(opts, codec.WithSerializerNegotiation(o.serializePreference...))
+	}
+	if o.compressFallback {
+		opts = append(opts, codec.WithCompressFallback())
+	}
+	if o.compressDictionary != nil {
+		opts = append(opts, codec.WithCompressDictionary(o.compressDictionary))
+	}
+	if o.idGenerator != nil {
+		opts = append(opts, codec.WithIDGenerator(o.idGenerator))
+	}
+	if o.registry != nil {
+		opts = append(opts, codec.WithRegistry(o.registry))
+	}
+	if o.hmacKey != nil {
+		opts = append(opts, codec.WithHMACKey(o.hmacKey))
+	}
+	if o.serverName != "" {
+		opts = append(opts, codec.WithServerName(o.serverName))
+	}
+	if o.padding > 0 {
+		opts = append(opts, codec.WithPadding(o.padding))
+	}
+	if o.fallbackSerializer != nil {
+		opts = append(opts, codec.WithFallbackSerializer(o.fallbackSerializer))
+	}
+	for prefix, t := range o.serviceSerializers {
+		opts = append(opts, codec.WithServiceSerializer(prefix, t))
+	}
+	if o.frameBufferSize > 0 {
+		opts = append(opts, codec.WithFrameBuffer(o.frameBufferSize))
+	}
+	if o.streamBacklog > 0 || o.streamTimeout > 0 {
+		opts = append(opts, codec.WithStreamBuffer(o.streamBacklog, o.streamTimeout))
+	}
+	if o.readBufferSize > 0 {
+		opts = append(opts, codec.WithReadBufferSize(o.readBufferSize))
+	}
+	if o.writeBufferSize > 0 {
+		opts = append(opts, codec.WithWriteBufferSize(o.writeBufferSize))
+	}
+	if o.unbuffered {
+		opts = append(opts, codec.WithoutBuffering())
+	}
+	if o.maxRequestSize > 0 {
+		opts = append(opts, codec.WithMaxRequestSize(o.maxRequestSize))
+	}
+	if o.maxResponseSize > 0 {
+		opts = append(opts, codec.WithMaxResponseSize(o.maxResponseSize))
+	}
+	if o.maxPendingRequests > 0 {
+		opts = append(opts, codec.WithMaxPendingRequests(o.maxPendingRequests))
+	}
+	if o.rejectDuplicateIDs {
+		opts = append(opts, codec.WithRejectDuplicateIDs())
+	}
+	if o.keepaliveInterval > 0 {
+		opts = append(opts, codec.WithKeepalive(o.keepaliveInterval, o.keepaliveTimeout))
+	}
+	if o.statsHandler != nil {
+		opts = append(opts, codec.WithStatsHandler(o.statsHandler))
+	}
+	return opts
+}
+
+// WithHeaderCodec set the encoding of the message headers, client and server must use the same one
+func WithHeaderCodec(hc codec.HeaderCodec) Option {
+	return func(o *options) {
+		o.headerCodec = hc
+	}
+}
+
+// WithKeepalive ping the server after the client connection stayed idle for interval,
+// the connection is closed when the pong does not arrive within timeout, interval when not positive.
+// Servers always answer pings
+func WithKeepalive(interval, timeout time.Duration) Option {
+	return func(o *options) {
+		o.keepaliveInterval = interval
+		o.keepaliveTimeout = timeout
+	}
+}
+
+// WithRejectDuplicateIDs close server connections whose client reuses the id of a request still in flight
+func WithRejectDuplicateIDs() Option {
+	return func(o *options) {
+		o.rejectDuplicateIDs = true
+	}
 }
 
 // WithCompress set client compression format
@@ -28,6 +239,184 @@ func WithCompress(c compressor.CompressType) Option {
 	}
 }
 
+// WithCompressNegotiation compress requests with the first of the preferred compressors the server
+// can decompress, or send them uncompressed when it supports none. It enables the handshake, the
+// server must be created WithHandshake
+func WithCompressNegotiation(preferred ...compressor.CompressType) Option {
+	return func(o *options) {
+		o.compressPreference = preferred
+	}
+}
+
+// WithSerializerNegotiation encode requests with the first of the preferred serializers the server
+// can decode, or with the client serializer when it supports none. It enables the handshake, the
+// server must be created WithHandshake
+func WithSerializerNegotiation(preferred ...serializer.SerializeType) Option {
+	return func(o *options) {
+		o.serializePreference = preferred
+	}
+}
+
+// WithCompressFallback send the bodies which compression does not make smaller uncompressed,
+// so that already compressed payloads are not paid for twice
+func WithCompressFallback() Option {
+	return func(o *options) {
+		o.compressFallback = true
+	}
+}
+
+// WithCompressDictionary compress the bodies with dict as preset dictionary, for the compressors
+// implementing compressor.DictCompressor such as Flate. Clients and servers must use the same dictionary
+func WithCompressDictionary(dict []byte) Option {
+	return func(o *options) {
+		o.compressDictionary = dict
+	}
+}
+
+// WithChecksum set client checksum algorithm, the server replies with the same one
+func WithChecksum(c checksum.ChecksumType) Option {
+	return func(o *options) {
+		o.checksumType = c
+	}
+}
+
+// WithoutChecksum disable checksums for trusted local transports, the server replies without one too
+func WithoutChecksum() Option {
+	return func(o *options) {
+		o.checksumType = checksum.None
+	}
+}
+
+// WithTLSConfig encrypt the connection with TLS, the server wraps accepted
+// connections with tls.Server and Dial wraps the dialed connection with tls.Client
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
+
+// WithConnWrapper wrap the connections accepted by Serve and ServeHTTP and the ones dialed by Dial,
+// the wrapper sees the raw connection, below TLS, and the codec uses the connection it returns
+func WithConnWrapper(wrap func(net.Conn) net.Conn) Option {
+	return func(o *options) {
+		o.connWrapper = wrap
+	}
+}
+
+// WithConnAuthorizer check each connection the server serves before any request is read from it,
+// a non-nil error closes the connection and the rejection is logged. The authorizer sees the connection
+// given by the wrapper and TLS, it may call Handshake on a *tls.Conn to inspect the peer certificates
+func WithConnAuthorizer(authorize func(net.Conn) error) Option {
+	return func(o *options) {
+		o.connAuthorizer = authorize
+	}
+}
+
+// WithDialTimeout limit the time Dial waits for the connection and the TLS handshake, zero means no limit
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.dialTimeout = timeout
+	}
+}
+
+// WithReadBufferSize set the size of the buffer reading each connection, zero means the bufio default
+func WithReadBufferSize(size int) Option {
+	return func(o *options) {
+		o.readBufferSize = size
+	}
+}
+
+// WithFrameBuffer read the messages of each connection into a buffer of initially size bytes which
+// grows as needed and is reused across messages, see codec.WithFrameBuffer
+func WithFrameBuffer(size int) Option {
+	return func(o *options) {
+		o.frameBufferSize = size
+	}
+}
+
+// WithStreamBuffer buffer up to frames frames of each streamed reply not read yet, and wait at most
+// timeout for the caller to read a full buffer before the stream is abandoned, see codec.WithStreamBuffer
+func WithStreamBuffer(frames int, timeout time.Duration) Option {
+	return func(o *options) {
+		o.streamBacklog = frames
+		o.streamTimeout = timeout
+	}
+}
+
+// WithWriteBufferSize set the size of the buffer writing each connection, zero means the bufio default
+func WithWriteBufferSize(size int) Option {
+	return func(o *options) {
+		o.writeBufferSize = size
+	}
+}
+
+// WithoutBuffering read and write each connection directly instead of through bufio, which saves
+// a copy for small messages on low latency transports, see codec.WithoutBuffering
+func WithoutBuffering() Option {
+	return func(o *options) {
+		o.unbuffered = true
+	}
+}
+
+// WithMaxMessageSize limit the size of messages sent and received, zero means unlimited,
+// with the handshake enabled both peers enforce the smaller limit of the two
+func WithMaxMessageSize(size uint32) Option {
+	return func(o *options) {
+		o.maxMessageSize = size
+	}
+}
+
+// WithHandshake negotiate connection settings when the connection is set up,
+// both the client and the server must enable it
+func WithHandshake() Option {
+	return func(o *options) {
+		o.handshake = true
+	}
+}
+
+// WithBatching let the client coalesce requests issued within window into a single write,
+// a batch is written early once it holds maxBatch requests, zero means no cap
+func WithBatching(window time.Duration, maxBatch int) Option {
+	return func(o *options) {
+		o.batchWindow = window
+		o.maxBatch = maxBatch
+	}
+}
+
+// WithResponseBatching let the server coalesce the responses ready within window into a single write,
+// trading up to window of latency for fewer writes under pipelined load. A batch is written early
+// once it holds maxBatch responses, zero means no cap
+func WithResponseBatching(window time.Duration, maxBatch int) Option {
+	return func(o *options) {
+		o.respWindow = window
+		o.maxRespBatch = maxBatch
+	}
+}
+
+// WithIdleTimeout close the connection when no message header, nor the handshake, arrives within
+// timeout, it applies to net.Conn connections only
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.idleTimeout = timeout
+	}
+}
+
+// WithReadTimeout close the connection when a body does not arrive within timeout after its header,
+// or the handshake without an idle timeout, detecting stalled peers. It applies to net.Conn connections only
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.readTimeout = timeout
+	}
+}
+
+// WithWriteTimeout close the connection when a message cannot be written within timeout,
+// it applies to net.Conn connections only
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.writeTimeout = timeout
+	}
+}
+
 // WithSerializer set client serializer
 func WithSerializer(serializer serializer.Serializer) Option {
 	return func(o *options) {
@@ -35,26 +424,249 @@ func WithSerializer(serializer serializer.Serializer) Option {
 	}
 }
 
+// WithServiceSerializer encode the calls of the methods starting with prefix, "Service." for instance,
+// with the registered serializer t rather than the client serializer, the longest matching prefix wins
+func WithServiceSerializer(prefix string, t serializer.SerializeType) Option {
+	return func(o *options) {
+		if o.serviceSerializers == nil {
+			o.serviceSerializers = make(map[string]serializer.SerializeType)
+		}
+		o.serviceSerializers[prefix] = t
+	}
+}
+
+// WithIDGenerator write ids from gen into the request headers of the client instead of the sequence
+// numbers of the connection, which restart with each connection. The server echoes the ids, gen must
+// be safe for concurrent use and must not return an id still awaiting its response
+func WithIDGenerator(gen func() uint64) Option {
+	return func(o *options) {
+		o.idGenerator = gen
+	}
+}
+
+// WithHMACKey authenticate every message with an HMAC-SHA256 computed with key, messages whose MAC
+// does not match fail with codec.AuthenticationError. The server closes the connection of a request
+// failing it without answering. Client and server must use the same key
+func WithHMACKey(key []byte) Option {
+	return func(o *options) {
+		o.hmacKey = key
+	}
+}
+
+// WithPadding pad the bodies sent to size buckets, the powers of two up to max bytes and the multiples
+// of max beyond, so that their length leaks less through traffic analysis, see codec.WithPadding.
+// Client and server must both run a version which strips the padding
+func WithPadding(max int) Option {
+	return func(o *options) {
+		o.padding = max
+	}
+}
+
+// WithRegistry use the compressors and serializers of r instead of the package registries,
+// see codec.NewRegistry and codec.DefaultRegistry
+func WithRegistry(r *codec.Registry) Option {
+	return func(o *options) {
+		o.registry = r
+	}
+}
+
+// newOptions apply opts over the defaults, client and server start from the same defaults
+// so that one option slice configures both ends alike
+func newOptions(opts []Option) options {
+	o := options{
+		compressType:        compressor.Raw,
+		checksumType:        checksum.Crc32,
+		serializer:          serializer.Proto,
+		logger:              stdLogger{},
+		quarantine:          defaultQuarantine,
+		reconnectBackoff:    defaultReconnectBackoff,
+		reconnectMaxBackoff: defaultReconnectMaxBackoff,
+		reconnectAttempts:   defaultReconnectAttempts,
+	}
+	for _, option := range opts {
+		option(&o)
+	}
+	return o
+}
+
 // NewClient Create a new rpc client
 func NewClient(conn io.ReadWriteCloser, opts ...Option) *Client {
-	options := options{
-		compressType: compressor.Raw,
-		serializer:   serializer.Proto,
+	options := newOptions(opts)
+	c := codec.NewClientCodec(conn, options.compressType, options.serializer, options.codecOptions()...)
+	return &Client{Client: rpc.NewClientWithCodec(c), codec: c}
+}
+
+// Closed report whether the connection is shut down, later calls fail with rpc.ErrShutdown
+func (c *Client) Closed() bool {
+	if i, ok := c.codec.(interface{ Closed() bool }); ok {
+		return i.Closed()
+	}
+	return false
+}
+
+// ConnInfo get the connection settings in effect, negotiated at handshake when it is enabled
+func (c *Client) ConnInfo() codec.ConnInfo {
+	return connInfo(c.codec)
+}
+
+// Traffic get the bytes the client read from and wrote to its connection so far
+func (c *Client) Traffic() codec.Traffic {
+	return connTraffic(c.codec)
+}
+
+// connTraffic get the bytes read and written by a codec created by this package
+func connTraffic(c interface{}) codec.Traffic {
+	if i, ok := c.(interface{ Traffic() codec.Traffic }); ok {
+		return i.Traffic()
+	}
+	return codec.Traffic{}
+}
+
+// connInfo get the connection settings of a codec created by this package
+func connInfo(c interface{}) codec.ConnInfo {
+	if i, ok := c.(interface{ ConnInfo() codec.ConnInfo }); ok {
+		return i.ConnInfo()
 	}
+	return codec.ConnInfo{}
+}
+
+// CallOption provides per-call options carried in the request metadata
+type CallOption func(p *codec.Param)
+
+// WithResponseSerializer ask the server to encode the reply of this call with the given serializer
+func WithResponseSerializer(t serializer.SerializeType) CallOption {
+	return func(p *codec.Param) {
+		p.Metadata[codec.MetaResponseSerializer] = strconv.Itoa(int(t))
+	}
+}
 
+// WithCallSerializer encode the args of this call with the registered serializer t, the server
+// replies with it too unless WithResponseSerializer asks otherwise
+func WithCallSerializer(t serializer.SerializeType) CallOption {
+	return func(p *codec.Param) {
+		p.SerializeType = t
+	}
+}
+
+// WithResponseSizeLimit ask the server to fail the call with codec.ResponseTooLargeError
+// instead of sending a compressed reply larger than limit bytes
+func WithResponseSizeLimit(limit uint32) CallOption {
+	return func(p *codec.Param) {
+		p.Metadata[codec.MetaMaxResponseSize] = strconv.FormatUint(uint64(limit), 10)
+	}
+}
+
+// wrapArgs attach the per-call options to args
+func wrapArgs(args interface{}, opts []CallOption) interface{} {
+	if len(opts) == 0 {
+		return args
+	}
+	p := &codec.Param{Value: args, Metadata: make(map[string]string)}
 	for _, option := range opts {
-		option(&options)
+		option(p)
 	}
+	return p
+}
+
+// Dial connects to the rpc server at the address and creates a client on the connection
+func Dial(network, address string, opts ...Option) (*Client, error) {
+	conn, err := dial(network, address, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, opts...), nil
+}
 
-	return &Client{rpc.NewClientWithCodec(codec.NewClientCodec(conn, options.compressType, options.serializer))}
+// DialTimeout acts like Dial but gives up connecting after timeout
+func DialTimeout(network, address string, timeout time.Duration, opts ...Option) (*Client, error) {
+	return Dial(network, address, append(opts[:len(opts):len(opts)], WithDialTimeout(timeout))...)
+}
+
+// dial connect to address, performing the TLS handshake when a TLS config is set
+func dial(network, address string, opts []Option) (net.Conn, error) {
+	options := newOptions(opts)
+	conn, err := net.DialTimeout(network, address, options.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn = options.wrapConn(conn)
+	if options.tlsConfig != nil {
+		config := options.tlsConfig
+		// 与 tls.Dial 一致，未指定 ServerName 时使用地址中的主机名
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		tlsConn := tls.Client(conn, config)
+		// 握手同样受连接超时的限制
+		if options.dialTimeout > 0 {
+			conn.SetDeadline(time.Now().Add(options.dialTimeout))
+		}
+		// 握手失败时立即返回错误，而不是推迟到第一次调用
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+	return conn, nil
 }
 
 // Call synchronously calls the rpc function
-func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
-	return c.Client.Call(serviceMethod, args, reply)
+func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}, opts ...CallOption) error {
+	return convertError(c.Client.Call(serviceMethod, wrapArgs(args, opts), reply))
 }
 
 // AsyncCall asynchronously calls the rpc function and returns a channel of *rpc.Call
-func (c *Client) AsyncCall(serviceMethod string, args interface{}, reply interface{}) chan *rpc.Call {
-	return c.Go(serviceMethod, args, reply, nil).Done
+func (c *Client) AsyncCall(serviceMethod string, args interface{}, reply interface{}, opts ...CallOption) chan *rpc.Call {
+	done := make(chan *rpc.Call, 1)
+	call := c.Go(serviceMethod, wrapArgs(args, opts), reply, make(chan *rpc.Call, 1))
+	// 与 Call 一致，将服务端错误转换为具体的错误类型
+	go func() {
+		<-call.Done
+		call.Error = convertError(call.Error)
+		done <- call
+	}()
+	return done
+}
+
+// CallOneway send a request without waiting for its reply, the server runs the method but
+// sends no response, so only errors writing the request are returned
+func (c *Client) CallOneway(serviceMethod string, args interface{}, opts ...CallOption) error {
+	if c.Closed() {
+		return rpc.ErrShutdown
+	}
+	p := &codec.Param{Value: args, Metadata: make(map[string]string), Oneway: true}
+	for _, option := range opts {
+		option(p)
+	}
+	// 不经过 rpc.Client，请求不会等待响应，ID 也无需唯一
+	return c.codec.WriteRequest(&rpc.Request{ServiceMethod: serviceMethod}, p)
+}
+
+// BatchCall a call issued by Batch
+type BatchCall struct {
+	ServiceMethod string
+	Args          interface{}
+	Reply         interface{}
+	Opts          []CallOption
+}
+
+// Batch issue the calls back to back without waiting for their replies, and return
+// the completed *rpc.Call of each of them in the same order once all replies arrived
+func (c *Client) Batch(calls []BatchCall) []*rpc.Call {
+	done := make(chan *rpc.Call, len(calls))
+	results := make([]*rpc.Call, len(calls))
+	for i, call := range calls {
+		results[i] = c.Go(call.ServiceMethod, wrapArgs(call.Args, call.Opts), call.Reply, done)
+	}
+	// 响应按 Seq 分发，到达顺序不影响结果顺序
+	for range calls {
+		<-done
+	}
+	for _, call := range results {
+		call.Error = convertError(call.Error)
+	}
+	return results
 }
@@ -0,0 +1,637 @@
+package tiny_rpc
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tiny_rpc/checksum"
+	"tiny_rpc/codec"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+	jsonpb "tiny_rpc/test.data/json"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClient_ResponseSerializer .
+func TestClient_ResponseSerializer(t *testing.T) {
+	_, listener := startServer(t)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	client := NewClient(conn)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	err = client.Call("ArithService.Mul", &pb.ArithRequest{A: 2, B: 4}, reply,
+		WithResponseSerializer(serializer.JSONType))
+	assert.Nil(t, err)
+	assert.Equal(t, 8.0, reply.C)
+
+	// 不指定时沿用连接的序列化器
+	reply = &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Mul", &pb.ArithRequest{A: 2, B: 5}, reply))
+	assert.Equal(t, 10.0, reply.C)
+}
+
+// TestClient_MixedSerializers .
+func TestClient_MixedSerializers(t *testing.T) {
+	_, listener := startServer(t)
+
+	var wg sync.WaitGroup
+	for _, s := range []serializer.Serializer{serializer.Proto, serializer.JSON} {
+		client, err := Dial("tcp", listener.Addr().String(), WithSerializer(s))
+		assert.Nil(t, err)
+		defer client.Close()
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				reply := &pb.ArithResponse{}
+				assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 1}, reply))
+				assert.Equal(t, float64(i+1), reply.C)
+			}(i)
+		}
+	}
+	wg.Wait()
+}
+
+// TestClient_ServiceSerializer .
+func TestClient_ServiceSerializer(t *testing.T) {
+	server, listener := startServer(t)
+	assert.Nil(t, server.Register(new(jsonpb.TestService)))
+
+	// 同一连接上 TestService 使用 JSON，其余服务使用 Protobuf
+	client, err := Dial("tcp", listener.Addr().String(),
+		WithServiceSerializer("TestService.", serializer.JSONType))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			reply := &jsonpb.Response{}
+			assert.Nil(t, client.Call("TestService.Mul", &jsonpb.Request{A: float64(i), B: 2}, reply))
+			assert.Equal(t, float64(i*2), reply.C)
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			reply := &pb.ArithResponse{}
+			assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 1}, reply))
+			assert.Equal(t, float64(i+1), reply.C)
+		}(i)
+	}
+	wg.Wait()
+
+	// 单次调用指定的序列化器优先
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Sub", &pb.ArithRequest{A: 5, B: 2}, reply,
+		WithCallSerializer(serializer.JSONType)))
+	assert.Equal(t, 3.0, reply.C)
+	err = client.Call("ArithService.Sub", &pb.ArithRequest{}, reply, WithCallSerializer(200))
+	assert.Equal(t, codec.NotFoundSerializerError, err)
+}
+
+// TestClient_CompressDictionary .
+func TestClient_CompressDictionary(t *testing.T) {
+	dict := []byte("ArithRequest ArithResponse")
+	_, listener := startServer(t, WithCompressDictionary(dict))
+
+	client, err := Dial("tcp", listener.Addr().String(), WithCompress(compressor.Flate), WithCompressDictionary(dict))
+	assert.Nil(t, err)
+	defer client.Close()
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Mul", &pb.ArithRequest{A: 3, B: 4}, reply))
+	assert.Equal(t, 12.0, reply.C)
+
+	// 客户端未使用字典时服务端无法解压
+	other, err := Dial("tcp", listener.Addr().String(), WithCompress(compressor.Flate))
+	assert.Nil(t, err)
+	defer other.Close()
+	err = other.Call("ArithService.Mul", &pb.ArithRequest{A: 3, B: 4}, reply)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), compressor.DictionaryMismatchError.Error())
+}
+
+// TestClient_RawCompressor .
+func TestClient_RawCompressor(t *testing.T) {
+	_, listener := startServer(t)
+
+	client, err := Dial("tcp", listener.Addr().String(), WithCompress(compressor.Raw))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+
+	// 零值消息编码为空的请求体和响应体
+	reply = &pb.ArithResponse{C: 1}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{}, reply))
+	assert.Equal(t, 0.0, reply.C)
+}
+
+// TestClient_NilArgs .
+func TestClient_NilArgs(t *testing.T) {
+	_, listener := startServer(t)
+	client, err := Dial("tcp", listener.Addr().String(), WithCompress(compressor.Gzip), WithChecksum(checksum.XXHash64))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{C: 1}
+	assert.Nil(t, client.Call("ArithService.Add", (*pb.ArithRequest)(nil), reply))
+	assert.Equal(t, 0.0, reply.C)
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// BlobService relays already encoded payloads
+type BlobService struct{}
+
+// Reverse .
+func (_ *BlobService) Reverse(args []byte, reply *[]byte) error {
+	for i := len(args) - 1; i >= 0; i-- {
+		*reply = append(*reply, args[i])
+	}
+	return nil
+}
+
+// TestClient_RawSerializer .
+func TestClient_RawSerializer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := NewServer(WithSerializer(serializer.Raw))
+	assert.Nil(t, server.Register(new(BlobService)))
+	go server.Serve(listener)
+
+	client, err := Dial("tcp", listener.Addr().String(), WithSerializer(serializer.Raw))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply []byte
+	assert.Nil(t, client.Call("BlobService.Reverse", []byte{1, 2, 3}, &reply))
+	assert.Equal(t, []byte{3, 2, 1}, reply)
+
+	err = client.Call("BlobService.Reverse", "not bytes", &reply)
+	assert.Equal(t, serializer.NotBytesError, err)
+}
+
+// EchoService echoes the string it receives
+type EchoService struct{}
+
+// Echo .
+func (_ *EchoService) Echo(args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+// TestClient_NegotiateMaxMessageSize .
+func TestClient_NegotiateMaxMessageSize(t *testing.T) {
+	events := make(chan ConnEvent, 8)
+	server, listener := startServer(t, WithSerializer(serializer.JSON), WithHandshake(),
+		WithMaxMessageSize(64), WithConnectionEvents(events))
+	assert.Nil(t, server.Register(new(EchoService)))
+
+	client, err := Dial("tcp", listener.Addr().String(), WithSerializer(serializer.JSON), WithHandshake(),
+		WithMaxMessageSize(1<<20))
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Equal(t, uint32(64), client.ConnInfo().MaxMessageSize)
+
+	nextEvent(t, events)
+	handshake := nextEvent(t, events)
+	assert.Equal(t, HandshakeCompleted, handshake.Type)
+	assert.Equal(t, uint32(64), handshake.ConnInfo.MaxMessageSize)
+
+	var reply string
+	assert.Nil(t, client.Call("EchoService.Echo", "hello", &reply))
+	assert.Equal(t, "hello", reply)
+
+	// 客户端自身允许 1MB，但协商后的限制使其在发送前拒绝
+	err = client.Call("EchoService.Echo", strings.Repeat("a", 100), &reply)
+	assert.Equal(t, codec.MessageTooLargeError, err)
+
+	assert.Nil(t, client.Call("EchoService.Echo", "world", &reply))
+	assert.Equal(t, "world", reply)
+}
+
+// TestClient_ResponseSizeLimit .
+func TestClient_ResponseSizeLimit(t *testing.T) {
+	server, listener := startServer(t, WithSerializer(serializer.JSON))
+	assert.Nil(t, server.Register(new(EchoService)))
+
+	client, err := Dial("tcp", listener.Addr().String(), WithSerializer(serializer.JSON))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	err = client.Call("EchoService.Echo", strings.Repeat("a", 100), &reply, WithResponseSizeLimit(64))
+	assert.Equal(t, codec.ResponseTooLargeError, err)
+	assert.Equal(t, "", reply)
+
+	// 限制只作用于声明它的调用
+	assert.Nil(t, client.Call("EchoService.Echo", strings.Repeat("a", 100), &reply))
+	assert.Equal(t, strings.Repeat("a", 100), reply)
+	assert.Nil(t, client.Call("EchoService.Echo", "small", &reply, WithResponseSizeLimit(64)))
+	assert.Equal(t, "small", reply)
+}
+
+// TestClient_Batch .
+func TestClient_Batch(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	client := NewClient(clientConn)
+	defer client.Close()
+
+	const n = 10
+	// 服务端读取全部请求后按相反顺序回复
+	go func() {
+		server := codec.NewServerCodec(serverConn, serializer.Proto)
+		requests := make([]*rpc.Request, n)
+		args := make([]*pb.ArithRequest, n)
+		for i := 0; i < n; i++ {
+			requests[i], args[i] = &rpc.Request{}, &pb.ArithRequest{}
+			if server.ReadRequestHeader(requests[i]) != nil || server.ReadRequestBody(args[i]) != nil {
+				return
+			}
+		}
+		for i := n - 1; i >= 0; i-- {
+			reply := &pb.ArithResponse{C: args[i].A + args[i].B}
+			server.WriteResponse(&rpc.Response{ServiceMethod: requests[i].ServiceMethod, Seq: requests[i].Seq}, reply)
+		}
+	}()
+
+	calls := make([]BatchCall, n)
+	for i := range calls {
+		calls[i] = BatchCall{
+			ServiceMethod: "ArithService.Add",
+			Args:          &pb.ArithRequest{A: float64(i), B: 100},
+			Reply:         &pb.ArithResponse{},
+		}
+	}
+	results := client.Batch(calls)
+	assert.Len(t, results, n)
+	for i, call := range results {
+		assert.Nil(t, call.Error)
+		assert.Equal(t, calls[i].Reply, call.Reply)
+		assert.Equal(t, float64(i)+100, call.Reply.(*pb.ArithResponse).C)
+	}
+}
+
+// countingConn counts the writes issued on the connection
+type countingConn struct {
+	net.Conn
+	writes int64
+}
+
+func (c *countingConn) Write(data []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.Conn.Write(data)
+}
+
+// dialCounting dial the listener and count the writes of the client
+func dialCounting(t testing.TB, listener net.Listener, opts ...Option) (*Client, *countingConn) {
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	counting := &countingConn{Conn: conn}
+	return NewClient(counting, opts...), counting
+}
+
+// goCalls fire n asynchronous calls and wait for all of them
+func goCalls(t testing.TB, client *Client, n int) {
+	calls := make([]*rpc.Call, n)
+	for i := range calls {
+		calls[i] = client.Go("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 1}, &pb.ArithResponse{}, nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		assert.Nil(t, call.Error)
+		assert.Equal(t, float64(i+1), call.Reply.(*pb.ArithResponse).C)
+	}
+}
+
+// TestClient_Batching .
+func TestClient_Batching(t *testing.T) {
+	_, listener := startServer(t)
+
+	client, conn := dialCounting(t, listener, WithBatching(20*time.Millisecond, 0))
+	defer client.Close()
+	goCalls(t, client, 50)
+	assert.Less(t, atomic.LoadInt64(&conn.writes), int64(50))
+
+	// 达到批量上限时立即发送，不等待窗口结束
+	client, conn = dialCounting(t, listener, WithBatching(time.Hour, 10))
+	defer client.Close()
+	goCalls(t, client, 50)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&conn.writes))
+}
+
+// BenchmarkClient_Batching .
+func BenchmarkClient_Batching(b *testing.B) {
+	_, listener := startServer(b)
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{"immediate", nil},
+		{"batched", []Option{WithBatching(time.Millisecond, 64)}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			client, conn := dialCounting(b, listener, c.opts...)
+			defer client.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				goCalls(b, client, 64)
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&conn.writes))/float64(b.N), "writes/op")
+		})
+	}
+}
+
+// BenchmarkClient_ParallelCall .
+func BenchmarkClient_ParallelCall(b *testing.B) {
+	_, listener := startServer(b)
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(b, err)
+	defer client.Close()
+
+	b.ReportAllocs()
+	b.RunParallel(func(p *testing.PB) {
+		args := &pb.ArithRequest{A: 1, B: 2}
+		for p.Next() {
+			if err := client.Call("ArithService.Add", args, &pb.ArithResponse{}); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// TestClient_CallOneway .
+func TestClient_CallOneway(t *testing.T) {
+	block := newBlockService()
+	server, listener := startServer(t)
+	assert.Nil(t, server.Register(block))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// 处理函数阻塞时调用也立即返回
+	assert.Nil(t, client.CallOneway("BlockService.Wait", &pb.ArithRequest{A: 1}))
+	select {
+	case <-block.entered:
+	case <-time.After(time.Second):
+		t.Fatal("oneway call not handled")
+	}
+	close(block.release)
+
+	// 服务端没有回复单向请求，后续调用不受影响
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+
+	client.Close()
+	assert.Equal(t, rpc.ErrShutdown, client.CallOneway("BlockService.Wait", &pb.ArithRequest{A: 1}))
+}
+
+// TestDialTimeout .
+func TestDialTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	// 不可路由的地址，连接超时或立即失败，有透明代理的网络中也可能连接成功，但都不会一直阻塞
+	start := time.Now()
+	client, err := DialTimeout("tcp", "10.255.255.1:80", timeout)
+	if err == nil {
+		client.Close()
+	}
+	assert.Less(t, time.Since(start), timeout+time.Second)
+
+	// 接受连接但从不应答的对端使 TLS 握手挂起
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	start = time.Now()
+	_, err = Dial("tcp", listener.Addr().String(), WithTLSConfig(&tls.Config{}), WithDialTimeout(timeout))
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), timeout+time.Second)
+}
+
+// TestClient_CompressNegotiation .
+func TestClient_CompressNegotiation(t *testing.T) {
+	// 服务端未注册的压缩格式
+	const zstd = compressor.CompressType(100)
+	_, listener := startServer(t, WithHandshake())
+	cases := []struct {
+		name      string
+		preferred []compressor.CompressType
+		expect    compressor.CompressType
+	}{
+		{"test-1", []compressor.CompressType{zstd, compressor.Gzip}, compressor.Gzip},
+		{"test-2", []compressor.CompressType{compressor.Snappy, compressor.Gzip}, compressor.Snappy},
+		{"test-3", []compressor.CompressType{zstd}, compressor.Raw},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, err := Dial("tcp", listener.Addr().String(), WithCompressNegotiation(c.preferred...))
+			assert.Nil(t, err)
+			defer client.Close()
+			assert.Equal(t, c.expect, client.ConnInfo().CompressType)
+			assert.Contains(t, client.ConnInfo().Compressors, compressor.Gzip)
+			assert.Contains(t, client.ConnInfo().Serializers, serializer.ProtoType)
+
+			reply := &pb.ArithResponse{}
+			assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+			assert.Equal(t, 3.0, reply.C)
+		})
+	}
+
+	// 客户端未注册的压缩格式同样跳过
+	registry := codec.NewRegistry().RegisterCompressor(compressor.Gzip, compressor.GzipCompressor{}).
+		RegisterSerializer(serializer.ProtoType, serializer.Proto)
+	client, err := Dial("tcp", listener.Addr().String(), WithRegistry(registry),
+		WithCompressNegotiation(compressor.Snappy, compressor.Gzip))
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Equal(t, compressor.Gzip, client.ConnInfo().CompressType)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// TestClient_SerializerNegotiation .
+func TestClient_SerializerNegotiation(t *testing.T) {
+	_, listener := startServer(t, WithHandshake())
+	// 服务端只能解码 Proto
+	protoOnly := codec.NewRegistry().RegisterSerializer(serializer.ProtoType, serializer.Proto)
+	_, protoListener := startServer(t, WithHandshake(), WithRegistry(protoOnly))
+	cases := []struct {
+		name      string
+		addr      string
+		preferred []serializer.SerializeType
+		expect    serializer.SerializeType
+	}{
+		{"test-1", listener.Addr().String(), []serializer.SerializeType{serializer.JSONType, serializer.ProtoType}, serializer.JSONType},
+		{"test-2", protoListener.Addr().String(), []serializer.SerializeType{serializer.JSONType, serializer.ProtoType}, serializer.ProtoType},
+		{"test-3", protoListener.Addr().String(), []serializer.SerializeType{serializer.JSONType}, serializer.ProtoType},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, err := Dial("tcp", c.addr, WithSerializerNegotiation(c.preferred...))
+			assert.Nil(t, err)
+			defer client.Close()
+			assert.Equal(t, c.expect, client.ConnInfo().SerializeType)
+
+			reply := &pb.ArithResponse{}
+			assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+			assert.Equal(t, 3.0, reply.C)
+		})
+	}
+}
+
+// byteCountingConn counts the bytes read and written on the connection
+type byteCountingConn struct {
+	net.Conn
+	read, written *int64
+}
+
+func (c *byteCountingConn) Read(data []byte) (int, error) {
+	n, err := c.Conn.Read(data)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+func (c *byteCountingConn) Write(data []byte) (int, error) {
+	n, err := c.Conn.Write(data)
+	atomic.AddInt64(c.written, int64(n))
+	return n, err
+}
+
+// TestConnWrapper .
+func TestConnWrapper(t *testing.T) {
+	var serverRead, serverWritten, clientRead, clientWritten, wrapped int64
+	_, listener := startServer(t, WithConnWrapper(func(conn net.Conn) net.Conn {
+		atomic.AddInt64(&wrapped, 1)
+		return &byteCountingConn{Conn: conn, read: &serverRead, written: &serverWritten}
+	}))
+	client, err := Dial("tcp", listener.Addr().String(), WithConnWrapper(func(conn net.Conn) net.Conn {
+		return &byteCountingConn{Conn: conn, read: &clientRead, written: &clientWritten}
+	}))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+
+	// 两端统计的字节数一致
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&serverWritten) == atomic.LoadInt64(&clientRead) &&
+			atomic.LoadInt64(&serverRead) == atomic.LoadInt64(&clientWritten)
+	}, time.Second, 10*time.Millisecond)
+	assert.Greater(t, atomic.LoadInt64(&clientWritten), int64(0))
+	assert.Greater(t, atomic.LoadInt64(&clientRead), int64(0))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&wrapped))
+}
+
+// TestSharedOptions .
+func TestSharedOptions(t *testing.T) {
+	shared := []Option{
+		WithSerializer(serializer.JSON),
+		WithCompress(compressor.Gzip),
+		WithReadBufferSize(1024),
+		WithWriteBufferSize(2048),
+		WithMaxMessageSize(1 << 16),
+		WithMaxPendingRequests(8),
+	}
+
+	// 两端从同一组默认值出发，得到相同的配置
+	clientOptions, serverOptions := newOptions(shared), newOptions(shared)
+	assert.Equal(t, serializer.JSON, clientOptions.serializer)
+	assert.Equal(t, compressor.Gzip, clientOptions.compressType)
+	assert.Equal(t, len(clientOptions.codecOptions()), len(serverOptions.codecOptions()))
+	for _, o := range []options{clientOptions, serverOptions} {
+		assert.Equal(t, clientOptions.serializer, o.serializer)
+		assert.Equal(t, clientOptions.compressType, o.compressType)
+		assert.Equal(t, clientOptions.checksumType, o.checksumType)
+		assert.Equal(t, 1024, o.readBufferSize)
+		assert.Equal(t, 2048, o.writeBufferSize)
+		assert.Equal(t, uint32(1<<16), o.maxMessageSize)
+		assert.Equal(t, 8, o.maxPendingRequests)
+	}
+
+	server, listener := startServer(t, shared...)
+	assert.Equal(t, serializer.JSON, server.Serializer)
+	client, err := Dial("tcp", listener.Addr().String(), shared...)
+	assert.Nil(t, err)
+	defer client.Close()
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Mul", &pb.ArithRequest{A: 6, B: 7}, reply))
+	assert.Equal(t, 42.0, reply.C)
+}
+
+// TestClient_HMACKey .
+func TestClient_HMACKey(t *testing.T) {
+	key := []byte("shared secret")
+	_, listener := startServer(t, WithHMACKey(key))
+
+	client, err := Dial("tcp", listener.Addr().String(), WithHMACKey(key), WithCompress(compressor.Gzip))
+	assert.Nil(t, err)
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 2}, reply))
+		assert.Equal(t, float64(i+2), reply.C)
+	}
+	// 方法返回的错误同样经过签名
+	err = client.Call("ArithService.Div", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), codec.AuthenticationError.Error())
+
+	other, err := Dial("tcp", listener.Addr().String(), WithHMACKey([]byte("other key")))
+	assert.Nil(t, err)
+	defer other.Close()
+	err = other.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.NotNil(t, err)
+
+	// 未通过验证的请求即便方法不存在也不会得到回复，服务端直接关闭连接
+	other, err = Dial("tcp", listener.Addr().String(), WithHMACKey([]byte("other key")))
+	assert.Nil(t, err)
+	defer other.Close()
+	err = other.Call("ArithService.Missing", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.NotNil(t, err)
+	var notFound *MethodNotFoundError
+	assert.False(t, errors.As(err, &notFound))
+}
+
+// TestClient_Padding .
+func TestClient_Padding(t *testing.T) {
+	_, listener := startServer(t, WithPadding(256))
+	client, err := Dial("tcp", listener.Addr().String(), WithPadding(256), WithCompress(compressor.Gzip))
+	assert.Nil(t, err)
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 2}, reply))
+		assert.Equal(t, float64(i+2), reply.C)
+	}
+	// 错误响应没有响应体，不补齐
+	err = client.Call("ArithService.Div", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,30 @@
+package codec
+
+import "tiny_rpc/checksum"
+
+// sum compute the checksum of data, a zero checksum is written for checksum.None
+func sum(checksumType checksum.ChecksumType, data []byte) (uint64, error) {
+	if checksumType == checksum.None {
+		return 0, nil
+	}
+	cs, ok := checksum.Checksums[checksumType]
+	if !ok {
+		return 0, NotFoundChecksumError
+	}
+	return cs.Sum(data), nil
+}
+
+// verify check the checksum of data, a zero checksum means the peer skipped it
+func verify(checksumType checksum.ChecksumType, data []byte, expected uint64) error {
+	if expected == 0 || checksumType == checksum.None {
+		return nil
+	}
+	cs, ok := checksum.Checksums[checksumType]
+	if !ok {
+		return NotFoundChecksumError
+	}
+	if cs.Sum(data) != expected {
+		return UnexpectedChecksumError
+	}
+	return nil
+}
@@ -1,11 +1,13 @@
 package codec
 
 import (
-	"bufio"
-	"hash/crc32"
+	"errors"
 	"io"
 	"net/rpc"
 	"sync"
+	"sync/atomic"
+	"time"
+	"tiny_rpc/checksum"
 	"tiny_rpc/compressor"
 	"tiny_rpc/header"
 	"tiny_rpc/serializer"
@@ -16,46 +18,208 @@ type clientCodec struct {
 	writer io.Writer
 	closer io.Closer
 
-	compressor compressor.CompressType // rpc compress type
-	serializer serializer.Serializer
-	response   header.ResponseHeader // response header
-	mutex      sync.Mutex            // protect pending map
-	pending    map[uint64]string
+	counter *byteCounter // bytes read and written through reader and writer
+
+	compressor    compressor.CompressType // rpc compress type
+	checksum      checksum.ChecksumType   // rpc checksum type
+	serializer    serializer.Serializer
+	encoder       *encoder                            // marshals the requests when serializer is resettable
+	serializeType serializer.SerializeType            // declared in the request headers, zero if s is not registered
+	byService     map[string]serializer.SerializeType // serializers of the service prefixes
+	newID         func() uint64                       // generates the request ids, nil to use the sequence numbers
+	response      header.ResponseHeader               // response header
+	expected      serializer.SerializeType            // serializer the current response should use, zero if unknown
+	pending       pendingMap[pendingCall]
+	outstanding   atomic.Int64 // requests awaiting their response, counted when maxPending is set
+	maxPending    int
+	fallback      bool        // send bodies compression does not shrink raw
+	dict          *dictionary // preset dictionary of the compressors, empty if none configured
+	closed        atomic.Bool // responses can no longer be read
+
+	info            ConnInfo // settings in effect
+	maxResponseSize uint32   // limit of the declared response body size, zero if unlimited
+	err             error    // handshake error, fails every later call
+	broken          error    // read error, only accessed by the reading goroutine
+	deadline        deadline
+	headers         HeaderCodec
+	signer          *signer      // signs the requests and verifies the responses, nil without a key
+	padder          *padder      // pads the request bodies, nil without padding
+	verifier        *verifier    // verifies the current response, only accessed by the reading goroutine
+	verifyBuf       []byte       // scratch of the response headers being verified, only accessed by the reading goroutine
+	frames          *frameBuffer // buffer the responses are read into, nil to use the pools
+
+	// bodies being streamed by response id, frames of nil streams are discarded, only accessed by the reading goroutine
+	streams       map[uint64]*bodyStream
+	streamBacklog int
+	streamTimeout time.Duration
+
+	wmutex      sync.Mutex // protects writer against the batch timer, and the scratch buffers
+	headerBuf   []byte     // scratch of the encoded request headers, protected by wmutex
+	scratch     scratch    // buffers of the encoded request bodies, protected by wmutex
+	batchWindow time.Duration
+	maxBatch    int
+	batched     int         // requests written since the last flush
+	timer       *time.Timer // flushes the current batch when the window ends
+
+	lastActive atomic.Int64  // unix nano of the last message written or read
+	pong       chan struct{} // receives the pongs awaited by the keepalive goroutine
+	done       chan struct{} // closed by Close
+	closeOnce  sync.Once
+}
+
+// pendingCall a request waiting for its response
+type pendingCall struct {
+	method        string
+	seq           uint64                   // sequence number of the call in rpc.Client
+	onChunk       func(Chunk)              // chunk callback of a streaming call
+	serializeType serializer.SerializeType // serializer expected for the reply
 }
 
 // NewClientCodec Create a new client codec
-func NewClientCodec(conn io.ReadWriteCloser, compressType compressor.CompressType, serializer serializer.Serializer) rpc.ClientCodec {
-	return &clientCodec{
-		reader:     bufio.NewReader(conn),
-		writer:     bufio.NewWriter(conn),
-		closer:     conn,
-		compressor: compressType,
-		serializer: serializer,
-		pending:    make(map[uint64]string),
+func NewClientCodec(conn io.ReadWriteCloser, compressType compressor.CompressType, s serializer.Serializer, opts ...Option) rpc.ClientCodec {
+	options := newOptions(opts)
+	counter := &byteCounter{}
+	c := &clientCodec{
+		reader:        options.newReader(counter.reader(conn)),
+		writer:        options.newWriter(counter.writer(conn)),
+		counter:       counter,
+		closer:        conn,
+		compressor:    compressType,
+		checksum:      options.checksumType,
+		serializer:    s,
+		encoder:       newEncoder(s),
+		serializeType: options.registry.typeOf(s),
+		byService:     options.serviceSerializers,
+		newID:         options.idGenerator,
+		info:          ConnInfo{MaxMessageSize: options.maxMessageSize},
+		deadline:      newDeadline(conn, options),
+		headers:       options.headerCodec,
+		signer:        newSigner(options.hmacKey),
+		padder:        newPadder(options.padding),
+		frames:        newFrameBuffer(options.frameBufferSize),
+		streamBacklog: options.streamBacklog,
+		streamTimeout: options.streamTimeout,
+
+		maxPending:      options.maxPending,
+		fallback:        options.compressFallback,
+		dict:            newDictionary(options.registry, options.dictionary),
+		maxResponseSize: options.maxResponseSize,
+		batchWindow:     options.batchWindow,
+		maxBatch:        options.maxBatch,
+
+		pong: make(chan struct{}, 1),
+		done: make(chan struct{}),
 	}
+	if options.handshake {
+		c.deadline.handshake()
+		c.info, c.err = clientHandshake(c.reader, c.writer, c.info, options.registry)
+		if c.err == nil && options.compressPreference != nil {
+			// 只使用服务端能够解压的压缩格式
+			c.compressor = negotiateCompressor(options.compressPreference, c.info.Compressors, options.registry)
+		}
+		if c.err == nil && options.serializePreference != nil {
+			// 服务端无法解码任何偏好的序列化格式时沿用创建时的序列化器
+			if t, s, ok := negotiateSerializer(options.serializePreference, c.info.Serializers, options.registry); ok {
+				c.serializer, c.serializeType, c.encoder = s, t, newEncoder(s)
+			}
+		}
+	}
+	c.info.CompressType = c.compressor
+	c.info.SerializeType = c.serializeType
+	c.lastActive.Store(time.Now().UnixNano())
+	if options.keepaliveInterval > 0 && c.err == nil {
+		go c.keepalive(options.keepaliveInterval, options.keepaliveTimeout)
+	}
+	return c
 }
 
-// WriteRequest Write the rpc request header and body to the io stream
-func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
-	// map 不是并发安全的
-	c.mutex.Lock()
-	c.pending[r.Seq] = r.ServiceMethod
-	c.mutex.Unlock()
+// ConnInfo get the connection settings in effect
+func (c *clientCodec) ConnInfo() ConnInfo {
+	return c.info
+}
 
-	if _, ok := compressor.Compressors[c.compressor]; !ok {
-		return NotFoundCompressorError
-	}
+// Traffic get the bytes read from and written to the connection so far
+func (c *clientCodec) Traffic() Traffic {
+	return c.counter.traffic()
+}
 
-	// 将参数编码为请求体
-	reqBody, err := c.serializer.Marshal(param)
-	if err != nil {
-		return err
+// WriteRequest Write the rpc request header and body to the io stream
+func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) (err error) {
+	if c.err != nil {
+		return c.err
+	}
+	var (
+		flags    uint8
+		callType serializer.SerializeType
+	)
+	if p, ok := param.(*Param); ok {
+		callType = p.SerializeType
 	}
-	// 压缩请求体
-	compressedReqBody, err := compressor.Compressors[c.compressor].Zip(reqBody)
+	// 请求可以使用与连接不同的序列化器，响应默认沿用请求的序列化器
+	s, serializeType, err := serializerFor(c.dict.registry, r.ServiceMethod, callType, c.byService, c.serializer, c.serializeType)
 	if err != nil {
 		return err
 	}
+	// 请求头中的 ID 可以由生成器提供，响应按 ID 找回 rpc.Client 的序号
+	id := r.Seq
+	if c.newID != nil {
+		id = c.newID()
+	}
+	call := pendingCall{method: r.ServiceMethod, seq: r.Seq, serializeType: serializeType}
+	if p, ok := param.(*Param); ok {
+		call.onChunk = p.OnChunk
+		if t := responseSerializeType(p.Metadata); t != 0 {
+			call.serializeType = t
+		}
+		if p.Oneway {
+			flags = header.FlagOneway
+		}
+	}
+	comp, ok := c.dict.compressor(c.compressor)
+	if !ok {
+		return NotFoundCompressorError
+	}
+
+	// 拆出随调用携带的元数据
+	param, metadata := unwrapParam(param)
+	var (
+		compressedReqBody []byte
+		digest            uint64
+		compressType      = c.compressor
+	)
+	// 编码和发送共用连接的缓冲区，rpc.Client 本就串行调用 WriteRequest
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	// 无参数时不发送请求体，跳过序列化、压缩和校验
+	if !isNilParam(param) {
+		// 将参数编码为请求体
+		var reqBody []byte
+		if c.encoder != nil && serializeType == c.serializeType {
+			var release func()
+			reqBody, release, err = c.encoder.marshal(s, param)
+			defer release()
+		} else {
+			reqBody, err = c.scratch.marshal(s, param)
+		}
+		if err != nil {
+			return err
+		}
+		// 压缩请求体
+		if compressedReqBody, err = c.scratch.zip(comp, reqBody); err != nil {
+			return err
+		}
+		if useRaw(compressType, reqBody, compressedReqBody, c.fallback) {
+			compressedReqBody, compressType = reqBody, compressor.Raw
+		}
+		// 不发送对端会拒绝的请求体
+		if c.info.MaxMessageSize != 0 && len(compressedReqBody) > int(c.info.MaxMessageSize) {
+			return MessageTooLargeError
+		}
+		// 计算校验和
+		if digest, err = sum(c.checksum, compressedReqBody); err != nil {
+			return err
+		}
+	}
 	// 从请求头部对象池取出请求头
 	h := header.RequestPool.Get().(*header.RequestHeader)
 	// 循环利用请求头
@@ -64,84 +228,414 @@ func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
 		header.RequestPool.Put(h)
 	}()
 
-	h.ID = r.Seq
+	h.ID = id
 	h.Method = r.ServiceMethod
 	h.RequestLen = uint32(len(compressedReqBody))
-	h.CompressType = c.compressor
-	h.Checksum = crc32.ChecksumIEEE(compressedReqBody)
+	h.CompressType = compressType
+	h.ChecksumType = c.checksum
+	h.SerializeType = serializeType
+	h.Flags = flags
+	h.Checksum = digest
+	h.Metadata = metadata
 
-	// 发送请求头
-	if err := sendFrame(c.writer, h.Marshal()); err != nil {
+	// 补齐请求体，校验和只覆盖补齐前的请求体，签名覆盖发送的请求体
+	if body, padded := c.padder.pad(compressedReqBody, c.info.MaxMessageSize); padded != nil {
+		defer putBuffer(padded)
+		h.Flags |= header.FlagPadded
+		h.BodyLen = h.RequestLen
+		h.RequestLen = uint32(len(body))
+		compressedReqBody = body
+	}
+	if c.signer != nil {
+		// 签名覆盖不含 MAC 的请求头和请求体
+		if c.headerBuf, err = c.signer.signRequest(c.headers, c.headerBuf, h, compressedReqBody); err != nil {
+			return err
+		}
+	}
+	// 编码请求头，复用连接的缓冲区
+	data, err := marshalRequest(c.headers, c.headerBuf, h)
+	if err != nil {
+		return err
+	}
+	c.headerBuf = data
+	// 请求编码成功后才记录为未回复的请求，单向请求没有响应，不记录
+	if flags&header.FlagOneway == 0 {
+		if err = c.track(id, call); err != nil {
+			return err
+		}
+		defer func() {
+			// 请求未发出，不会收到响应
+			if err != nil {
+				c.forget(id)
+			}
+		}()
+	}
+	c.deadline.writeMessage()
+	// 发送请求头和请求体
+	if err := sendMessage(c.writer, data, compressedReqBody); err != nil {
 		return err
 	}
-	// 发送请求体
-	if err := write(c.writer, compressedReqBody); err != nil {
+
+	if err := c.flush(); err != nil {
 		return err
 	}
+	c.lastActive.Store(time.Now().UnixNano())
+	return nil
+}
 
-	c.writer.(*bufio.Writer).Flush()
+// track record the call awaiting the response of id
+func (c *clientCodec) track(id uint64, call pendingCall) error {
+	// 未回复的请求过多时拒绝新的请求
+	if c.maxPending > 0 && c.outstanding.Add(1) > int64(c.maxPending) {
+		c.outstanding.Add(-1)
+		return TooManyPendingError
+	}
+	// rpc.Client 的序号不会重复，生成的 ID 需要检查
+	if c.newID == nil {
+		c.pending.store(id, call)
+	} else if !c.pending.storeNew(id, call) {
+		if c.maxPending > 0 {
+			c.outstanding.Add(-1)
+		}
+		return DuplicateRequestIDError
+	}
 	return nil
 }
 
+// forget remove the pending call of seq
+func (c *clientCodec) forget(seq uint64) (pendingCall, bool) {
+	call, ok := c.pending.loadAndDelete(seq)
+	if ok && c.maxPending > 0 {
+		c.outstanding.Add(-1)
+	}
+	return call, ok
+}
+
+// flush 未开启批量发送时立即刷新缓冲，否则等待批量窗口结束或请求数达到上限后再刷新，
+// 调用方需持有 wmutex
+func (c *clientCodec) flush() error {
+	if c.batchWindow <= 0 {
+		return flush(c.writer)
+	}
+	c.batched++
+	if c.maxBatch > 0 && c.batched >= c.maxBatch {
+		return c.flushBatch()
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.batchWindow, func() {
+			c.wmutex.Lock()
+			defer c.wmutex.Unlock()
+			c.deadline.writeMessage()
+			if err := c.flushBatch(); err != nil {
+				// 与写入失败相同，关闭连接使读取协程退出，未回复的调用随之失败
+				c.closer.Close()
+			}
+		})
+	}
+	return nil
+}
+
+// flushBatch 刷新当前批次，调用方需持有 wmutex
+func (c *clientCodec) flushBatch() error {
+	c.batched = 0
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	return flush(c.writer)
+}
+
+// Closed report whether the codec stopped reading responses, rpc.Client shuts down
+// once ReadResponseHeader fails
+func (c *clientCodec) Closed() bool {
+	return c.closed.Load()
+}
+
 // ReadResponseHeader read the rpc response header from the io stream
 func (c *clientCodec) ReadResponseHeader(response *rpc.Response) error {
-	c.response.ResetHeader()
-	// 读取响应头
-	data, err := recvFrame(c.reader)
+	err := c.readResponseHeader(response)
 	if err != nil {
-		return err
+		c.closed.Store(true)
+		// 未接收完的数据流以连接的错误结束
+		for id, stream := range c.streams {
+			if stream != nil {
+				stream.finish(err)
+			}
+			delete(c.streams, id)
+		}
 	}
-	// 解码响应头
-	err = c.response.Unmarshal(data)
+	return err
+}
+
+func (c *clientCodec) readResponseHeader(response *rpc.Response) error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.broken != nil {
+		return c.broken
+	}
+	for {
+		c.response.ResetHeader()
+		// 读取响应头
+		c.deadline.waitHeader()
+		data, err := recvFrameInto(c.reader, c.info.MaxMessageSize, c.frames)
+		if err != nil {
+			return err
+		}
+		// 解码响应头，头部字段已拷贝出缓冲区
+		err = c.headers.UnmarshalResponse(*data, &c.response)
+		c.frames.put(data)
+		if err != nil {
+			return err
+		}
+		if c.verifier, err = c.signer.verifyResponse(c.headers, &c.verifyBuf, &c.response); err != nil {
+			return err
+		}
+		// 响应体需在读超时内到达
+		c.deadline.readBody()
+		c.lastActive.Store(time.Now().UnixNano())
+		flags := c.response.GetFlags()
+		if flags&header.FlagPong != 0 {
+			if err = c.verifier.verify(nil); err != nil {
+				return err
+			}
+			c.receivePong()
+			continue
+		}
+		if flags&header.FlagStreamBody != 0 {
+			if stream, ok := c.streams[c.response.ID]; ok {
+				// 数据流的后续帧交给调用方持有的 reader
+				if err = c.readStreamFrame(stream); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if flags&header.FlagStreamChunk != 0 {
+			// 流式消息交给调用的回调，rpc.Client 只会看到最终响应
+			if err = c.readChunk(); err != nil {
+				return err
+			}
+			continue
+		}
+		call, ok := c.forget(c.response.ID) // 取出并删除pending中的调用
+		if !ok && c.newID != nil {
+			// 生成的 ID 与 rpc.Client 的序列号无关，沿用 ID 可能对应到无关的调用，丢弃后读取下一个响应
+			c.readBody(nil)
+			if c.broken != nil {
+				return c.broken
+			}
+			continue
+		}
+		response.Error = c.response.Error
+		if c.response.ErrorCode != 0 {
+			// rpc.Client 只保留错误信息，错误码随信息传递
+			response.Error = formatCodeError(c.response.ErrorCode, c.response.Error)
+		}
+		response.Seq = call.seq // 取出序列号
+		if !ok {
+			response.Seq = c.response.ID
+		}
+		response.ServiceMethod = call.method
+		c.expected = call.serializeType
+		return nil
+	}
+}
+
+// readChunk deliver the body of the current stream chunk to the callback of its call,
+// only errors which break the connection are returned
+func (c *clientCodec) readChunk() error {
+	call, _ := c.pending.load(c.response.ID)
+	c.expected = call.serializeType
+	onChunk := call.onChunk
+	if onChunk == nil {
+		// 调用已结束，丢弃
+		c.readBody(nil)
+		return c.broken
+	}
+	err := c.readBody(func(data []byte, s serializer.Serializer) error {
+		// 缓冲区会被复用，需拷贝
+		onChunk(Chunk{data: append([]byte(nil), data...), serializer: s})
+		return nil
+	})
+	if err != nil && c.broken == nil {
+		onChunk(Chunk{err: err})
+	}
+	return c.broken
+}
+
+// readStreamFrame queue the bytes of the current frame of a streamed body for its reader, an empty
+// frame ends the stream. Only errors which break the connection are returned
+func (c *clientCodec) readStreamFrame(stream *bodyStream) error {
+	id := c.response.ID
+	if c.response.GetFlags()&header.FlagError != 0 {
+		delete(c.streams, id)
+		if stream != nil {
+			stream.finish(errors.New(c.response.Error))
+		}
+		c.readBody(nil)
+		return c.broken
+	}
+	if c.response.ResponseLen == 0 {
+		delete(c.streams, id)
+		err := c.authenticate(nil)
+		if stream != nil {
+			stream.finish(err)
+		}
+		return c.broken
+	}
+	if stream == nil {
+		c.readBody(nil)
+		return c.broken
+	}
+	// 帧内容只是字节，不经过序列化器
+	c.expected = 0
+	err := c.readBody(func(data []byte, _ serializer.Serializer) error {
+		// 缓冲区会被复用，需拷贝；内存占用不超过缓冲的帧数
+		return stream.push(append([]byte(nil), data...), c.streamTimeout)
+	})
 	if err != nil {
-		return err
+		// 调用方关闭或放弃了 reader，或帧无法解码，丢弃剩余的帧
+		stream.finish(err)
+		c.streams[id] = nil
 	}
-	c.mutex.Lock()
-	response.Seq = c.response.ID // 取出序列号
-	response.Error = c.response.Error
-	response.ServiceMethod = c.pending[response.Seq] // 取出响应方法
-	delete(c.pending, response.Seq)                  // 删除pending中的序号
-	c.mutex.Unlock()
-	return nil
+	return c.broken
 }
 
 // ReadResponseBody read the rpc response body from the io stream
 func (c *clientCodec) ReadResponseBody(param any) error {
-	if param == nil {
-		if c.response.ResponseLen != 0 { // 废弃多余部分
-			if err := read(c.reader, make([]byte, c.response.ResponseLen)); err != nil {
-				return err
-			}
+	flags := c.response.GetFlags()
+	if flags&header.FlagStreamBody != 0 && flags&header.FlagError == 0 {
+		return c.openStream(param)
+	}
+	// 错误响应没有响应体
+	if param == nil || flags&header.FlagError != 0 {
+		return c.readBody(nil)
+	}
+	return c.readBody(func(data []byte, s serializer.Serializer) error {
+		return s.Unmarshal(data, param)
+	})
+}
+
+// openStream hand a reader of the body streamed in the following frames to the *io.Reader param
+func (c *clientCodec) openStream(param any) error {
+	if c.streams == nil {
+		c.streams = make(map[uint64]*bodyStream)
+	}
+	// 首帧不带数据
+	if err := c.readBody(nil); err != nil {
+		return err
+	}
+	reply, ok := param.(*io.Reader)
+	if !ok {
+		c.streams[c.response.ID] = nil
+		return StreamedBodyError
+	}
+	stream := newBodyStream(c.streamBacklog)
+	c.streams[c.response.ID] = stream
+	*reply = stream
+	return nil
+}
+
+// readBody read the body of the current response and pass it decompressed to decode,
+// the body is discarded when decode is nil
+func (c *clientCodec) readBody(decode func(data []byte, s serializer.Serializer) error) error {
+	// 超过限制的响应体直接丢弃，不分配内存，补齐的字节不计入响应体的大小限制
+	if exceeds(c.response.ResponseLen, c.info.MaxMessageSize) ||
+		exceeds(unpaddedLen(c.response.GetFlags(), c.response.ResponseLen, c.response.BodyLen), c.maxResponseSize) {
+		if err := c.skipBody(); err != nil {
+			return err
 		}
-		return nil
+		return MessageTooLargeError
+	}
+	// 丢弃的响应体同样需要验证，例如错误响应
+	if decode == nil {
+		return c.skipBody()
 	}
 
 	// 根据响应体长度，读取该长度的字节串
-	respBody := make([]byte, c.response.ResponseLen)
+	buf := c.frames.get(int(c.response.ResponseLen))
+	// 反序列化会拷贝出数据，返回后缓冲区即可归还
+	defer c.frames.put(buf)
+	respBody := *buf
 	err := read(c.reader, respBody)
 	if err != nil {
+		// 响应体读取失败后无法定位下一个响应，连接不可再用
+		c.broken = err
+		return err
+	}
+	// 先验证签名，篡改的响应不再继续处理
+	if err = c.authenticate(respBody); err != nil {
+		return err
+	}
+	// 去掉补齐的字节
+	if respBody, err = unpad(c.response.GetFlags(), respBody, c.response.BodyLen); err != nil {
 		return err
 	}
 
 	// 检查校验和
-	if c.response.Checksum != 0 {
-		if crc32.ChecksumIEEE(respBody) != c.response.Checksum {
-			return UnexpectedChecksumError
-		}
+	if err = verify(c.response.GetChecksumType(), respBody, c.response.Checksum); err != nil {
+		return err
 	}
 	// 检查Compressor
-	if c.response.GetCompressType() != c.compressor {
+	// 对端可能以原数据发送压缩没有收益的响应体
+	if t := c.response.GetCompressType(); t != c.compressor && t != compressor.Raw {
 		return CompressorTypeMismatchError
 	}
+	// 检查Serializer，响应的序列化格式与请求时约定的不一致
+	if t := c.response.GetSerializeType(); t != 0 && c.expected != 0 && t != c.expected {
+		return SerializerMismatchError
+	}
 	// 解压响应体
-	resp, err := compressor.Compressors[c.response.GetCompressType()].Unzip(respBody)
+	comp, ok := c.dict.compressor(c.response.GetCompressType())
+	if !ok {
+		// 请求发出时压缩器仍已注册
+		return CompressorUnregisteredError
+	}
+	resp, unzipped, err := unzip(comp, respBody)
+	if err != nil {
+		return &DecompressError{CompressType: c.response.GetCompressType(), Err: err}
+	}
+	defer putBuffer(unzipped)
+	// 按响应头标记的序列化格式反序列化
+	s, err := serializerOf(c.dict.registry, c.response.GetSerializeType(), c.serializer)
 	if err != nil {
 		return err
 	}
-	// 反序列化
-	return c.serializer.Unmarshal(resp, param)
+	return decode(resp, s)
+}
+
+// skipBody read the body of the current response without decoding it and check its MAC
+func (c *clientCodec) skipBody() error {
+	if err := c.verifier.skip(c.reader, c.response.ResponseLen); err != nil {
+		c.broken = err
+		return err
+	}
+	return c.authenticate(nil)
+}
+
+// authenticate verify the MAC of the current response with its body, a response failing
+// it breaks the connection since later responses can no longer be trusted either
+func (c *clientCodec) authenticate(body []byte) error {
+	if err := c.verifier.verify(body); err != nil {
+		c.broken = err
+		return err
+	}
+	return nil
 }
 
 func (c *clientCodec) Close() error {
+	// 发出尚未刷新的批次；锁被占用时写入可能阻塞在连接上，不再刷新，直接关闭连接使其返回
+	if c.wmutex.TryLock() {
+		if c.batched > 0 {
+			c.deadline.writeMessage()
+			c.flushBatch()
+		}
+		c.wmutex.Unlock()
+	}
+	c.closed.Store(true)
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
 	return c.closer.Close()
 }
@@ -0,0 +1,1433 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"tiny_rpc/checksum"
+	"tiny_rpc/compressor"
+	"tiny_rpc/header"
+	"tiny_rpc/serializer"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buffer an in-memory io.ReadWriteCloser
+type buffer struct {
+	*bytes.Buffer
+}
+
+func (_ buffer) Close() error {
+	return nil
+}
+
+func newBuffer(data []byte) buffer {
+	return buffer{bytes.NewBuffer(data)}
+}
+
+// splitRequest split the raw request bytes into header and body
+func splitRequest(t *testing.T, data []byte) (*header.RequestHeader, []byte) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	frame, err := recvFrame(r, 0)
+	assert.Nil(t, err)
+	h := &header.RequestHeader{}
+	assert.Nil(t, h.Unmarshal(frame))
+	body := make([]byte, h.RequestLen)
+	assert.Nil(t, read(r, body))
+	return h, body
+}
+
+// TestCodec_Checksum .
+func TestCodec_Checksum(t *testing.T) {
+	cases := []struct {
+		name         string
+		checksumType checksum.ChecksumType
+		compressType compressor.CompressType
+	}{
+		{"test-1", checksum.Crc32, compressor.Raw},
+		{"test-2", checksum.Crc64, compressor.Raw},
+		{"test-3", checksum.XXHash64, compressor.Raw},
+		{"test-4", checksum.Crc32, compressor.Flate},
+		{"test-5", checksum.Crc32C, compressor.Raw},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := newBuffer(nil)
+			client := NewClientCodec(conn, c.compressType, serializer.Proto, WithChecksum(c.checksumType))
+			err := client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+				&pb.ArithRequest{A: 1, B: 2})
+			assert.Nil(t, err)
+
+			data := conn.Bytes()
+			h, body := splitRequest(t, data)
+			assert.Equal(t, c.checksumType, h.ChecksumType)
+			assert.Equal(t, checksum.Checksums[c.checksumType].Sum(body), h.Checksum)
+
+			// 服务端使用请求头中的校验算法校验，并在响应中沿用
+			conn = newBuffer(append([]byte{}, data...))
+			server := NewServerCodec(conn, serializer.Proto)
+			request := &rpc.Request{}
+			assert.Nil(t, server.ReadRequestHeader(request))
+			args := &pb.ArithRequest{}
+			assert.Nil(t, server.ReadRequestBody(args))
+			assert.Equal(t, 3.0, args.A+args.B)
+
+			conn.Reset()
+			err = server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+				&pb.ArithResponse{C: 3})
+			assert.Nil(t, err)
+
+			client = NewClientCodec(conn, c.compressType, serializer.Proto, WithChecksum(c.checksumType))
+			response := &rpc.Response{}
+			assert.Nil(t, client.ReadResponseHeader(response))
+			assert.Equal(t, c.checksumType, client.(*clientCodec).response.ChecksumType)
+			reply := &pb.ArithResponse{}
+			assert.Nil(t, client.ReadResponseBody(reply))
+			assert.Equal(t, 3.0, reply.C)
+		})
+	}
+}
+
+// TestCodec_ChecksumMismatch .
+func TestCodec_ChecksumMismatch(t *testing.T) {
+	for _, checksumType := range []checksum.ChecksumType{checksum.Crc32, checksum.Crc64, checksum.XXHash64, checksum.Crc32C} {
+		conn := newBuffer(nil)
+		client := NewClientCodec(conn, compressor.Raw, serializer.Proto, WithChecksum(checksumType))
+		err := client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+			&pb.ArithRequest{A: 1, B: 2})
+		assert.Nil(t, err)
+
+		// 篡改请求体的最后一个字节
+		data := conn.Bytes()
+		data[len(data)-1] ^= 0xff
+
+		server := NewServerCodec(newBuffer(data), serializer.Proto)
+		assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+		assert.Equal(t, UnexpectedChecksumError, server.ReadRequestBody(&pb.ArithRequest{}))
+	}
+}
+
+// TestCodec_WithoutChecksum .
+func TestCodec_WithoutChecksum(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Gzip, serializer.Proto, WithoutChecksum())
+	err := client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+		&pb.ArithRequest{A: 1, B: 2})
+	assert.Nil(t, err)
+
+	h, _ := splitRequest(t, conn.Bytes())
+	assert.Equal(t, checksum.None, h.ChecksumType)
+	assert.Equal(t, uint64(0), h.Checksum)
+
+	server := NewServerCodec(conn, serializer.Proto)
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	args := &pb.ArithRequest{}
+	assert.Nil(t, server.ReadRequestBody(args))
+	assert.Equal(t, 1.0, args.A)
+	assert.Equal(t, 2.0, args.B)
+
+	conn.Reset()
+	err = server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+		&pb.ArithResponse{C: 3})
+	assert.Nil(t, err)
+
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	assert.Equal(t, checksum.None, client.(*clientCodec).response.ChecksumType)
+	assert.Equal(t, uint64(0), client.(*clientCodec).response.Checksum)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.ReadResponseBody(reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// TestCodec_ResponseSerializer .
+func TestCodec_ResponseSerializer(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Proto)
+	param := &Param{
+		Value:    &pb.ArithRequest{A: 1, B: 2},
+		Metadata: map[string]string{MetaResponseSerializer: strconv.Itoa(int(serializer.JSONType))},
+	}
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, param))
+
+	h, _ := splitRequest(t, conn.Bytes())
+	assert.Equal(t, param.Metadata, h.Metadata)
+
+	server := NewServerCodec(conn, serializer.Proto)
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	args := &pb.ArithRequest{}
+	assert.Nil(t, server.ReadRequestBody(args))
+	assert.Equal(t, 2.0, args.B)
+
+	conn.Reset()
+	err := server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+		&pb.ArithResponse{C: 3})
+	assert.Nil(t, err)
+	assert.Contains(t, conn.String(), `{"c":3}`)
+
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	assert.Equal(t, serializer.JSONType, client.(*clientCodec).response.SerializeType)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.ReadResponseBody(reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// TestCodec_CompressorUnregistered .
+func TestCodec_CompressorUnregistered(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Gzip, serializer.Proto)
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+		&pb.ArithRequest{A: 1, B: 2}))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 2},
+		&pb.ArithRequest{A: 1, B: 2}))
+
+	server := NewServerCodec(conn, serializer.Proto)
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+
+	// 读取请求头与请求体之间注销压缩器
+	gzip, _ := compressor.Get(compressor.Gzip)
+	compressor.Unregister(compressor.Gzip)
+	defer compressor.Register(compressor.Gzip, gzip)
+
+	assert.Equal(t, CompressorUnregisteredError, server.ReadRequestBody(&pb.ArithRequest{}))
+	// 连接随之关闭，不再读取后续请求
+	assert.Equal(t, CompressorUnregisteredError, server.ReadRequestHeader(&rpc.Request{}))
+}
+
+// TestCodec_CompressorNotFound .
+func TestCodec_CompressorNotFound(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.CompressType(100), serializer.Proto)
+	err := client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, &pb.ArithRequest{})
+	assert.Equal(t, NotFoundCompressorError, err)
+}
+
+// TestCodec_UnsupportedCompressor .
+func TestCodec_UnsupportedCompressor(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Gzip, serializer.Proto)
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, &pb.ArithRequest{A: 1}))
+	server := NewServerCodec(conn, serializer.Proto, WithRegistry(NewRegistry()))
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	err := server.ReadRequestBody(&pb.ArithRequest{})
+	var ue *UnsupportedCompressorError
+	assert.True(t, errors.As(err, &ue))
+	assert.True(t, errors.Is(err, NotFoundCompressorError))
+	assert.Equal(t, compressor.Gzip, ue.CompressType)
+	assert.Equal(t, []compressor.CompressType{compressor.Raw}, ue.Supported)
+
+	// 错误响应以 Raw 发送，连接可继续使用
+	conn.Reset()
+	assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq,
+		Error: err.Error()}, err))
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	h := &client.(*clientCodec).response
+	assert.Equal(t, compressor.Raw, h.CompressType)
+	assert.Equal(t, UnsupportedCompressorCode, h.ErrorCode)
+	assert.Nil(t, client.ReadResponseBody(nil))
+
+	cases := []struct {
+		name string
+		err  *UnsupportedCompressorError
+	}{
+		{"test-1", ue},
+		{"test-2", &UnsupportedCompressorError{CompressType: 100, Supported: []compressor.CompressType{0, 1, 3}}},
+		{"test-3", &UnsupportedCompressorError{CompressType: 7}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			parsed, ok := ParseUnsupportedCompressorError(c.err.Error())
+			assert.True(t, ok)
+			assert.Equal(t, c.err, parsed)
+		})
+	}
+	_, ok := ParseUnsupportedCompressorError("not found compressor")
+	assert.False(t, ok)
+}
+
+// TestCodec_FrameBuffer .
+func TestCodec_FrameBuffer(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Raw, WithFrameBuffer(16))
+	server := NewServerCodec(conn, serializer.Raw, WithFrameBuffer(16))
+	// 消息超过初始大小时缓冲区增长，较小的消息不受前一个消息的残留影响
+	for i, size := range []int{8, 300, 5, 300} {
+		body := bytes.Repeat([]byte{byte(i + 1)}, size)
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: uint64(i)}, body))
+		request := &rpc.Request{}
+		assert.Nil(t, server.ReadRequestHeader(request))
+		var args []byte
+		assert.Nil(t, server.ReadRequestBody(&args))
+		assert.Equal(t, body, args)
+
+		assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq}, args))
+		assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+		var reply []byte
+		assert.Nil(t, client.ReadResponseBody(&reply))
+		assert.Equal(t, body, reply)
+	}
+	assert.Equal(t, 300, cap(server.(*serverCodec).frames.buf))
+}
+
+// TestCodec_MarshalErrorNotPending .
+func TestCodec_MarshalErrorNotPending(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Proto, WithMaxPendingRequests(1))
+	err := client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, "not a proto message")
+	var typeErr *serializer.SerializerTypeError
+	assert.True(t, errors.As(err, &typeErr))
+	// 编码失败的请求既未记录也未发出
+	c := client.(*clientCodec)
+	assert.Equal(t, 0, c.pending.len())
+	assert.Equal(t, int64(0), c.outstanding.Load())
+	assert.Equal(t, 0, conn.Len())
+
+	// 连接仍可继续使用
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 2}, &pb.ArithRequest{A: 1, B: 2}))
+	assert.Equal(t, 1, c.pending.len())
+	server := NewServerCodec(conn, serializer.Proto)
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	assert.Equal(t, "ArithService.Add", request.ServiceMethod)
+	args := &pb.ArithRequest{}
+	assert.Nil(t, server.ReadRequestBody(args))
+	assert.Equal(t, 2.0, args.B)
+}
+
+// jsonHeaderCodec encodes the headers as JSON objects
+type jsonHeaderCodec struct{}
+
+func (jsonHeaderCodec) MarshalRequest(h *header.RequestHeader) ([]byte, error) {
+	return json.Marshal(h)
+}
+
+func (jsonHeaderCodec) UnmarshalRequest(data []byte, h *header.RequestHeader) error {
+	return json.Unmarshal(data, h)
+}
+
+func (jsonHeaderCodec) MarshalResponse(h *header.ResponseHeader) ([]byte, error) {
+	return json.Marshal(h)
+}
+
+func (jsonHeaderCodec) UnmarshalResponse(data []byte, h *header.ResponseHeader) error {
+	return json.Unmarshal(data, h)
+}
+
+// TestCodec_HeaderCodec .
+func TestCodec_HeaderCodec(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Gzip, serializer.Proto, WithHeaderCodec(jsonHeaderCodec{}))
+	param := &Param{Value: &pb.ArithRequest{A: 6, B: 3}, Metadata: map[string]string{"trace": "abc"}}
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Div", Seq: 7}, param))
+
+	// 请求头以 JSON 编码
+	frame, err := recvFrame(bytes.NewReader(conn.Bytes()), 0)
+	assert.Nil(t, err)
+	assert.True(t, json.Valid(frame))
+	assert.Contains(t, string(frame), `"ArithService.Div"`)
+	assert.Contains(t, string(frame), `"trace":"abc"`)
+
+	server := NewServerCodec(conn, serializer.Proto, WithHeaderCodec(jsonHeaderCodec{}))
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	assert.Equal(t, "ArithService.Div", request.ServiceMethod)
+	args := &pb.ArithRequest{}
+	assert.Nil(t, server.ReadRequestBody(args))
+	assert.Equal(t, 6.0, args.A)
+	assert.Equal(t, 3.0, args.B)
+
+	conn.Reset()
+	err = server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+		&pb.ArithResponse{C: 2})
+	assert.Nil(t, err)
+
+	response := &rpc.Response{}
+	assert.Nil(t, client.ReadResponseHeader(response))
+	assert.Equal(t, uint64(7), response.Seq)
+	assert.Equal(t, "ArithService.Div", response.ServiceMethod)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.ReadResponseBody(reply))
+	assert.Equal(t, 2.0, reply.C)
+
+	// 与默认的二进制请求头不兼容
+	conn.Reset()
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Div", Seq: 8}, param))
+	server = NewServerCodec(conn, serializer.Proto)
+	assert.NotNil(t, server.ReadRequestHeader(&rpc.Request{}))
+}
+
+// TestCodec_IDGenerator .
+func TestCodec_IDGenerator(t *testing.T) {
+	next := uint64(1 << 40)
+	gen := func() uint64 {
+		next += 7
+		return next
+	}
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Raw, WithIDGenerator(gen))
+	server := NewServerCodec(conn, serializer.Raw)
+
+	for seq := uint64(0); seq < 3; seq++ {
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "BlobService.Echo", Seq: seq}, []byte("tinyrpc")))
+		h, _ := splitRequest(t, conn.Bytes())
+		assert.Equal(t, next, h.ID)
+
+		request := &rpc.Request{}
+		assert.Nil(t, server.ReadRequestHeader(request))
+		var args []byte
+		assert.Nil(t, server.ReadRequestBody(&args))
+		assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq}, args))
+
+		// 服务端原样返回生成的 ID
+		frame, err := recvFrame(bytes.NewReader(conn.Bytes()), 0)
+		assert.Nil(t, err)
+		rh := &header.ResponseHeader{}
+		assert.Nil(t, rh.Unmarshal(frame))
+		assert.Equal(t, next, rh.ID)
+
+		// rpc.Client 看到的仍是自己的序号
+		response := &rpc.Response{}
+		assert.Nil(t, client.ReadResponseHeader(response))
+		assert.Equal(t, seq, response.Seq)
+		assert.Equal(t, "BlobService.Echo", response.ServiceMethod)
+		var reply []byte
+		assert.Nil(t, client.ReadResponseBody(&reply))
+		assert.Equal(t, "tinyrpc", string(reply))
+	}
+
+	// 未知 ID 的响应被丢弃，不会按序号对应到无关的调用
+	conn.Reset()
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "BlobService.Echo", Seq: 3}, []byte("tinyrpc")))
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	var args []byte
+	assert.Nil(t, server.ReadRequestBody(&args))
+	conn.Reset()
+	unknown := &header.ResponseHeader{ID: 3, ResponseLen: 5}
+	assert.Nil(t, sendFrame(conn, unknown.Marshal()))
+	conn.Write([]byte("stale"))
+	assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq}, args))
+	response := &rpc.Response{}
+	assert.Nil(t, client.ReadResponseHeader(response))
+	assert.Equal(t, uint64(3), response.Seq)
+	var reply []byte
+	assert.Nil(t, client.ReadResponseBody(&reply))
+	assert.Equal(t, "tinyrpc", string(reply))
+
+	// 生成的 ID 仍在等待响应时拒绝请求
+	client = NewClientCodec(newBuffer(nil), compressor.Raw, serializer.Raw, WithIDGenerator(func() uint64 { return 42 }))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "BlobService.Echo", Seq: 0}, []byte("a")))
+	err := client.WriteRequest(&rpc.Request{ServiceMethod: "BlobService.Echo", Seq: 1}, []byte("b"))
+	assert.Equal(t, DuplicateRequestIDError, err)
+}
+
+// TestCodec_DuplicateRequestID .
+func TestCodec_DuplicateRequestID(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Proto)
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+			&pb.ArithRequest{A: 1, B: 2}))
+	}
+
+	// 默认不检查重复的 ID
+	server := NewServerCodec(newBuffer(conn.Bytes()), serializer.Proto)
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+		assert.Nil(t, server.ReadRequestBody(&pb.ArithRequest{}))
+	}
+
+	server = NewServerCodec(newBuffer(conn.Bytes()), serializer.Proto, WithRejectDuplicateIDs())
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	assert.Nil(t, server.ReadRequestBody(&pb.ArithRequest{}))
+	assert.Equal(t, DuplicateRequestIDError, server.ReadRequestHeader(&rpc.Request{}))
+	assert.Equal(t, DuplicateRequestIDError, server.ReadRequestHeader(&rpc.Request{}))
+
+	// 响应之后可以复用 ID
+	conn.Reset()
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+			&pb.ArithRequest{A: 1, B: 2}))
+	}
+	server = NewServerCodec(newBuffer(conn.Bytes()), serializer.Proto, WithRejectDuplicateIDs())
+	for i := 0; i < 2; i++ {
+		request := &rpc.Request{}
+		assert.Nil(t, server.ReadRequestHeader(request))
+		assert.Nil(t, server.ReadRequestBody(&pb.ArithRequest{}))
+		assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+			&pb.ArithResponse{C: 3}))
+	}
+}
+
+// pendingOnClose records the pending requests left when the connection closes
+type pendingOnClose struct {
+	*serverCodec
+	pending int
+}
+
+func (c *pendingOnClose) Close() error {
+	c.pending = c.serverCodec.pending.len()
+	return c.serverCodec.Close()
+}
+
+// TestCodec_PendingReleased .
+func TestCodec_PendingReleased(t *testing.T) {
+	conn := newBuffer(nil)
+	for seq := uint64(0); seq < 1000; seq++ {
+		request := newBuffer(nil)
+		client := NewClientCodec(request, compressor.Raw, serializer.Proto)
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: seq},
+			&pb.ArithRequest{A: 1, B: 2}))
+		// 篡改请求体，使请求体读取失败
+		data := request.Bytes()
+		data[len(data)-1] ^= 0xff
+		conn.Write(data)
+	}
+
+	requests := append([]byte(nil), conn.Bytes()...)
+
+	server := rpc.NewServer()
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	codec := &pendingOnClose{serverCodec: NewServerCodec(conn, serializer.Proto).(*serverCodec)}
+	server.ServeCodec(codec)
+	// 每个失败的请求都已回复错误，上下文均已移除
+	assert.Equal(t, 0, codec.pending)
+	assert.Equal(t, 0, codec.serverCodec.pending.len())
+
+	// 未回复的请求在关闭时释放
+	s := NewServerCodec(newBuffer(requests), serializer.Proto).(*serverCodec)
+	assert.Nil(t, s.ReadRequestHeader(&rpc.Request{}))
+	assert.Equal(t, 1, s.pending.len())
+	assert.Nil(t, s.Close())
+	assert.Equal(t, 0, s.pending.len())
+}
+
+// discardConn a connection reading from a buffer and discarding what is written to it
+type discardConn struct {
+	buffer
+}
+
+func (discardConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// TestCodec_SequentialReads .
+func TestCodec_SequentialReads(t *testing.T) {
+	const n = 300
+	conn := newBuffer(nil)
+	clients := []rpc.ClientCodec{
+		NewClientCodec(conn, compressor.Raw, serializer.Proto),
+		NewClientCodec(conn, compressor.Gzip, serializer.JSON),
+		NewClientCodec(conn, compressor.Snappy, serializer.Proto, WithoutChecksum()),
+	}
+	for i := 0; i < n; i++ {
+		var param any = &pb.ArithRequest{A: float64(i), B: 1}
+		// 穿插没有请求体的请求
+		if i%7 == 0 {
+			param = nil
+		}
+		assert.Nil(t, clients[i%len(clients)].WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: uint64(i)}, param))
+	}
+
+	server := NewServerCodec(discardConn{conn}, serializer.Proto)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		request := &rpc.Request{}
+		assert.Nil(t, server.ReadRequestHeader(request))
+		args := &pb.ArithRequest{}
+		assert.Nil(t, server.ReadRequestBody(args))
+		if i%7 == 0 {
+			assert.Equal(t, 0.0, args.A)
+		} else {
+			assert.Equal(t, float64(i), args.A)
+		}
+		// 回复与后续请求的读取并发进行，每个请求的状态互不干扰
+		wg.Add(1)
+		go func(request *rpc.Request) {
+			defer wg.Done()
+			assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+				&pb.ArithResponse{C: 1}))
+		}(request)
+	}
+	wg.Wait()
+	assert.Equal(t, 0, server.(*serverCodec).pending.len())
+}
+
+// brokenConn a connection whose writes fail, the buffered writer of the codecs
+// only notices it on Flush
+type brokenConn struct {
+	buffer
+	closed bool
+}
+
+var errBrokenPipe = errors.New("broken pipe")
+
+func (c *brokenConn) Write([]byte) (int, error) {
+	return 0, errBrokenPipe
+}
+
+func (c *brokenConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+// TestCodec_FlushError .
+func TestCodec_FlushError(t *testing.T) {
+	client := NewClientCodec(&brokenConn{buffer: newBuffer(nil)}, compressor.Raw, serializer.Proto)
+	err := client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, &pb.ArithRequest{A: 1, B: 2})
+	assert.Equal(t, errBrokenPipe, err)
+
+	conn := newBuffer(nil)
+	client = NewClientCodec(conn, compressor.Raw, serializer.Proto)
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+		&pb.ArithRequest{A: 1, B: 2}))
+	broken := &brokenConn{buffer: conn}
+	server := NewServerCodec(broken, serializer.Proto)
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	assert.Nil(t, server.ReadRequestBody(&pb.ArithRequest{}))
+	err = server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+		&pb.ArithResponse{C: 3})
+	assert.Equal(t, errBrokenPipe, err)
+	// 连接随之关闭
+	assert.True(t, broken.closed)
+}
+
+// TestCodec_NilParam .
+func TestCodec_NilParam(t *testing.T) {
+	for _, param := range []any{nil, (*pb.ArithRequest)(nil), &Param{Metadata: map[string]string{"k": "v"}}} {
+		conn := newBuffer(nil)
+		client := NewClientCodec(conn, compressor.Gzip, serializer.Proto, WithChecksum(checksum.XXHash64))
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, param))
+
+		// 只发送请求头，没有请求体
+		r := bytes.NewReader(conn.Bytes())
+		frame, err := recvFrame(r, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, r.Len())
+		h := &header.RequestHeader{}
+		assert.Nil(t, h.Unmarshal(frame))
+		assert.Equal(t, uint32(0), h.RequestLen)
+		assert.Equal(t, uint64(0), h.Checksum)
+
+		// 服务端的参数保持零值
+		server := NewServerCodec(conn, serializer.Proto)
+		assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+		args := &pb.ArithRequest{}
+		assert.Nil(t, server.ReadRequestBody(args))
+		assert.Equal(t, 0.0, args.A)
+	}
+}
+
+// corruptBody flip the first body byte of the single message in data
+func corruptBody(t *testing.T, data []byte) {
+	r := bytes.NewReader(data)
+	_, err := recvFrame(r, 0)
+	assert.Nil(t, err)
+	data[len(data)-r.Len()] ^= 0xff
+}
+
+// TestCodec_DecompressError .
+func TestCodec_DecompressError(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Gzip, serializer.Proto, WithoutChecksum())
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+		&pb.ArithRequest{A: 1, B: 2}))
+	corruptBody(t, conn.Bytes())
+
+	server := NewServerCodec(conn, serializer.Proto, WithoutChecksum())
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	err := server.ReadRequestBody(&pb.ArithRequest{})
+	var de *DecompressError
+	assert.True(t, errors.As(err, &de))
+	assert.Equal(t, compressor.Gzip, de.CompressType)
+	assert.True(t, errors.Is(err, gzip.ErrHeader))
+
+	// 响应体同样包装解压错误
+	assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+		&pb.ArithResponse{C: 3}))
+	corruptBody(t, conn.Bytes())
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	err = client.ReadResponseBody(&pb.ArithResponse{})
+	assert.True(t, errors.As(err, &de))
+	assert.True(t, errors.Is(err, gzip.ErrHeader))
+}
+
+// TestCodec_MaxPendingRequests .
+func TestCodec_MaxPendingRequests(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Proto, WithMaxPendingRequests(2))
+	for seq := uint64(1); seq <= 2; seq++ {
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: seq},
+			&pb.ArithRequest{A: 1, B: 2}))
+	}
+	err := client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 3}, &pb.ArithRequest{})
+	assert.Equal(t, TooManyPendingError, err)
+	// 参数编码失败先于名额检查返回
+	err = client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 3}, "not a proto message")
+	assert.True(t, errors.Is(err, serializer.NotImplementProtoMessageError))
+
+	// 收到响应后释放名额
+	server := NewServerCodec(conn, serializer.Proto)
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	assert.Nil(t, server.ReadRequestBody(&pb.ArithRequest{}))
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+	assert.Nil(t, server.ReadRequestBody(&pb.ArithRequest{}))
+	assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+		&pb.ArithResponse{C: 3}))
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	assert.Nil(t, client.ReadResponseBody(&pb.ArithResponse{}))
+
+	err = client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 3}, "not a proto message")
+	assert.True(t, errors.Is(err, serializer.NotImplementProtoMessageError))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 4}, &pb.ArithRequest{}))
+	assert.Equal(t, TooManyPendingError,
+		client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 5}, &pb.ArithRequest{}))
+}
+
+// countingCompressor counts the bodies it compresses
+type countingCompressor struct {
+	compressor.GzipCompressor
+	zipped int
+}
+
+func (c *countingCompressor) Zip(data []byte) ([]byte, error) {
+	c.zipped++
+	return c.GzipCompressor.Zip(data)
+}
+
+// TestCodec_ErrorResponse .
+func TestCodec_ErrorResponse(t *testing.T) {
+	const counting = compressor.CompressType(100)
+	comp := &countingCompressor{}
+	compressor.Register(counting, comp)
+	defer compressor.Unregister(counting)
+
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, counting, serializer.Proto, WithChecksum(checksum.XXHash64))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+		&pb.ArithRequest{A: 1, B: 2}))
+	server := NewServerCodec(conn, serializer.Proto)
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	assert.Nil(t, server.ReadRequestBody(&pb.ArithRequest{}))
+
+	comp.zipped = 0
+	err := server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq, Error: "failed"},
+		&pb.ArithResponse{C: 3})
+	assert.Nil(t, err)
+	// 错误响应不经过压缩器，也不计算校验和
+	assert.Equal(t, 0, comp.zipped)
+	frame, err := recvFrame(bytes.NewReader(conn.Bytes()), 0)
+	assert.Nil(t, err)
+	h := &header.ResponseHeader{}
+	assert.Nil(t, h.Unmarshal(frame))
+	assert.Equal(t, header.FlagError, h.Flags)
+	assert.Equal(t, uint32(0), h.ResponseLen)
+	assert.Equal(t, uint64(0), h.Checksum)
+
+	response := &rpc.Response{}
+	assert.Nil(t, client.ReadResponseHeader(response))
+	assert.Equal(t, "failed", response.Error)
+	assert.Nil(t, client.ReadResponseBody(nil))
+	assert.Equal(t, 0, conn.Len())
+}
+
+// TestCodec_MaxRequestSize .
+func TestCodec_MaxRequestSize(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.JSON)
+	large, small := strings.Repeat("a", 100), "small"
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 1}, large))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 2}, small))
+
+	server := NewServerCodec(conn, serializer.JSON, WithMaxRequestSize(64))
+	var args string
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+	assert.Equal(t, MessageTooLargeError, server.ReadRequestBody(&args))
+	assert.Equal(t, "", args)
+	// 超限的请求体已跳过，下一个请求不受影响
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+	assert.Nil(t, server.ReadRequestBody(&args))
+	assert.Equal(t, small, args)
+}
+
+// TestCodec_MaxResponseSize .
+func TestCodec_MaxResponseSize(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.JSON, WithMaxResponseSize(64))
+	server := NewServerCodec(conn, serializer.JSON)
+	large, small := strings.Repeat("a", 100), "small"
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 1}, large))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 2}, small))
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+		assert.Nil(t, server.ReadRequestBody(nil))
+	}
+	assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: "EchoService.Echo", Seq: 1}, large))
+	assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: "EchoService.Echo", Seq: 2}, small))
+
+	var reply string
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	assert.Equal(t, MessageTooLargeError, client.ReadResponseBody(&reply))
+	assert.Equal(t, "", reply)
+	// 超限的响应体已跳过，下一个响应不受影响
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	assert.Nil(t, client.ReadResponseBody(&reply))
+	assert.Equal(t, small, reply)
+}
+
+// TestCodec_SerializerMismatch .
+func TestCodec_SerializerMismatch(t *testing.T) {
+	// 客户端使用服务端未注册的序列化器
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.JSON)
+	client.(*clientCodec).serializeType = 99
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 1}, "hello"))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 2}, nil))
+	server := NewServerCodec(conn, serializer.JSON)
+	var args string
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+	assert.Equal(t, SerializerMismatchError, server.ReadRequestBody(&args))
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+	assert.Nil(t, server.ReadRequestBody(&args))
+
+	// 服务端不支持客户端要求的响应格式，退回自身的序列化器
+	conn = newBuffer(nil)
+	client = NewClientCodec(conn, compressor.Raw, serializer.Proto)
+	param := &Param{
+		Value:    &pb.ArithRequest{A: 1, B: 2},
+		Metadata: map[string]string{MetaResponseSerializer: "99"},
+	}
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, param))
+	server = NewServerCodec(conn, serializer.Proto)
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	assert.Nil(t, server.ReadRequestBody(&pb.ArithRequest{}))
+	assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+		&pb.ArithResponse{C: 3}))
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	assert.Equal(t, SerializerMismatchError, client.ReadResponseBody(&pb.ArithResponse{}))
+}
+
+// TestCodec_ErrorCode .
+func TestCodec_ErrorCode(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Proto)
+	server := NewServerCodec(conn, serializer.Proto)
+	for seq := uint64(1); seq <= 2; seq++ {
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Div", Seq: seq}, &pb.ArithRequest{}))
+		assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+		assert.Nil(t, server.ReadRequestBody(nil))
+	}
+	coded := &CodeError{Code: 404, Message: "not found"}
+	assert.Nil(t, server.WriteResponse(&rpc.Response{Seq: 1, Error: coded.Error()}, coded))
+	assert.Nil(t, server.WriteResponse(&rpc.Response{Seq: 2, Error: "failed"}, nil))
+
+	response := &rpc.Response{}
+	assert.Nil(t, client.ReadResponseHeader(response))
+	assert.Equal(t, uint32(404), client.(*clientCodec).response.ErrorCode)
+	assert.Nil(t, client.ReadResponseBody(nil))
+	ce, ok := ParseCodeError(response.Error)
+	assert.True(t, ok)
+	assert.Equal(t, coded, ce)
+
+	// 不带错误码的错误保持原样
+	assert.Nil(t, client.ReadResponseHeader(response))
+	assert.Nil(t, client.ReadResponseBody(nil))
+	assert.Equal(t, "failed", response.Error)
+	_, ok = ParseCodeError(response.Error)
+	assert.False(t, ok)
+}
+
+// TestCodec_BufferSize .
+func TestCodec_BufferSize(t *testing.T) {
+	cases := []struct {
+		name  string
+		opts  []Option
+		read  int
+		write int
+	}{
+		{"test-1", nil, 4096, 4096},
+		{"test-2", []Option{WithReadBufferSize(512)}, 512, 4096},
+		{"test-3", []Option{WithWriteBufferSize(1 << 16)}, 4096, 1 << 16},
+		{"test-4", []Option{WithReadBufferSize(1 << 16), WithWriteBufferSize(512)}, 1 << 16, 512},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := newBuffer(nil)
+			client := NewClientCodec(conn, compressor.Raw, serializer.JSON, c.opts...).(*clientCodec)
+			server := NewServerCodec(conn, serializer.JSON, c.opts...).(*serverCodec)
+			assert.Equal(t, c.read, client.reader.(*bufio.Reader).Size())
+			assert.Equal(t, c.write, client.writer.(*bufio.Writer).Size())
+			assert.Equal(t, c.read, server.reader.(*bufio.Reader).Size())
+			assert.Equal(t, c.write, server.writer.(*bufio.Writer).Size())
+
+			// 请求体大于缓冲区时仍可完整读写
+			body := strings.Repeat("a", 10000)
+			assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 1}, body))
+			var args string
+			assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+			assert.Nil(t, server.ReadRequestBody(&args))
+			assert.Equal(t, body, args)
+		})
+	}
+}
+
+// TestCodec_Unbuffered .
+func TestCodec_Unbuffered(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{"test-1", []Option{WithoutBuffering()}},
+		{"test-2", []Option{WithoutBuffering(), WithHandshake()}},
+		{"test-3", []Option{WithoutBuffering(), WithBatching(time.Millisecond, 4)}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				server := NewServerCodec(serverConn, serializer.Raw, c.opts...)
+				// 连接直接读写，不经过 bufio
+				assert.Equal(t, serverConn, server.(*serverCodec).writer.(*countingWriter).Writer)
+				for {
+					request := &rpc.Request{}
+					if err := server.ReadRequestHeader(request); err != nil {
+						return
+					}
+					var args []byte
+					assert.Nil(t, server.ReadRequestBody(&args))
+					reply := append([]byte("echo "), args...)
+					assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq}, reply))
+				}
+			}()
+
+			client := NewClientCodec(clientConn, compressor.Raw, serializer.Raw, c.opts...)
+			assert.Equal(t, clientConn, client.(*clientCodec).reader.(*countingReader).Reader)
+			for i := 1; i <= 10; i++ {
+				args := []byte(strings.Repeat(strconv.Itoa(i), i*100))
+				assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: uint64(i)}, args))
+				response := &rpc.Response{}
+				assert.Nil(t, client.ReadResponseHeader(response))
+				assert.Equal(t, uint64(i), response.Seq)
+				var reply []byte
+				assert.Nil(t, client.ReadResponseBody(&reply))
+				assert.Equal(t, "echo "+string(args), string(reply))
+			}
+			client.Close()
+			<-done
+		})
+	}
+}
+
+// TestCodec_CloseBlockedWrite .
+func TestCodec_CloseBlockedWrite(t *testing.T) {
+	// 对端不读取，超过缓冲区的请求阻塞在连接上
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	client := NewClientCodec(clientConn, compressor.Raw, serializer.Raw, WithBatching(time.Hour, 0))
+	written := make(chan error)
+	go func() {
+		args := bytes.Repeat([]byte("x"), 1<<20)
+		written <- client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 1}, args)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on the pending write")
+	}
+	assert.NotNil(t, <-written)
+}
+
+// bufferSerializer a resettable JSON serializer encoding into a reused buffer
+type bufferSerializer struct {
+	serializer.JSONSerializer
+	buf     *bytes.Buffer
+	created *int    // instances created by New
+	marshal []*byte // first byte of the buffer of each Marshal
+}
+
+func (s *bufferSerializer) New() serializer.Resettable {
+	*s.created++
+	return &bufferSerializer{buf: &bytes.Buffer{}, created: s.created}
+}
+
+func (s *bufferSerializer) Reset() {
+	s.buf.Reset()
+}
+
+func (s *bufferSerializer) Marshal(message any) ([]byte, error) {
+	if err := json.NewEncoder(s.buf).Encode(message); err != nil {
+		return nil, err
+	}
+	data := s.buf.Bytes()
+	s.marshal = append(s.marshal, &data[0])
+	return data, nil
+}
+
+// TestCodec_ResettableSerializer .
+func TestCodec_ResettableSerializer(t *testing.T) {
+	created := 0
+	shared := &bufferSerializer{created: &created}
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, shared)
+	assert.Equal(t, 1, created)
+	long, short := "a longer first request", "second"
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 1}, long))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 2}, short))
+
+	// 两次编码复用同一个缓冲区
+	enc := client.(*clientCodec).encoder.s.(*bufferSerializer)
+	assert.Len(t, enc.marshal, 2)
+	assert.Same(t, enc.marshal[0], enc.marshal[1])
+	assert.Nil(t, shared.marshal)
+
+	// 第二个请求不含第一个请求的残留数据
+	server := NewServerCodec(conn, serializer.JSON)
+	var args string
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+	assert.Nil(t, server.ReadRequestBody(&args))
+	assert.Equal(t, long, args)
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+	assert.Nil(t, server.ReadRequestBody(&args))
+	assert.Equal(t, short, args)
+
+	// 每个连接各自创建实例
+	NewServerCodec(newBuffer(nil), shared)
+	assert.Equal(t, 2, created)
+}
+
+// TestCodec_Traffic .
+func TestCodec_Traffic(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{"test-1", nil},
+		{"test-2", []Option{WithoutBuffering()}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := newBuffer(nil)
+			client := NewClientCodec(conn, compressor.Raw, serializer.Raw, c.opts...).(*clientCodec)
+			server := NewServerCodec(conn, serializer.Raw, c.opts...).(*serverCodec)
+			body := []byte("0123456789abcdef")
+
+			assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "BlobService.Echo", Seq: 1}, body))
+			// 帧长度、请求头和请求体
+			h, _ := splitRequest(t, conn.Bytes())
+			headerLen := len(h.Marshal())
+			request := uint64(uvarintSize(headerLen) + headerLen + len(body))
+			assert.Equal(t, uint64(conn.Len()), request)
+			assert.Equal(t, Traffic{BytesWritten: request}, client.Traffic())
+
+			assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+			var args []byte
+			assert.Nil(t, server.ReadRequestBody(&args))
+			assert.Equal(t, Traffic{BytesRead: request}, server.Traffic())
+
+			assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: "BlobService.Echo", Seq: 1}, args))
+			response := uint64(conn.Len())
+			assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+			var reply []byte
+			assert.Nil(t, client.ReadResponseBody(&reply))
+			assert.Equal(t, Traffic{BytesRead: response, BytesWritten: request}, client.Traffic())
+			assert.Equal(t, Traffic{BytesRead: request, BytesWritten: response}, server.Traffic())
+		})
+	}
+}
+
+// uvarintSize the length of the uvarint encoding of n
+func uvarintSize(n int) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(n))
+}
+
+// TestCodec_HMAC .
+func TestCodec_HMAC(t *testing.T) {
+	key := []byte("shared secret")
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Gzip, serializer.Proto, WithHMACKey(key))
+	server := NewServerCodec(conn, serializer.Proto, WithHMACKey(key))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, &pb.ArithRequest{A: 1, B: 2}))
+	h, _ := splitRequest(t, conn.Bytes())
+	assert.NotZero(t, h.Flags&header.FlagSigned)
+	assert.Len(t, h.MAC, 32)
+
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	args := &pb.ArithRequest{}
+	assert.Nil(t, server.ReadRequestBody(args))
+	assert.Equal(t, 2.0, args.B)
+	assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+		&pb.ArithResponse{C: 3}))
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.ReadResponseBody(reply))
+	assert.Equal(t, 3.0, reply.C)
+
+	// 篡改的响应无法通过验证
+	conn.Reset()
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 2}, &pb.ArithRequest{A: 1, B: 2}))
+	assert.Nil(t, server.ReadRequestHeader(request))
+	assert.Nil(t, server.ReadRequestBody(args))
+	assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+		&pb.ArithResponse{C: 3}))
+	data := conn.Bytes()
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	assert.Equal(t, AuthenticationError, client.ReadResponseBody(reply))
+
+	cases := []struct {
+		name      string
+		clientKey []byte
+		tamper    bool
+		skip      bool  // the body is discarded, e.g. the method is not found
+		headerErr error // error reading the request header
+		bodyErr   error // error reading the request body
+	}{
+		{"test-1", key, true, false, nil, AuthenticationError},
+		{"test-2", []byte("other key"), false, false, nil, AuthenticationError},
+		{"test-3", nil, false, false, AuthenticationError, nil},
+		{"test-4", key, true, true, nil, AuthenticationError},
+		{"test-5", []byte("other key"), false, true, nil, AuthenticationError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := newBuffer(nil)
+			client := NewClientCodec(conn, compressor.Raw, serializer.Proto, WithHMACKey(c.clientKey))
+			assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+				&pb.ArithRequest{A: 1, B: 2}))
+			if c.tamper {
+				// 篡改请求体，签名先于校验和被检查
+				data := conn.Bytes()
+				data[len(data)-1] ^= 0xff
+			}
+			server := NewServerCodec(conn, serializer.Proto, WithHMACKey(key))
+			err := server.ReadRequestHeader(&rpc.Request{})
+			assert.Equal(t, c.headerErr, err)
+			if err != nil {
+				return
+			}
+			var args any = &pb.ArithRequest{}
+			if c.skip {
+				args = nil
+			}
+			assert.Equal(t, c.bodyErr, server.ReadRequestBody(args))
+			// 验证失败后连接不再读取请求
+			assert.Equal(t, AuthenticationError, server.ReadRequestHeader(&rpc.Request{}))
+		})
+	}
+}
+
+// TestCodec_Padding .
+func TestCodec_Padding(t *testing.T) {
+	cases := []struct {
+		name   string
+		max    int
+		size   int // length of the body
+		bucket int // length of the padded body
+	}{
+		{"test-1", 1024, 1, 32},
+		{"test-2", 1024, 33, 64},
+		{"test-3", 1024, 512, 512},
+		{"test-4", 1024, 1000, 1024},
+		{"test-5", 1024, 1025, 2048},
+		{"test-6", 1000, 600, 1000},
+		{"test-7", 16, 3, 16},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.bucket, newPadder(c.max).bucket(c.size))
+		})
+	}
+
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Gzip, serializer.Proto, WithPadding(1024), WithHMACKey([]byte("key")))
+	server := NewServerCodec(conn, serializer.Proto, WithPadding(1024), WithHMACKey([]byte("key")))
+	for i, args := range []*pb.ArithRequest{{A: 1}, {A: 1, B: 2}, {A: 1.5, B: 1e300}} {
+		conn.Reset()
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: uint64(i)}, args))
+		h, body := splitRequest(t, conn.Bytes())
+		// 发送的请求体长度落在区间上，请求头记录补齐前的长度
+		assert.NotZero(t, h.Flags&header.FlagPadded)
+		assert.Zero(t, len(body)&(len(body)-1))
+		assert.Equal(t, newPadder(1024).bucket(int(h.BodyLen)), len(body))
+
+		request := &rpc.Request{}
+		assert.Nil(t, server.ReadRequestHeader(request))
+		got := &pb.ArithRequest{}
+		assert.Nil(t, server.ReadRequestBody(got))
+		assert.Equal(t, args.A, got.A)
+		assert.Equal(t, args.B, got.B)
+
+		conn.Reset()
+		assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+			&pb.ArithResponse{C: args.A + args.B}))
+		assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.ReadResponseBody(reply))
+		assert.Equal(t, args.A+args.B, reply.C)
+	}
+
+	// 不补齐的对端同样能读取补齐的消息，补齐后的长度不超过消息的上限
+	conn.Reset()
+	client = NewClientCodec(conn, compressor.Raw, serializer.Proto, WithPadding(1024), WithMaxMessageSize(20))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, &pb.ArithRequest{A: 1}))
+	h, body := splitRequest(t, conn.Bytes())
+	assert.Equal(t, 20, len(body))
+	assert.Equal(t, uint32(9), h.BodyLen)
+	server = NewServerCodec(conn, serializer.Proto)
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+	got := &pb.ArithRequest{}
+	assert.Nil(t, server.ReadRequestBody(got))
+	assert.Equal(t, 1.0, got.A)
+
+	// 大小限制只比较补齐前的长度
+	conn.Reset()
+	client = NewClientCodec(conn, compressor.Raw, serializer.Proto, WithPadding(1024))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, &pb.ArithRequest{A: 1}))
+	server = NewServerCodec(conn, serializer.Proto, WithMaxRequestSize(20))
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+	assert.Nil(t, server.ReadRequestBody(got))
+
+	lens := []struct {
+		name    string
+		flags   uint8
+		n       uint32 // length received
+		bodyLen uint32
+		size    uint32 // length the limits apply to
+	}{
+		{"test-1", 0, 100, 0, 100},
+		{"test-2", header.FlagPadded, 32, 9, 9},
+		{"test-3", header.FlagPadded, 128, 100, 100},
+		{"test-4", header.FlagPadded, 1 << 20, 9, 1 << 20},
+		{"test-5", header.FlagPadded, 32, 64, 32},
+	}
+	for _, c := range lens {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.size, unpaddedLen(c.flags, c.n, c.bodyLen))
+		})
+	}
+}
+
+// TestCodec_FallbackSerializer .
+func TestCodec_FallbackSerializer(t *testing.T) {
+	conn := newBuffer(nil)
+	// 服务端不认识的序列化器，请求头不带序列化格式
+	migrated := struct{ serializer.JSONSerializer }{}
+	client := NewClientCodec(conn, compressor.Raw, migrated)
+	server := NewServerCodec(conn, serializer.Proto, WithFallbackSerializer(serializer.JSON))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1}, &pb.ArithRequest{A: 1, B: 2}))
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	args := &pb.ArithRequest{}
+	assert.Nil(t, server.ReadRequestBody(args))
+	assert.Equal(t, 1.0, args.A)
+	assert.Equal(t, 2.0, args.B)
+	ctx, _ := server.(*serverCodec).pending.load(request.Seq)
+	assert.True(t, ctx.fallback)
+
+	// 响应同样以备用序列化器编码
+	assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+		&pb.ArithResponse{C: 3}))
+	assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+	assert.Equal(t, serializer.JSONType, client.(*clientCodec).response.SerializeType)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.ReadResponseBody(reply))
+	assert.Equal(t, 3.0, reply.C)
+
+	// 两个序列化器都无法解码时返回两者的错误
+	conn.Reset()
+	client = NewClientCodec(conn, compressor.Raw, serializer.Raw)
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 2}, []byte("not json")))
+	assert.Nil(t, server.ReadRequestHeader(request))
+	err := server.ReadRequestBody(args)
+	var fe *FallbackError
+	assert.True(t, errors.As(err, &fe))
+	assert.Equal(t, serializer.NotBytesError, fe.Err)
+	assert.NotNil(t, fe.FallbackErr)
+	assert.True(t, errors.Is(err, serializer.NotBytesError))
+	assert.Contains(t, err.Error(), "fallback serializer")
+}
+
+// TestCodec_ResponseBatching .
+func TestCodec_ResponseBatching(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Proto)
+	server := NewServerCodec(conn, serializer.Proto, WithResponseBatching(time.Hour, 0))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: uint64(i)},
+			&pb.ArithRequest{A: float64(i)}))
+	}
+	var requests []*rpc.Request
+	for i := 0; i < 3; i++ {
+		request := &rpc.Request{}
+		assert.Nil(t, server.ReadRequestHeader(request))
+		assert.Nil(t, server.ReadRequestBody(&pb.ArithRequest{}))
+		requests = append(requests, request)
+	}
+	for i, request := range requests {
+		assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+			&pb.ArithResponse{C: float64(i)}))
+	}
+	// 窗口结束前响应留在缓冲区中，关闭时发出
+	assert.Zero(t, conn.Len())
+	assert.Nil(t, server.Close())
+	for i := 0; i < 3; i++ {
+		response := &rpc.Response{}
+		assert.Nil(t, client.ReadResponseHeader(response))
+		assert.Equal(t, uint64(i), response.Seq)
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.ReadResponseBody(reply))
+		assert.Equal(t, float64(i), reply.C)
+	}
+}
+
+// TestCodec_CompressDictionary .
+func TestCodec_CompressDictionary(t *testing.T) {
+	dict := []byte(`{"user_id":,"user_name":"","email":"@example.com"}`)
+	body := []byte(`{"user_id":7,"user_name":"carol","email":"carol@example.com"}`)
+	cases := []struct {
+		name       string
+		clientDict []byte
+		serverDict []byte
+		expect     error
+	}{
+		{"test-1", dict, dict, nil},
+		{"test-2", dict, []byte("another dictionary"), compressor.DictionaryMismatchError},
+		{"test-3", nil, dict, compressor.DictionaryMismatchError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := newBuffer(nil)
+			client := NewClientCodec(conn, compressor.Flate, serializer.Raw, WithCompressDictionary(c.clientDict))
+			server := NewServerCodec(conn, serializer.Raw, WithCompressDictionary(c.serverDict))
+			for seq := uint64(1); seq <= 2; seq++ {
+				assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "BlobService.Echo", Seq: seq}, body))
+				request := &rpc.Request{}
+				assert.Nil(t, server.ReadRequestHeader(request))
+				var args []byte
+				err := server.ReadRequestBody(&args)
+				if c.expect != nil {
+					var de *DecompressError
+					assert.True(t, errors.As(err, &de))
+					assert.True(t, errors.Is(err, c.expect))
+					return
+				}
+				assert.Nil(t, err)
+				assert.Equal(t, body, args)
+
+				err = server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq}, args)
+				assert.Nil(t, err)
+				assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+				var reply []byte
+				assert.Nil(t, client.ReadResponseBody(&reply))
+				assert.Equal(t, body, reply)
+			}
+		})
+	}
+}
+
+// TestCodec_CompressFallback .
+func TestCodec_CompressFallback(t *testing.T) {
+	random := make([]byte, 4096)
+	_, err := rand.Read(random)
+	assert.Nil(t, err)
+	compressible := bytes.Repeat([]byte("tinyrpc"), 1024)
+
+	cases := []struct {
+		name     string
+		body     []byte
+		fallback bool
+		expect   compressor.CompressType
+	}{
+		{"test-1", random, true, compressor.Raw},
+		{"test-2", random, false, compressor.Gzip},
+		{"test-3", compressible, true, compressor.Gzip},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var opts []Option
+			if c.fallback {
+				opts = append(opts, WithCompressFallback())
+			}
+			conn := newBuffer(nil)
+			client := NewClientCodec(conn, compressor.Gzip, serializer.Raw, opts...)
+			assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "BlobService.Echo", Seq: 1}, c.body))
+
+			h, body := splitRequest(t, conn.Bytes())
+			assert.Equal(t, c.expect, h.CompressType)
+			if c.expect == compressor.Raw {
+				assert.Equal(t, c.body, body)
+			} else {
+				assert.NotEqual(t, c.body, body)
+			}
+
+			server := NewServerCodec(conn, serializer.Raw, opts...)
+			request := &rpc.Request{}
+			assert.Nil(t, server.ReadRequestHeader(request))
+			var args []byte
+			assert.Nil(t, server.ReadRequestBody(&args))
+			assert.Equal(t, c.body, args)
+
+			// 响应按同样的规则选择压缩格式，客户端仍可解码
+			conn.Reset()
+			err := server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq}, args)
+			assert.Nil(t, err)
+			assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+			assert.Equal(t, c.expect, client.(*clientCodec).response.CompressType)
+			var reply []byte
+			assert.Nil(t, client.ReadResponseBody(&reply))
+			assert.Equal(t, c.body, reply)
+		})
+	}
+}
+
+// TestCodec_ChecksumPolynomial .
+func TestCodec_ChecksumPolynomial(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Proto, WithChecksum(checksum.Crc32))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: 1},
+		&pb.ArithRequest{A: 1, B: 2}))
+
+	// 校验和按 IEEE 多项式计算，请求头却声明 Castagnoli
+	h, body := splitRequest(t, conn.Bytes())
+	h.ChecksumType = checksum.Crc32C
+	data := h.Marshal()
+	buf := newBuffer(nil)
+	assert.Nil(t, sendFrame(buf, data))
+	buf.Write(body)
+
+	server := NewServerCodec(buf, serializer.Proto)
+	assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+	assert.Equal(t, UnexpectedChecksumError, server.ReadRequestBody(&pb.ArithRequest{}))
+}
@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"io"
+	"time"
+)
+
+// deadliner is implemented by connections supporting deadlines, such as net.Conn
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// deadline applies the configured timeouts to the connection
+type deadline struct {
+	conn  deadliner // nil if no timeout is configured or the connection lacks deadlines
+	idle  time.Duration
+	read  time.Duration
+	write time.Duration
+}
+
+func newDeadline(conn io.ReadWriteCloser, o options) deadline {
+	d := deadline{idle: o.idleTimeout, read: o.readTimeout, write: o.writeTimeout}
+	if c, ok := conn.(deadliner); ok && (d.idle > 0 || d.read > 0 || d.write > 0) {
+		d.conn = c
+	}
+	return d
+}
+
+// handshake bound the handshake, its read by the idle timeout, or by the read timeout without one
+func (d *deadline) handshake() {
+	if d.conn == nil {
+		return
+	}
+	timeout := d.idle
+	if timeout <= 0 {
+		timeout = d.read
+	}
+	d.conn.SetReadDeadline(after(timeout))
+	d.conn.SetWriteDeadline(after(d.write))
+}
+
+// waitHeader bound the wait for the next header by the idle timeout
+func (d *deadline) waitHeader() {
+	if d.conn != nil {
+		d.conn.SetReadDeadline(after(d.idle))
+	}
+}
+
+// readBody bound the read of the body announced by a header by the read timeout
+func (d *deadline) readBody() {
+	if d.conn != nil {
+		d.conn.SetReadDeadline(after(d.read))
+	}
+}
+
+// writeMessage bound the write of a whole message by the write timeout
+func (d *deadline) writeMessage() {
+	if d.conn != nil {
+		d.conn.SetWriteDeadline(after(d.write))
+	}
+}
+
+// after get the deadline timeout from now, zero timeout means no deadline
+func after(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
@@ -0,0 +1,55 @@
+package codec
+
+import (
+	"reflect"
+	"sync"
+	"tiny_rpc/compressor"
+)
+
+// dictionary the preset dictionary of a connection and the compressors using it, which are
+// created on first use. Without data it gives the registered compressors as they are
+type dictionary struct {
+	registry *Registry
+	data     []byte
+	mutex    sync.Mutex
+	comps    map[compressor.CompressType]dictCompressor
+}
+
+// dictCompressor a compressor using the dictionary
+type dictCompressor struct {
+	base reflect.Type // type of the registered compressor it was derived from
+	comp compressor.Compressor
+}
+
+// newDictionary create the dictionary of a connection looking up the compressors in registry,
+// a dictionary without data only looks them up
+func newDictionary(registry *Registry, data []byte) *dictionary {
+	d := &dictionary{registry: registry, data: data}
+	if len(data) > 0 {
+		d.comps = make(map[compressor.CompressType]dictCompressor)
+	}
+	return d
+}
+
+// compressor look up the compressor of t, using the dictionary when the registered compressor
+// implements compressor.DictCompressor
+func (d *dictionary) compressor(t compressor.CompressType) (compressor.Compressor, bool) {
+	comp, ok := d.registry.compressor(t)
+	if !ok || len(d.data) == 0 {
+		return comp, ok
+	}
+	dc, ok := comp.(compressor.DictCompressor)
+	if !ok {
+		return comp, true
+	}
+	base := reflect.TypeOf(comp)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	// 注册了其他类型的压缩器时重新创建
+	if e, ok := d.comps[t]; ok && e.base == base {
+		return e.comp, true
+	}
+	e := dictCompressor{base: base, comp: dc.WithDictionary(d.data)}
+	d.comps[t] = e
+	return e.comp, true
+}
@@ -0,0 +1,35 @@
+package codec
+
+import (
+	"sync"
+	"tiny_rpc/serializer"
+)
+
+// encoder marshals the outgoing bodies of a connection with its own instance of a
+// serializer.Resettable, which is reset before each body
+type encoder struct {
+	mutex sync.Mutex // held from Reset until the body is written
+	s     serializer.Resettable
+}
+
+// newEncoder create the encoder of a connection, nil if s is not resettable
+func newEncoder(s serializer.Serializer) *encoder {
+	rs, ok := s.(serializer.Resettable)
+	if !ok {
+		return nil
+	}
+	return &encoder{s: rs.New()}
+}
+
+// marshal encode v with the connection instance, or with s when the connection has no encoder.
+// The returned bytes may belong to the encoder, release must be called once they are written
+func (e *encoder) marshal(s serializer.Serializer, v any) (data []byte, release func(), err error) {
+	if e == nil {
+		data, err = s.Marshal(v)
+		return data, func() {}, err
+	}
+	e.mutex.Lock()
+	e.s.Reset()
+	data, err = e.s.Marshal(v)
+	return data, e.mutex.Unlock, err
+}
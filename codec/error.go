@@ -1,10 +1,156 @@
 package codec
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"tiny_rpc/compressor"
+)
 
 var (
 	InvalidSequenceError        = errors.New("invalid sequence number in response")
 	UnexpectedChecksumError     = errors.New("unexpected checksum")
 	NotFoundCompressorError     = errors.New("not found compressor")
+	CompressorUnregisteredError = errors.New("compressor unregistered during the call")
+	NotFoundChecksumError       = errors.New("not found checksum")
+	NotFoundSerializerError     = errors.New("not found serializer")
+	UnsupportedHandshakeError   = errors.New("unsupported handshake version")
+	MessageTooLargeError        = errors.New("message exceeds the max message size")
+	ResponseTooLargeError       = errors.New("response exceeds the max response size of the call")
 	CompressorTypeMismatchError = errors.New("request and response Compressor type mismatch")
+	SerializerMismatchError     = errors.New("client and server Serializer type mismatch")
+	DuplicateRequestIDError     = errors.New("request id reused while in flight")
+	TooManyPendingError         = errors.New("too many pending requests")
+	StreamedBodyError           = errors.New("streamed response body needs an *io.Reader reply")
+	StreamAbandonedError        = errors.New("streamed response body abandoned, the reader was not read in time")
+	AuthenticationError         = errors.New("message authentication failed")
 )
+
+// DecompressError a body which could not be decompressed, the peer sent corrupt data
+// rather than the connection failing
+type DecompressError struct {
+	CompressType compressor.CompressType
+	Err          error
+}
+
+func (e *DecompressError) Error() string {
+	return fmt.Sprintf("decompress body of compress type %d: %v", e.CompressType, e.Err)
+}
+
+func (e *DecompressError) Unwrap() error {
+	return e.Err
+}
+
+// FallbackError a request body which neither the serializer of the request nor the fallback
+// serializer could unmarshal, see WithFallbackSerializer
+type FallbackError struct {
+	Err         error // error of the serializer of the request
+	FallbackErr error // error of the fallback serializer
+}
+
+func (e *FallbackError) Error() string {
+	return fmt.Sprintf("unmarshal body: %v; fallback serializer: %v", e.Err, e.FallbackErr)
+}
+
+func (e *FallbackError) Unwrap() error {
+	return e.Err
+}
+
+// UnsupportedCompressorCode the error code of the error responses carrying an UnsupportedCompressorError
+const UnsupportedCompressorCode uint32 = 0xffff0100
+
+// UnsupportedCompressorError a request compressed with a compress type the server has no compressor of,
+// the server answers it with UnsupportedCompressorCode. errors.Is(err, NotFoundCompressorError) holds
+type UnsupportedCompressorError struct {
+	CompressType compressor.CompressType   // compress type of the request
+	Supported    []compressor.CompressType // compress types the server supports, in ascending order
+}
+
+func (e *UnsupportedCompressorError) Error() string {
+	supported := make([]string, len(e.Supported))
+	for i, t := range e.Supported {
+		supported[i] = strconv.FormatUint(uint64(t), 10)
+	}
+	return fmt.Sprintf("%v: compress type %d, supported %s", NotFoundCompressorError, e.CompressType,
+		strings.Join(supported, ","))
+}
+
+func (e *UnsupportedCompressorError) Unwrap() error {
+	return NotFoundCompressorError
+}
+
+// ErrorCode .
+func (e *UnsupportedCompressorError) ErrorCode() uint32 {
+	return UnsupportedCompressorCode
+}
+
+// ParseUnsupportedCompressorError recover the UnsupportedCompressorError from the message of an error
+// response carrying UnsupportedCompressorCode
+func ParseUnsupportedCompressorError(msg string) (*UnsupportedCompressorError, bool) {
+	prefix := NotFoundCompressorError.Error() + ": compress type "
+	if !strings.HasPrefix(msg, prefix) {
+		return nil, false
+	}
+	t, list, ok := strings.Cut(msg[len(prefix):], ", supported ")
+	if !ok {
+		return nil, false
+	}
+	n, err := strconv.ParseUint(t, 10, 16)
+	if err != nil {
+		return nil, false
+	}
+	e := &UnsupportedCompressorError{CompressType: compressor.CompressType(n)}
+	if list == "" {
+		return e, true
+	}
+	for _, field := range strings.Split(list, ",") {
+		n, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return nil, false
+		}
+		e.Supported = append(e.Supported, compressor.CompressType(n))
+	}
+	return e, true
+}
+
+// CodeError an error carrying an application error code. Given as the body of an error response,
+// any value with an ErrorCode method has its code sent in the response header
+type CodeError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *CodeError) Error() string {
+	return e.Message
+}
+
+// ErrorCode .
+func (e *CodeError) ErrorCode() uint32 {
+	return e.Code
+}
+
+// codeErrorPrefix starts the message of a coded error response handed to rpc.Client,
+// which only keeps the error message of a response
+const codeErrorPrefix = "rpc error: code = "
+
+// formatCodeError format a coded error response as the message rpc.Client reports
+func formatCodeError(code uint32, msg string) string {
+	return codeErrorPrefix + strconv.FormatUint(uint64(code), 10) + " desc = " + msg
+}
+
+// ParseCodeError recover the CodeError from the message rpc.Client reports for a coded error response
+func ParseCodeError(msg string) (*CodeError, bool) {
+	if !strings.HasPrefix(msg, codeErrorPrefix) {
+		return nil, false
+	}
+	code, desc, ok := strings.Cut(msg[len(codeErrorPrefix):], " desc = ")
+	if !ok {
+		return nil, false
+	}
+	n, err := strconv.ParseUint(code, 10, 32)
+	if err != nil {
+		return nil, false
+	}
+	return &CodeError{Code: uint32(n), Message: desc}, true
+}
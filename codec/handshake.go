@@ -0,0 +1,114 @@
+package codec
+
+import (
+	"io"
+	"tiny_rpc/compressor"
+	"tiny_rpc/header"
+	"tiny_rpc/serializer"
+)
+
+// ConnInfo connection settings in effect, negotiated at handshake when it is enabled
+type ConnInfo struct {
+	MaxMessageSize uint32 // zero means unlimited
+
+	// 对端在握手时声明的能力，未开启握手或对端未声明时为空
+	Compressors []compressor.CompressType  // compress types the peer can decompress
+	Serializers []serializer.SerializeType // serialize types the peer can decode
+
+	CompressType  compressor.CompressType  // compressor of the client requests, set by client codecs
+	SerializeType serializer.SerializeType // serializer of the client requests, set by client codecs
+}
+
+// minLimit take the smaller limit of both peers, zero means unlimited
+func minLimit(a, b uint32) uint32 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// newHandshake the handshake advertising the local settings and the formats registered in r
+func newHandshake(info ConnInfo, r *Registry) *header.Handshake {
+	return &header.Handshake{
+		Version:        header.HandshakeVersion,
+		MaxMessageSize: info.MaxMessageSize,
+		Compressors:    r.compressTypes(),
+		Serializers:    r.serializeTypes(),
+	}
+}
+
+// clientHandshake send the client settings and negotiate with the settings replied by the server
+func clientHandshake(r io.Reader, w io.Writer, info ConnInfo, registry *Registry) (ConnInfo, error) {
+	if err := sendFrame(w, newHandshake(info, registry).Marshal()); err != nil {
+		return info, err
+	}
+	if err := flush(w); err != nil {
+		return info, err
+	}
+	return readHandshake(r, info)
+}
+
+// serverHandshake wait for the client settings and reply with the server settings
+func serverHandshake(r io.Reader, w io.Writer, info ConnInfo, registry *Registry) (ConnInfo, error) {
+	negotiated, err := readHandshake(r, info)
+	if err != nil {
+		return info, err
+	}
+	if err = sendFrame(w, newHandshake(info, registry).Marshal()); err != nil {
+		return info, err
+	}
+	return negotiated, flush(w)
+}
+
+// readHandshake read the settings of the peer and negotiate with the local ones
+func readHandshake(r io.Reader, info ConnInfo) (ConnInfo, error) {
+	data, err := recvFrame(r, info.MaxMessageSize)
+	if err != nil {
+		return info, err
+	}
+	peer := &header.Handshake{}
+	if err = peer.Unmarshal(data); err != nil {
+		return info, err
+	}
+	if peer.Version != header.HandshakeVersion {
+		return info, UnsupportedHandshakeError
+	}
+	info.MaxMessageSize = minLimit(info.MaxMessageSize, peer.MaxMessageSize)
+	info.Compressors = peer.Compressors
+	info.Serializers = peer.Serializers
+	return info, nil
+}
+
+// negotiateCompressor pick the first preferred compressor registered in r which the server can
+// decompress, Raw when there is none or the server did not advertise its compressors
+func negotiateCompressor(preferred, supported []compressor.CompressType, r *Registry) compressor.CompressType {
+	for _, t := range preferred {
+		// 本端未注册的压缩格式无法使用
+		if _, ok := r.compressor(t); !ok {
+			continue
+		}
+		for _, s := range supported {
+			if t == s {
+				return t
+			}
+		}
+	}
+	return compressor.Raw
+}
+
+// negotiateSerializer pick the first preferred serializer registered in r which the server can
+// decode, false when there is none or the server did not advertise its serializers
+func negotiateSerializer(preferred, supported []serializer.SerializeType, r *Registry) (serializer.SerializeType, serializer.Serializer, bool) {
+	for _, t := range preferred {
+		s, ok := r.serializer(t)
+		if !ok {
+			continue
+		}
+		for _, st := range supported {
+			if t == st {
+				return t, s, true
+			}
+		}
+	}
+	return 0, nil, false
+}
@@ -0,0 +1,67 @@
+package codec
+
+import "tiny_rpc/header"
+
+// HeaderCodec encodes the request and response headers carried in the header frame,
+// the bodies are still framed and encoded by the codecs
+type HeaderCodec interface {
+	MarshalRequest(h *header.RequestHeader) ([]byte, error)
+	UnmarshalRequest(data []byte, h *header.RequestHeader) error
+	MarshalResponse(h *header.ResponseHeader) ([]byte, error)
+	UnmarshalResponse(data []byte, h *header.ResponseHeader) error
+}
+
+// headerCodecTo is implemented by header codecs able to encode into a buffer reused across messages
+type headerCodecTo interface {
+	MarshalRequestTo(buf []byte, h *header.RequestHeader) ([]byte, error)
+	MarshalResponseTo(buf []byte, h *header.ResponseHeader) ([]byte, error)
+}
+
+// marshalRequest encode h with hc, reusing buf when hc supports it
+func marshalRequest(hc HeaderCodec, buf []byte, h *header.RequestHeader) ([]byte, error) {
+	if to, ok := hc.(headerCodecTo); ok {
+		return to.MarshalRequestTo(buf, h)
+	}
+	return hc.MarshalRequest(h)
+}
+
+// marshalResponse encode h with hc, reusing buf when hc supports it
+func marshalResponse(hc HeaderCodec, buf []byte, h *header.ResponseHeader) ([]byte, error) {
+	if to, ok := hc.(headerCodecTo); ok {
+		return to.MarshalResponseTo(buf, h)
+	}
+	return hc.MarshalResponse(h)
+}
+
+// BinaryHeaderCodec the default HeaderCodec using the fixed binary layout of the header package
+type BinaryHeaderCodec struct{}
+
+// MarshalRequest .
+func (BinaryHeaderCodec) MarshalRequest(h *header.RequestHeader) ([]byte, error) {
+	return h.Marshal(), nil
+}
+
+// UnmarshalRequest .
+func (BinaryHeaderCodec) UnmarshalRequest(data []byte, h *header.RequestHeader) error {
+	return h.Unmarshal(data)
+}
+
+// MarshalResponse .
+func (BinaryHeaderCodec) MarshalResponse(h *header.ResponseHeader) ([]byte, error) {
+	return h.Marshal(), nil
+}
+
+// UnmarshalResponse .
+func (BinaryHeaderCodec) UnmarshalResponse(data []byte, h *header.ResponseHeader) error {
+	return h.Unmarshal(data)
+}
+
+// MarshalRequestTo .
+func (BinaryHeaderCodec) MarshalRequestTo(buf []byte, h *header.RequestHeader) ([]byte, error) {
+	return h.MarshalTo(buf), nil
+}
+
+// MarshalResponseTo .
+func (BinaryHeaderCodec) MarshalResponseTo(buf []byte, h *header.ResponseHeader) ([]byte, error) {
+	return h.MarshalTo(buf), nil
+}
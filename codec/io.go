@@ -30,9 +30,23 @@ func sendFrame(w io.Writer, data []byte) (err error) {
 	return
 }
 
-// recvFrame 从IO中读取uvarint类型的 size ，表示要接收数据的长度，随后将该从IO流中读取该 size 长度字节串
-func recvFrame(r io.Reader) (data []byte, err error) {
-	size, err := binary.ReadUvarint(r.(io.ByteReader))
+// sendMessage 写入消息头帧和消息体，未经缓冲的连接用一次 writev 发出，避免拆成多次写入
+func sendMessage(w io.Writer, header, body []byte) error {
+	if _, ok := w.(interface{ Flush() error }); ok {
+		if err := sendFrame(w, header); err != nil {
+			return err
+		}
+		return write(w, body)
+	}
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(header)))
+	return writeBuffers(w, net.Buffers{size[:n], header, body})
+}
+
+// recvFrame 从IO中读取uvarint类型的 size ，表示要接收数据的长度，随后将该从IO流中读取该 size 长度字节串，
+// limit 不为0时拒绝超过 limit 的帧
+func recvFrame(r io.Reader, limit uint32) (data []byte, err error) {
+	size, err := recvFrameSize(r, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -46,11 +60,78 @@ func recvFrame(r io.Reader) (data []byte, err error) {
 	return data, err
 }
 
+// recvFrameInto 与 recvFrame 相同，但读入 b 提供的缓冲区，调用方用完后需调用 b.put 归还，
+// b 为 nil 时使用缓冲池中的缓冲区
+func recvFrameInto(r io.Reader, limit uint32, b *frameBuffer) (*[]byte, error) {
+	size, err := recvFrameSize(r, limit)
+	if err != nil {
+		return nil, err
+	}
+	buf := b.get(int(size))
+	if err = read(r, *buf); err != nil {
+		b.put(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// recvFrameSize 读取帧长度
+func recvFrameSize(r io.Reader, limit uint32) (uint64, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		// 不能包装为 bufio.Reader，否则会预读并丢失后续帧的数据
+		br = byteReader{r}
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, err
+	}
+	if limit != 0 && size > uint64(limit) {
+		return 0, MessageTooLargeError
+	}
+	return size, nil
+}
+
+// byteReader adapts an io.Reader to io.ByteReader by reading a single byte per call
+type byteReader struct {
+	io.Reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	var buf [1]byte
+	if err := read(b.Reader, buf[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// 帧之间的 EOF 是正常关闭，由 binary.ReadUvarint 判断是否截断
+			err = io.EOF
+		}
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// exceeds report whether size is over one of the limits, a zero limit is unlimited
+func exceeds(size uint32, limits ...uint32) bool {
+	for _, limit := range limits {
+		if limit != 0 && size > limit {
+			return true
+		}
+	}
+	return false
+}
+
+// flush send the data buffered by w, unbuffered writers have nothing to flush
+func flush(w io.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
 // write the data into IO stream
 func write(w io.Writer, data []byte) error {
 	for index := 0; index < len(data); {
 		n, err := w.Write(data[index:])
-		if _, ok := err.(net.Error); !ok {
+		if ne, ok := err.(net.Error); !ok || ne.Timeout() {
 			return err
 		}
 		index += n
@@ -58,12 +139,17 @@ func write(w io.Writer, data []byte) error {
 	return nil
 }
 
-// read from IO stream into byte array
+// read from IO stream into byte array, the data is part of a message so io.EOF is reported
+// as io.ErrUnexpectedEOF
 func read(r io.Reader, data []byte) error {
 	for index := 0; index < len(data); {
 		n, err := r.Read(data[index:])
-		if err != nil {
-			if _, ok := err.(net.Error); !ok {
+		if err == io.EOF && index+n < len(data) {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			// 超时不可重试，否则截止时间永远不会生效
+			if ne, ok := err.(net.Error); !ok || ne.Timeout() {
 				return err
 			}
 		}
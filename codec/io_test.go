@@ -0,0 +1,127 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// plainReader hides every method of the underlying reader except Read
+type plainReader struct {
+	r io.Reader
+}
+
+func (p plainReader) Read(data []byte) (int, error) {
+	return p.r.Read(data)
+}
+
+// TestRecvFrame_PlainReader .
+func TestRecvFrame_PlainReader(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, sendFrame(buf, []byte("hello")))
+	assert.Nil(t, sendFrame(buf, nil))
+	assert.Nil(t, sendFrame(buf, bytes.Repeat([]byte{0x1}, 300)))
+
+	r := plainReader{buf}
+	data, err := recvFrame(r, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	data, err = recvFrame(r, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+
+	data, err = recvFrame(r, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{0x1}, 300), data)
+
+	_, err = recvFrame(r, 0)
+	assert.Equal(t, io.EOF, err)
+}
+
+// TestRecvFrameInto_PlainReader .
+func TestRecvFrameInto_PlainReader(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, sendFrame(buf, []byte("hello")))
+	// 长度字段被截断
+	buf.WriteByte(0x80)
+
+	r := plainReader{buf}
+	data, err := recvFrameInto(r, 0, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), *data)
+	putBuffer(data)
+
+	_, err = recvFrameInto(r, 0, nil)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+// TestRecvFrameInto_Reuse .
+func TestRecvFrameInto_Reuse(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, sendFrame(buf, []byte("hello")))
+	assert.Nil(t, sendFrame(buf, []byte("world")))
+	assert.Nil(t, sendFrame(buf, bytes.Repeat([]byte{0x1}, 100)))
+
+	b := newFrameBuffer(16)
+	first, err := recvFrameInto(buf, 0, b)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), *first)
+	b.put(first)
+	// 相同大小的帧复用同一个缓冲区
+	second, err := recvFrameInto(buf, 0, b)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("world"), *second)
+	assert.Same(t, &(*first)[0], &(*second)[0])
+	b.put(second)
+
+	// 更大的帧使缓冲区增长
+	third, err := recvFrameInto(buf, 0, b)
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{0x1}, 100), *third)
+	assert.Equal(t, 100, cap(b.buf))
+	assert.Nil(t, newFrameBuffer(0))
+}
+
+// BenchmarkRecvFrame .
+func BenchmarkRecvFrame(b *testing.B) {
+	frame := bytes.Repeat([]byte{0x1}, 200)
+	cases := []struct {
+		name string
+		recv func(r io.Reader, fb *frameBuffer) error
+	}{
+		{"alloc", func(r io.Reader, _ *frameBuffer) error {
+			_, err := recvFrame(r, 0)
+			return err
+		}},
+		{"pooled", func(r io.Reader, _ *frameBuffer) error {
+			data, err := recvFrameInto(r, 0, nil)
+			putBuffer(data)
+			return err
+		}},
+		{"reused", func(r io.Reader, fb *frameBuffer) error {
+			data, err := recvFrameInto(r, 0, fb)
+			fb.put(data)
+			return err
+		}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			stream := bytes.NewBuffer(nil)
+			for i := 0; i < b.N; i++ {
+				_ = sendFrame(stream, frame)
+			}
+			r := bytes.NewReader(stream.Bytes())
+			fb := newFrameBuffer(256)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := c.recv(r, fb); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
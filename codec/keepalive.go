@@ -0,0 +1,72 @@
+package codec
+
+import (
+	"time"
+	"tiny_rpc/checksum"
+	"tiny_rpc/header"
+)
+
+// keepalive ping the server whenever the connection stayed idle for interval,
+// and close the connection if the pong does not arrive within timeout
+func (c *clientCodec) keepalive(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+		if time.Since(time.Unix(0, c.lastActive.Load())) < interval {
+			continue
+		}
+		if err := c.writePing(); err != nil {
+			c.closer.Close()
+			return
+		}
+		select {
+		case <-c.pong:
+		case <-time.After(timeout):
+			// 对端未响应，关闭连接使等待中的调用失败
+			c.closer.Close()
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// writePing send a ping request without body
+func (c *clientCodec) writePing() error {
+	h := &header.RequestHeader{Flags: header.FlagPing, ChecksumType: c.checksum}
+	if c.signer != nil {
+		if _, err := c.signer.signRequest(c.headers, nil, h, nil); err != nil {
+			return err
+		}
+	}
+	data, err := c.headers.MarshalRequest(h)
+	if err != nil {
+		return err
+	}
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	c.deadline.writeMessage()
+	if err = sendFrame(c.writer, data); err != nil {
+		return err
+	}
+	return flush(c.writer)
+}
+
+// receivePong wake up the keepalive goroutine waiting for the pong
+func (c *clientCodec) receivePong() {
+	select {
+	case c.pong <- struct{}{}:
+	default:
+	}
+}
+
+// writePong answer a ping request with the checksum type it declares
+func (s *serverCodec) writePong(checksumType checksum.ChecksumType) error {
+	h := &header.ResponseHeader{Flags: header.FlagPong, ChecksumType: checksumType}
+	return s.writeMessage(h, nil)
+}
@@ -0,0 +1,359 @@
+package codec
+
+import (
+	"bufio"
+	"io"
+	"time"
+	"tiny_rpc/checksum"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+)
+
+// Option provides options for codec
+type Option func(o *options)
+
+type options struct {
+	checksumType   checksum.ChecksumType
+	maxMessageSize uint32
+	handshake      bool
+	batchWindow    time.Duration
+	maxBatch       int
+	respWindow     time.Duration
+	maxRespBatch   int
+	idleTimeout    time.Duration
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	headerCodec    HeaderCodec
+	stats          StatsHandler
+
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+
+	rejectDuplicateIDs bool
+	maxPending         int
+
+	maxRequestSize  uint32
+	maxResponseSize uint32
+
+	readBufferSize  int
+	writeBufferSize int
+	unbuffered      bool
+	frameBufferSize int
+
+	streamBacklog int
+	streamTimeout time.Duration
+
+	compressPreference  []compressor.CompressType
+	serializePreference []serializer.SerializeType
+	compressFallback    bool
+
+	serviceSerializers map[string]serializer.SerializeType
+
+	dictionary  []byte
+	idGenerator func() uint64
+	registry    *Registry
+	hmacKey     []byte
+	serverName  string
+	padding     int
+
+	fallbackSerializer serializer.Serializer
+}
+
+// WithStreamBuffer buffer up to frames frames of each streamed response body the caller did not read
+// yet. Once the buffer is full the connection waits at most timeout for the caller to read, then the
+// stream fails with StreamAbandonedError so that the other responses are received. Non-positive values
+// keep the defaults of 8 frames and 10 seconds
+func WithStreamBuffer(frames int, timeout time.Duration) Option {
+	return func(o *options) {
+		if frames > 0 {
+			o.streamBacklog = frames
+		}
+		if timeout > 0 {
+			o.streamTimeout = timeout
+		}
+	}
+}
+
+// WithFallbackSerializer make the server codec decode the request bodies its serializer fails to
+// unmarshal with s, easing the migration of clients to another serializer. The requests decoded by s
+// are answered with s as well unless the client asked for another response serializer, and reported
+// by RequestStats.Fallback. A body neither can decode fails with a *FallbackError
+func WithFallbackSerializer(s serializer.Serializer) Option {
+	return func(o *options) {
+		o.fallbackSerializer = s
+	}
+}
+
+// WithPadding pad every body sent up to the next power of two, at most max bytes, and the bodies
+// over max up to a multiple of max, hiding their exact length. The header records the length without
+// the padding, which the peer strips before decompressing, the peer must know the FlagPadded flag.
+// WithMaxRequestSize and WithMaxResponseSize of the peer apply to the length without the padding
+func WithPadding(max int) Option {
+	return func(o *options) {
+		o.padding = max
+	}
+}
+
+// WithHMACKey sign every message with an HMAC-SHA256 of its header and body computed with key, and
+// reject the messages of the peer whose MAC does not match with AuthenticationError. Unlike the
+// checksum it detects tampering, both peers must use the same key
+func WithHMACKey(key []byte) Option {
+	return func(o *options) {
+		o.hmacKey = key
+	}
+}
+
+// WithRegistry look up compressors and serializers in r instead of the package registries
+func WithRegistry(r *Registry) Option {
+	return func(o *options) {
+		o.registry = r
+	}
+}
+
+// WithCompressFallback send the bodies which compression does not make smaller uncompressed,
+// their header carries the Raw compress type
+func WithCompressFallback() Option {
+	return func(o *options) {
+		o.compressFallback = true
+	}
+}
+
+// WithCompressDictionary compress and decompress the bodies with dict as preset dictionary, when the
+// compressor implements compressor.DictCompressor. Both peers must use the same dictionary, a body
+// compressed with another one fails to decompress
+func WithCompressDictionary(dict []byte) Option {
+	return func(o *options) {
+		o.dictionary = dict
+	}
+}
+
+// WithChecksum set the checksum algorithm used for outgoing bodies
+func WithChecksum(c checksum.ChecksumType) Option {
+	return func(o *options) {
+		o.checksumType = c
+	}
+}
+
+// WithoutChecksum skip computing checksums for outgoing bodies, useful on trusted local transports
+func WithoutChecksum() Option {
+	return func(o *options) {
+		o.checksumType = checksum.None
+	}
+}
+
+// WithMaxMessageSize limit the size of frames and bodies, zero means unlimited
+func WithMaxMessageSize(size uint32) Option {
+	return func(o *options) {
+		o.maxMessageSize = size
+	}
+}
+
+// WithMaxRequestSize make the server codec reject request bodies declaring more than size bytes
+// with MessageTooLargeError, before the body is read. Zero means unlimited
+func WithMaxRequestSize(size uint32) Option {
+	return func(o *options) {
+		o.maxRequestSize = size
+	}
+}
+
+// WithMaxResponseSize make the client codec reject response bodies declaring more than size bytes
+// with MessageTooLargeError, before the body is read. Zero means unlimited
+func WithMaxResponseSize(size uint32) Option {
+	return func(o *options) {
+		o.maxResponseSize = size
+	}
+}
+
+// WithCompressNegotiation make the client codec use the first of the preferred compressors the
+// server advertises at handshake, Raw when it supports none of them. It enables the handshake,
+// which the server must enable as well
+func WithCompressNegotiation(preferred ...compressor.CompressType) Option {
+	return func(o *options) {
+		o.handshake = true
+		o.compressPreference = preferred
+	}
+}
+
+// WithSerializerNegotiation make the client codec encode the requests with the first of the preferred
+// serializers the server advertises at handshake, keeping its own serializer when it supports none of
+// them. It enables the handshake, which the server must enable as well
+func WithSerializerNegotiation(preferred ...serializer.SerializeType) Option {
+	return func(o *options) {
+		o.handshake = true
+		o.serializePreference = preferred
+	}
+}
+
+// WithReadBufferSize set the size of the buffer reading the connection, zero means the bufio default
+func WithReadBufferSize(size int) Option {
+	return func(o *options) {
+		o.readBufferSize = size
+	}
+}
+
+// WithFrameBuffer read the frames and bodies of the connection into one buffer of initially size bytes,
+// grown to the largest message and reused across messages, instead of buffers of the shared pools.
+// It suits connections whose messages are of similar size, the buffer is held while the codec lives
+func WithFrameBuffer(size int) Option {
+	return func(o *options) {
+		o.frameBufferSize = size
+	}
+}
+
+// WithWriteBufferSize set the size of the buffer writing the connection, zero means the bufio default
+func WithWriteBufferSize(size int) Option {
+	return func(o *options) {
+		o.writeBufferSize = size
+	}
+}
+
+// WithoutBuffering read and write the connection directly instead of through bufio, saving a copy of
+// each message. A message is then sent with a single vectored write, but its frame length is read
+// one byte per call, which suits small messages on low latency transports. Batching has no effect
+func WithoutBuffering() Option {
+	return func(o *options) {
+		o.unbuffered = true
+	}
+}
+
+// WithHandshake exchange settings with the peer when the codec is created,
+// the peer must enable the handshake as well
+func WithHandshake() Option {
+	return func(o *options) {
+		o.handshake = true
+	}
+}
+
+// WithBatching delay flushing client requests for up to window so that requests
+// issued close together share a single write, maxBatch requests flush immediately
+func WithBatching(window time.Duration, maxBatch int) Option {
+	return func(o *options) {
+		o.batchWindow = window
+		o.maxBatch = maxBatch
+	}
+}
+
+// WithResponseBatching delay flushing server responses for up to window so that responses ready
+// close together, such as those of pipelined requests, share a single write. maxBatch responses
+// flush immediately, zero means no cap
+func WithResponseBatching(window time.Duration, maxBatch int) Option {
+	return func(o *options) {
+		o.respWindow = window
+		o.maxRespBatch = maxBatch
+	}
+}
+
+// WithIdleTimeout limit the wait for the next message header and for the handshake, the connection
+// must support deadlines
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.idleTimeout = timeout
+	}
+}
+
+// WithReadTimeout limit the read of a body once its header arrived, and the handshake without an idle
+// timeout. The connection must support deadlines
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.readTimeout = timeout
+	}
+}
+
+// WithWriteTimeout limit the write of each message, the connection must support deadlines
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.writeTimeout = timeout
+	}
+}
+
+// WithHeaderCodec set the encoding of the message headers, both peers must use the same one
+func WithHeaderCodec(hc HeaderCodec) Option {
+	return func(o *options) {
+		o.headerCodec = hc
+	}
+}
+
+// WithKeepalive ping the server after the client connection stayed idle for interval,
+// the connection is closed when the pong does not arrive within timeout, interval when not positive
+func WithKeepalive(interval, timeout time.Duration) Option {
+	return func(o *options) {
+		// 超时为零时 pong 不可能及时到达，改用间隔
+		if timeout <= 0 {
+			timeout = interval
+		}
+		o.keepaliveInterval = interval
+		o.keepaliveTimeout = timeout
+	}
+}
+
+// WithIDGenerator make the client codec write ids from gen into the request headers instead of the
+// sequence numbers of rpc.Client, for tracing for instance. The server echoes them in the responses.
+// An id still awaiting its response fails the call with DuplicateRequestIDError
+func WithIDGenerator(gen func() uint64) Option {
+	return func(o *options) {
+		o.idGenerator = gen
+	}
+}
+
+// WithRejectDuplicateIDs make the server codec fail with DuplicateRequestIDError when a client
+// reuses the id of a request still in flight, the connection can not be read any further
+func WithRejectDuplicateIDs() Option {
+	return func(o *options) {
+		o.rejectDuplicateIDs = true
+	}
+}
+
+// WithMaxPendingRequests make the client codec fail WriteRequest with TooManyPendingError
+// while n requests await their response, zero means unlimited
+func WithMaxPendingRequests(n int) Option {
+	return func(o *options) {
+		o.maxPending = n
+	}
+}
+
+// WithServiceSerializer make the client codec encode the requests of the methods starting with prefix,
+// "Service." for instance, with the registered serializer t instead of the connection serializer.
+// The longest matching prefix wins, a serializer chosen for the call takes precedence
+func WithServiceSerializer(prefix string, t serializer.SerializeType) Option {
+	return func(o *options) {
+		if o.serviceSerializers == nil {
+			o.serviceSerializers = make(map[string]serializer.SerializeType)
+		}
+		o.serviceSerializers[prefix] = t
+	}
+}
+
+// newReader buffer the reads of conn with the configured size
+func (o *options) newReader(conn io.Reader) io.Reader {
+	if o.unbuffered {
+		return conn
+	}
+	if o.readBufferSize > 0 {
+		return bufio.NewReaderSize(conn, o.readBufferSize)
+	}
+	return bufio.NewReader(conn)
+}
+
+// newWriter buffer the writes of conn with the configured size
+func (o *options) newWriter(conn io.Writer) io.Writer {
+	if o.unbuffered {
+		return conn
+	}
+	if o.writeBufferSize > 0 {
+		return bufio.NewWriterSize(conn, o.writeBufferSize)
+	}
+	return bufio.NewWriter(conn)
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		checksumType:  checksum.Crc32,
+		headerCodec:   BinaryHeaderCodec{},
+		streamBacklog: defaultStreamBacklog,
+		streamTimeout: defaultStreamTimeout,
+	}
+	for _, option := range opts {
+		option(&o)
+	}
+	return o
+}
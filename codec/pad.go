@@ -0,0 +1,88 @@
+package codec
+
+import "tiny_rpc/header"
+
+// minPadding the smallest size bucket of the padded bodies
+const minPadding = 32
+
+// padder pads the bodies sent up to the next size bucket so that their length reveals less of
+// their content, the buckets are the powers of two up to max, then the multiples of max
+type padder struct {
+	max int
+}
+
+// newPadder create the padder of the buckets capped at max, nil if max is not positive
+func newPadder(max int) *padder {
+	if max <= 0 {
+		return nil
+	}
+	return &padder{max: max}
+}
+
+// bucket the padded size of a body of n bytes
+func (p *padder) bucket(n int) int {
+	if n > p.max {
+		return (n + p.max - 1) / p.max * p.max
+	}
+	size := minPadding
+	for size < n {
+		size <<= 1
+	}
+	if size > p.max {
+		size = p.max
+	}
+	return size
+}
+
+// pad copy body followed by zeros up to its bucket into a pooled buffer which the caller puts
+// back, the padded body does not exceed limit unless limit is zero. Empty bodies end streams
+// and are not padded, neither does a nil padder pad anything, the returned buffer is nil then
+func (p *padder) pad(body []byte, limit uint32) ([]byte, *[]byte) {
+	if p == nil || len(body) == 0 {
+		return body, nil
+	}
+	size := p.bucket(len(body))
+	if limit != 0 && size > int(limit) {
+		size = int(limit)
+	}
+	// 超过限制的消息由接收方拒绝，补齐不能截断消息体
+	if size < len(body) {
+		size = len(body)
+	}
+	buf := getBuffer(size)
+	padded := *buf
+	n := copy(padded, body)
+	for i := n; i < len(padded); i++ {
+		padded[i] = 0
+	}
+	return padded, buf
+}
+
+// unpaddedLen the length the size limits apply to of a body of n bytes received with flags, which
+// is its length without the padding. Padding at most doubles a body or fills the smallest bucket,
+// a body padded beyond that is counted whole so that the padding does not bypass the limits
+func unpaddedLen(flags uint8, n, bodyLen uint32) uint32 {
+	if flags&header.FlagPadded == 0 || bodyLen > n {
+		return n
+	}
+	bound := 2 * uint64(bodyLen)
+	if bound < minPadding {
+		bound = minPadding
+	}
+	if uint64(n) > bound {
+		return n
+	}
+	return bodyLen
+}
+
+// unpad strip the padding following a body received with FlagPadded, n is the length of the body without it
+func unpad(flags uint8, body []byte, n uint32) ([]byte, error) {
+	if flags&header.FlagPadded == 0 {
+		return body, nil
+	}
+	// 其他请求头编码未必检查过补齐前的长度
+	if int(n) > len(body) {
+		return nil, header.MalformedHeaderError
+	}
+	return body[:n], nil
+}
@@ -0,0 +1,131 @@
+package codec
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"tiny_rpc/serializer"
+)
+
+// Metadata keys understood by the codecs
+const (
+	// MetaResponseSerializer asks the server to encode the reply with the given serializer.SerializeType
+	MetaResponseSerializer = "response-serializer"
+	// MetaMaxResponseSize caps the size in bytes of the compressed reply the client accepts
+	MetaMaxResponseSize = "max-response-size"
+)
+
+// Param wraps a request param with per-call metadata, WriteRequest writes
+// the metadata into the request header and serializes Value as the body
+type Param struct {
+	Value    any
+	Metadata map[string]string
+	// OnChunk receives the messages streamed back before the final response,
+	// it runs on the reading goroutine and must not block
+	OnChunk func(Chunk)
+	// Oneway asks the server not to respond, the request is not tracked as pending
+	Oneway bool
+	// SerializeType encodes the request with this registered serializer, the server replies
+	// with it as well. Zero uses the connection serializer
+	SerializeType serializer.SerializeType
+}
+
+// Chunk a message streamed back ahead of the final response of a call
+type Chunk struct {
+	data       []byte
+	serializer serializer.Serializer
+	err        error // error reading the chunk, the stream continues
+}
+
+// Decode unmarshal the message into v
+func (c Chunk) Decode(v any) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.serializer.Unmarshal(c.data, v)
+}
+
+// unwrapParam split a param passed to WriteRequest into its value and metadata
+func unwrapParam(param any) (any, map[string]string) {
+	if p, ok := param.(*Param); ok {
+		return p.Value, p.Metadata
+	}
+	return param, nil
+}
+
+// isNilParam report whether param carries no value, it is nil or a nil pointer
+func isNilParam(param any) bool {
+	if param == nil {
+		return true
+	}
+	v := reflect.ValueOf(param)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
+
+// resetParam zero the value param points to, clearing what a failed decoding left in it
+func resetParam(param any) {
+	if r, ok := param.(interface{ Reset() }); ok {
+		r.Reset()
+		return
+	}
+	if v := reflect.ValueOf(param); v.Kind() == reflect.Pointer && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+}
+
+// serializerOf look up the serializer of a serialize type in r, zero refers to the connection serializer
+func serializerOf(r *Registry, serializeType serializer.SerializeType, s serializer.Serializer) (serializer.Serializer, error) {
+	if serializeType == 0 {
+		return s, nil
+	}
+	if s, ok := r.serializer(serializeType); ok {
+		return s, nil
+	}
+	return nil, NotFoundSerializerError
+}
+
+// serializerFor pick the serializer of a request to method: the one chosen for the call, the one of the
+// longest matching service prefix, or the connection serializer s of type own. Others are looked up in r
+func serializerFor(r *Registry, method string, t serializer.SerializeType, prefixes map[string]serializer.SerializeType, s serializer.Serializer, own serializer.SerializeType) (serializer.Serializer, serializer.SerializeType, error) {
+	if t == 0 {
+		longest := -1
+		for prefix, pt := range prefixes {
+			if len(prefix) > longest && strings.HasPrefix(method, prefix) {
+				t, longest = pt, len(prefix)
+			}
+		}
+	}
+	if t == 0 || t == own {
+		return s, own, nil
+	}
+	if s, ok := r.serializer(t); ok {
+		return s, t, nil
+	}
+	return nil, 0, NotFoundSerializerError
+}
+
+// responseSerializeType parse the serializer requested for the reply, zero if absent or malformed
+func responseSerializeType(metadata map[string]string) serializer.SerializeType {
+	v, ok := metadata[MetaResponseSerializer]
+	if !ok {
+		return 0
+	}
+	t, err := strconv.ParseUint(v, 10, 8)
+	if err != nil {
+		return 0
+	}
+	return serializer.SerializeType(t)
+}
+
+// maxResponseSize parse the reply size limit of the call, zero if absent or malformed
+func maxResponseSize(metadata map[string]string) uint32 {
+	v, ok := metadata[MetaMaxResponseSize]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(n)
+}
@@ -0,0 +1,96 @@
+package codec
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// cacheLine size of the cache lines the shards are padded to
+const cacheLine = 64
+
+// pendingShards number of shards of a pendingMap
+const pendingShards = 32
+
+// pendingMap in-flight calls keyed by sequence number, sharded by seq%pendingShards
+// so that concurrent calls rarely contend on the same lock
+type pendingMap[V any] struct {
+	shards [pendingShards]pendingShard[V]
+}
+
+type pendingShard[V any] struct {
+	sync.Mutex
+	m map[uint64]V
+	// 补齐到整个缓存行，避免相邻分片位于同一缓存行
+	_ [cacheLine - unsafe.Sizeof(sync.Mutex{}) - unsafe.Sizeof(map[uint64]struct{}(nil))]byte
+}
+
+func (p *pendingMap[V]) shard(seq uint64) *pendingShard[V] {
+	return &p.shards[seq%pendingShards]
+}
+
+// store record the value of seq
+func (p *pendingMap[V]) store(seq uint64, v V) {
+	shard := p.shard(seq)
+	shard.Lock()
+	if shard.m == nil {
+		shard.m = make(map[uint64]V)
+	}
+	shard.m[seq] = v
+	shard.Unlock()
+}
+
+// storeNew record the value of seq unless seq is already stored, reporting whether it was stored
+func (p *pendingMap[V]) storeNew(seq uint64, v V) bool {
+	shard := p.shard(seq)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, ok := shard.m[seq]; ok {
+		return false
+	}
+	if shard.m == nil {
+		shard.m = make(map[uint64]V)
+	}
+	shard.m[seq] = v
+	return true
+}
+
+// load get the value of seq
+func (p *pendingMap[V]) load(seq uint64) (V, bool) {
+	shard := p.shard(seq)
+	shard.Lock()
+	v, ok := shard.m[seq]
+	shard.Unlock()
+	return v, ok
+}
+
+// loadAndDelete get the value of seq and remove it
+func (p *pendingMap[V]) loadAndDelete(seq uint64) (V, bool) {
+	shard := p.shard(seq)
+	shard.Lock()
+	v, ok := shard.m[seq]
+	delete(shard.m, seq)
+	shard.Unlock()
+	return v, ok
+}
+
+// len count the stored values
+func (p *pendingMap[V]) len() int {
+	n := 0
+	for i := range p.shards {
+		shard := &p.shards[i]
+		shard.Lock()
+		n += len(shard.m)
+		shard.Unlock()
+	}
+	return n
+}
+
+// clear remove every stored value
+func (p *pendingMap[V]) clear() {
+	for i := range p.shards {
+		shard := &p.shards[i]
+		shard.Lock()
+		shard.m = nil
+		shard.Unlock()
+	}
+}
@@ -0,0 +1,74 @@
+package codec
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPendingMap .
+func TestPendingMap(t *testing.T) {
+	var p pendingMap[string]
+	for seq := uint64(0); seq < 100; seq++ {
+		p.store(seq, "call")
+	}
+	v, ok := p.load(42)
+	assert.True(t, ok)
+	assert.Equal(t, "call", v)
+
+	v, ok = p.loadAndDelete(42)
+	assert.True(t, ok)
+	assert.Equal(t, "call", v)
+	_, ok = p.load(42)
+	assert.False(t, ok)
+	_, ok = p.loadAndDelete(42)
+	assert.False(t, ok)
+
+	// 同一分片中的其他序号不受影响
+	_, ok = p.load(42 + pendingShards)
+	assert.True(t, ok)
+	assert.Equal(t, 99, p.len())
+
+	p.clear()
+	assert.Equal(t, 0, p.len())
+	_, ok = p.load(43)
+	assert.False(t, ok)
+}
+
+// mutexMap the single-mutex pending map the codecs used before sharding
+type mutexMap struct {
+	sync.Mutex
+	m map[uint64]string
+}
+
+// BenchmarkPendingMap .
+func BenchmarkPendingMap(b *testing.B) {
+	b.Run("mutex", func(b *testing.B) {
+		p := &mutexMap{m: make(map[uint64]string)}
+		var seq uint64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				s := atomic.AddUint64(&seq, 1)
+				p.Lock()
+				p.m[s] = "call"
+				p.Unlock()
+				p.Lock()
+				delete(p.m, s)
+				p.Unlock()
+			}
+		})
+	})
+	b.Run("sharded", func(b *testing.B) {
+		var p pendingMap[string]
+		var seq uint64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				s := atomic.AddUint64(&seq, 1)
+				p.store(s, "call")
+				p.loadAndDelete(s)
+			}
+		})
+	})
+}
@@ -0,0 +1,180 @@
+package codec
+
+import (
+	"math/bits"
+	"sync"
+	"tiny_rpc/compressor"
+)
+
+const (
+	minBufferShift = 6  // smallest pooled buffer is 64B
+	maxBufferShift = 22 // largest pooled buffer is 4MB
+)
+
+// bufferPools pools of byte buffers, pool i holds buffers of capacity 1<<(i+minBufferShift)
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+func init() {
+	for i := range bufferPools {
+		size := 1 << (i + minBufferShift)
+		bufferPools[i].New = func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		}
+	}
+}
+
+// bufferClass get the pool index for a buffer of size, -1 if size is too large to pool
+func bufferClass(size int) int {
+	if size <= 1<<minBufferShift {
+		return 0
+	}
+	shift := bits.Len(uint(size - 1))
+	if shift > maxBufferShift {
+		return -1
+	}
+	return shift - minBufferShift
+}
+
+// getBuffer get a buffer of length size, it is taken from the pool unless size is too large
+func getBuffer(size int) *[]byte {
+	class := bufferClass(size)
+	if class < 0 {
+		buf := make([]byte, size)
+		return &buf
+	}
+	buf := bufferPools[class].Get().(*[]byte)
+	*buf = (*buf)[:size]
+	return buf
+}
+
+// putBuffer return the buffer to the pool, nothing may reference its bytes afterwards, nil is ignored
+func putBuffer(buf *[]byte) {
+	if buf == nil {
+		return
+	}
+	class := bufferClass(cap(*buf))
+	// 只回收容量恰为某一级别的缓冲区
+	if class < 0 || cap(*buf) != 1<<(class+minBufferShift) {
+		return
+	}
+	*buf = (*buf)[:cap(*buf)]
+	bufferPools[class].Put(buf)
+}
+
+// unzip decompress src, into a pooled buffer when comp implements compressor.Unzipper,
+// the returned buffer is nil otherwise and must be returned with putBuffer after use
+func unzip(comp compressor.Compressor, src []byte) ([]byte, *[]byte, error) {
+	if _, raw := comp.(compressor.RawCompressor); raw {
+		return src, nil, nil
+	}
+	into, ok := comp.(compressor.Unzipper)
+	if !ok {
+		data, err := comp.Unzip(src)
+		return data, nil, err
+	}
+	// 预估解压后的大小，不足时 UnzipInto 会自行扩容
+	buf := getBuffer(2 * len(src))
+	data, err := into.UnzipInto((*buf)[:0], src)
+	if err != nil {
+		putBuffer(buf)
+		return nil, nil, err
+	}
+	*buf = data
+	return data, buf, nil
+}
+
+// zip compress src, into a pooled buffer when comp implements compressor.StreamCompressor,
+// the returned buffer is nil otherwise and must be returned with putBuffer after use.
+// Bodies are still compressed whole since their header carries the length and checksum,
+// so the memory used grows with the size of the body, streamed replies are compressed per frame
+func zip(comp compressor.Compressor, src []byte) ([]byte, *[]byte, error) {
+	// 不压缩时直接使用原数据，无需拷贝
+	if _, raw := comp.(compressor.RawCompressor); raw {
+		return src, nil, nil
+	}
+	sc, ok := comp.(compressor.StreamCompressor)
+	if !ok {
+		data, err := comp.Zip(src)
+		return data, nil, err
+	}
+	// 压缩后通常不大于原数据，不足时 append 会自行扩容
+	buf := getBuffer(len(src))
+	w := &bufferWriter{buf: (*buf)[:0]}
+	zw := sc.ZipWriter(w)
+	_, err := zw.Write(src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		putBuffer(buf)
+		return nil, nil, err
+	}
+	*buf = w.buf
+	return w.buf, buf, nil
+}
+
+// zipBody compress body like zip, when fallback is set and compression does not make body
+// smaller, body itself is returned along with the Raw compress type
+func zipBody(comp compressor.Compressor, compressType compressor.CompressType, body []byte, fallback bool) ([]byte, *[]byte, compressor.CompressType, error) {
+	zipped, buf, err := zip(comp, body)
+	if err != nil {
+		return nil, nil, compressType, err
+	}
+	if useRaw(compressType, body, zipped, fallback) {
+		putBuffer(buf)
+		return body, nil, compressor.Raw, nil
+	}
+	return zipped, buf, compressType, nil
+}
+
+// useRaw report whether body is sent uncompressed in place of zipped, when fallback is set
+// and compression did not make it smaller
+func useRaw(compressType compressor.CompressType, body, zipped []byte, fallback bool) bool {
+	// 压缩没有收益，改为发送原数据
+	return fallback && compressType != compressor.Raw && len(zipped) >= len(body)
+}
+
+// bufferWriter an io.Writer appending to buf
+type bufferWriter struct {
+	buf []byte
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+// frameBuffer the buffer a connection reads its frames and bodies into, one buffer grown to the
+// largest message and reused across messages. It is only used by the reading goroutine, a nil
+// frameBuffer takes the buffers from the pools instead
+type frameBuffer struct {
+	buf []byte
+}
+
+// newFrameBuffer create the buffer of initially size bytes, nil if size is not positive
+func newFrameBuffer(size int) *frameBuffer {
+	if size <= 0 {
+		return nil
+	}
+	return &frameBuffer{buf: make([]byte, size)}
+}
+
+// get a buffer of length size, its bytes are valid until it is put back
+func (b *frameBuffer) get(size int) *[]byte {
+	if b == nil {
+		return getBuffer(size)
+	}
+	if cap(b.buf) < size {
+		b.buf = make([]byte, size)
+	}
+	b.buf = b.buf[:size]
+	return &b.buf
+}
+
+// put give back a buffer taken by get
+func (b *frameBuffer) put(buf *[]byte) {
+	if b == nil {
+		putBuffer(buf)
+	}
+}
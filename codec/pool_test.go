@@ -0,0 +1,322 @@
+package codec
+
+import (
+	"bytes"
+	"net"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGetBuffer .
+func TestGetBuffer(t *testing.T) {
+	cases := []struct {
+		name   string
+		size   int
+		expect int // 期望的缓冲区容量
+	}{
+		{"test-1", 0, 64},
+		{"test-2", 1, 64},
+		{"test-3", 64, 64},
+		{"test-4", 65, 128},
+		{"test-5", 4096, 4096},
+		{"test-6", 1<<22 + 1, 1<<22 + 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			buf := getBuffer(c.size)
+			assert.Equal(t, c.size, len(*buf))
+			assert.Equal(t, c.expect, cap(*buf))
+			putBuffer(buf)
+		})
+	}
+}
+
+// TestCodec_PooledBufferNotRetained .
+func TestCodec_PooledBufferNotRetained(t *testing.T) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.JSON)
+	first, second := "first request", "SECOND REQUEST"
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 1}, &first))
+	assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 2}, &second))
+
+	server := NewServerCodec(conn, serializer.JSON)
+	var args1, args2 string
+	request := &rpc.Request{}
+	assert.Nil(t, server.ReadRequestHeader(request))
+	assert.Equal(t, "EchoService.Echo", request.ServiceMethod)
+	assert.Nil(t, server.ReadRequestBody(&args1))
+	// 第二个请求会复用第一个请求归还的缓冲区
+	assert.Nil(t, server.ReadRequestHeader(request))
+	assert.Nil(t, server.ReadRequestBody(&args2))
+
+	assert.Equal(t, "EchoService.Echo", request.ServiceMethod)
+	assert.Equal(t, first, args1)
+	assert.Equal(t, second, args2)
+}
+
+// BenchmarkServerCodec_ReadRequest .
+func BenchmarkServerCodec_ReadRequest(b *testing.B) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Proto)
+	for i := 0; i < b.N; i++ {
+		_ = client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: uint64(i)},
+			&pb.ArithRequest{A: 1, B: 2})
+	}
+	server := NewServerCodec(newBuffer(bytes.Clone(conn.Bytes())), serializer.Proto)
+	request := &rpc.Request{}
+	args := &pb.ArithRequest{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := server.ReadRequestHeader(request); err != nil {
+			b.Fatal(err)
+		}
+		if err := server.ReadRequestBody(args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCodec_RawRoundTrip .
+func BenchmarkCodec_RawRoundTrip(b *testing.B) {
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Raw, serializer.Proto)
+	server := NewServerCodec(conn, serializer.Proto)
+	request, response := &rpc.Request{}, &rpc.Response{}
+	args, reply := &pb.ArithRequest{}, &pb.ArithResponse{}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: uint64(i)},
+			&pb.ArithRequest{A: 1, B: 2})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err = server.ReadRequestHeader(request); err != nil {
+			b.Fatal(err)
+		}
+		if err = server.ReadRequestBody(args); err != nil {
+			b.Fatal(err)
+		}
+		err = server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq},
+			&pb.ArithResponse{C: 3})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err = client.ReadResponseHeader(response); err != nil {
+			b.Fatal(err)
+		}
+		if err = client.ReadResponseBody(reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestCodec_LargeBody .
+func TestCodec_LargeBody(t *testing.T) {
+	// 超过最大的缓冲池级别
+	body := strings.Repeat("tinyrpc ", 1<<20)
+	for _, comp := range []compressor.CompressType{compressor.Raw, compressor.Gzip, compressor.Snappy, compressor.Zlib, compressor.Flate} {
+		conn := newBuffer(nil)
+		client := NewClientCodec(conn, comp, serializer.JSON)
+		assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: 1}, body))
+
+		server := NewServerCodec(conn, serializer.JSON)
+		request := &rpc.Request{}
+		assert.Nil(t, server.ReadRequestHeader(request))
+		var args string
+		assert.Nil(t, server.ReadRequestBody(&args))
+		assert.Equal(t, body, args)
+
+		assert.Nil(t, server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq}, args))
+		assert.Nil(t, client.ReadResponseHeader(&rpc.Response{}))
+		var reply string
+		assert.Nil(t, client.ReadResponseBody(&reply))
+		assert.Equal(t, body, reply)
+	}
+}
+
+// BenchmarkCodec_BufferSize .
+func BenchmarkCodec_BufferSize(b *testing.B) {
+	body := strings.Repeat("tinyrpc ", 2048)
+	for _, size := range []int{512, 4096, 1 << 16} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			conn := newBuffer(nil)
+			opts := []Option{WithReadBufferSize(size), WithWriteBufferSize(size)}
+			client := NewClientCodec(conn, compressor.Raw, serializer.JSON, opts...)
+			server := NewServerCodec(conn, serializer.JSON, opts...)
+			request := &rpc.Request{}
+			var args string
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				err := client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: uint64(i)}, body)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err = server.ReadRequestHeader(request); err != nil {
+					b.Fatal(err)
+				}
+				if err = server.ReadRequestBody(&args); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCodec_Buffering .
+func BenchmarkCodec_Buffering(b *testing.B) {
+	args := []byte("0123456789abcdef")
+	for _, bench := range []struct {
+		name string
+		opts []Option
+	}{
+		{"buffered", nil},
+		{"unbuffered", []Option{WithoutBuffering()}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer listener.Close()
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				server := NewServerCodec(conn, serializer.Raw, bench.opts...)
+				defer server.Close()
+				request := &rpc.Request{}
+				var body []byte
+				for {
+					if server.ReadRequestHeader(request) != nil || server.ReadRequestBody(&body) != nil {
+						return
+					}
+					if server.WriteResponse(&rpc.Response{ServiceMethod: request.ServiceMethod, Seq: request.Seq}, body) != nil {
+						return
+					}
+				}
+			}()
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			client := NewClientCodec(conn, compressor.Raw, serializer.Raw, bench.opts...)
+			defer client.Close()
+			response := &rpc.Response{}
+			var reply []byte
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err = client.WriteRequest(&rpc.Request{ServiceMethod: "EchoService.Echo", Seq: uint64(i)}, args); err != nil {
+					b.Fatal(err)
+				}
+				if err = client.ReadResponseHeader(response); err != nil {
+					b.Fatal(err)
+				}
+				if err = client.ReadResponseBody(&reply); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkClientCodec_WriteRequest .
+func BenchmarkClientCodec_WriteRequest(b *testing.B) {
+	args := &pb.ArithRequest{A: 1, B: 2}
+	for _, comp := range []compressor.CompressType{compressor.Raw, compressor.Gzip, compressor.Snappy} {
+		b.Run(strconv.Itoa(int(comp)), func(b *testing.B) {
+			conn := newBuffer(nil)
+			client := NewClientCodec(conn, comp, serializer.Proto)
+			request := &rpc.Request{ServiceMethod: "ArithService.Add"}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				conn.Reset()
+				request.Seq = uint64(i)
+				if err := client.WriteRequest(request, args); err != nil {
+					b.Fatal(err)
+				}
+				// 丢弃 pending 中记录的调用
+				client.(*clientCodec).forget(request.Seq)
+			}
+		})
+	}
+}
+
+// TestClientCodec_ScratchPerConnection .
+func TestClientCodec_ScratchPerConnection(t *testing.T) {
+	const conns, requests = 4, 200
+	buffers := make([]buffer, conns)
+	var wg sync.WaitGroup
+	for i := range buffers {
+		buffers[i] = newBuffer(nil)
+		client := NewClientCodec(buffers[i], compressor.Gzip, serializer.Proto)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for seq := 0; seq < requests; seq++ {
+				// 每个连接发送互不相同的参数
+				args := &pb.ArithRequest{A: float64(i), B: float64(seq)}
+				err := client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add", Seq: uint64(seq)}, args)
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, buf := range buffers {
+		server := NewServerCodec(newBuffer(buf.Bytes()), serializer.Proto)
+		for seq := 0; seq < requests; seq++ {
+			assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+			args := &pb.ArithRequest{}
+			assert.Nil(t, server.ReadRequestBody(args))
+			assert.Equal(t, float64(i), args.A)
+			assert.Equal(t, float64(seq), args.B)
+		}
+	}
+}
+
+// TestClientCodec_ScratchConcurrentOneway .
+func TestClientCodec_ScratchConcurrentOneway(t *testing.T) {
+	// 连接只在持有 wmutex 时写入
+	conn := newBuffer(nil)
+	client := NewClientCodec(conn, compressor.Snappy, serializer.Proto)
+	const writers, requests = 4, 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				// 单向请求不经过 rpc.Client，可能并发写入
+				p := &Param{Value: &pb.ArithRequest{A: float64(i), B: float64(j)}, Oneway: true}
+				assert.Nil(t, client.WriteRequest(&rpc.Request{ServiceMethod: "ArithService.Add"}, p))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	server := NewServerCodec(newBuffer(conn.Bytes()), serializer.Proto)
+	next := make([]float64, writers)
+	for n := 0; n < writers*requests; n++ {
+		assert.Nil(t, server.ReadRequestHeader(&rpc.Request{}))
+		args := &pb.ArithRequest{}
+		assert.Nil(t, server.ReadRequestBody(args))
+		// 每个写入方的请求完整且按序到达
+		assert.Equal(t, next[int(args.A)], args.B)
+		next[int(args.A)]++
+	}
+}
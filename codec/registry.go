@@ -0,0 +1,110 @@
+package codec
+
+import (
+	"reflect"
+	"sort"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+)
+
+// Registry the compressors and serializers a codec may use, isolated from the package registries
+// compressor.Compressors and serializer.Serializers. A codec without a registry uses the package ones.
+// A registry must not be changed once a codec uses it
+type Registry struct {
+	compressors map[compressor.CompressType]compressor.Compressor
+	serializers map[serializer.SerializeType]serializer.Serializer
+}
+
+// NewRegistry create a registry holding only the Raw compressor, which is always available
+func NewRegistry() *Registry {
+	return &Registry{
+		compressors: map[compressor.CompressType]compressor.Compressor{compressor.Raw: compressor.RawCompressor{}},
+		serializers: make(map[serializer.SerializeType]serializer.Serializer),
+	}
+}
+
+// DefaultRegistry create a registry holding a copy of the currently registered compressors and serializers
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, t := range compressor.Types() {
+		c, _ := compressor.Get(t)
+		r.compressors[t] = c
+	}
+	for t, s := range serializer.Serializers {
+		r.serializers[t] = s
+	}
+	return r
+}
+
+// RegisterCompressor register the compressor of a compress type, replacing the previous one
+func (r *Registry) RegisterCompressor(t compressor.CompressType, c compressor.Compressor) *Registry {
+	r.compressors[t] = c
+	return r
+}
+
+// RegisterSerializer register the serializer of a serialize type, replacing the previous one
+func (r *Registry) RegisterSerializer(t serializer.SerializeType, s serializer.Serializer) *Registry {
+	r.serializers[t] = s
+	return r
+}
+
+// compressor look up the compressor of t, Raw is answered without consulting the registry
+func (r *Registry) compressor(t compressor.CompressType) (compressor.Compressor, bool) {
+	if t == compressor.Raw {
+		return compressor.RawCompressor{}, true
+	}
+	if r == nil {
+		return compressor.Get(t)
+	}
+	c, ok := r.compressors[t]
+	return c, ok
+}
+
+// serializer look up the serializer of t
+func (r *Registry) serializer(t serializer.SerializeType) (serializer.Serializer, bool) {
+	if r == nil {
+		s, ok := serializer.Serializers[t]
+		return s, ok
+	}
+	s, ok := r.serializers[t]
+	return s, ok
+}
+
+// typeOf look up the registered type of serializer s, zero if s is not registered
+func (r *Registry) typeOf(s serializer.Serializer) serializer.SerializeType {
+	if r == nil {
+		return serializer.TypeOf(s)
+	}
+	for t, registered := range r.serializers {
+		if reflect.TypeOf(registered) == reflect.TypeOf(s) {
+			return t
+		}
+	}
+	return 0
+}
+
+// compressTypes list the registered compress types in ascending order
+func (r *Registry) compressTypes() []compressor.CompressType {
+	if r == nil {
+		return compressor.Types()
+	}
+	types := make([]compressor.CompressType, 0, len(r.compressors))
+	for t := range r.compressors {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// serializeTypes list the registered serialize types in ascending order
+func (r *Registry) serializeTypes() []serializer.SerializeType {
+	if r == nil {
+		return serializer.Types()
+	}
+	types := make([]serializer.SerializeType, 0, len(r.serializers))
+	for t := range r.serializers {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
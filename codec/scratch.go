@@ -0,0 +1,89 @@
+package codec
+
+import (
+	"io"
+	"reflect"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+)
+
+// scratch the buffers a connection reuses to encode its bodies, the serialized body, the
+// compressed body and the compressing writer. It is not safe for concurrent use, and the
+// bytes it returns are only valid until its next use
+type scratch struct {
+	body []byte
+	out  bufferWriter
+	zw   io.WriteCloser
+	comp compressor.Compressor // compressor which created zw
+}
+
+// resetter is implemented by the compressing writers which can be reused, such as gzip.Writer
+type resetter interface {
+	Reset(w io.Writer)
+}
+
+// marshal encode v into the body buffer when s implements serializer.Appender
+func (b *scratch) marshal(s serializer.Serializer, v any) ([]byte, error) {
+	a, ok := s.(serializer.Appender)
+	if !ok {
+		return s.Marshal(v)
+	}
+	data, err := a.MarshalAppend(b.body[:0], v)
+	if err != nil {
+		return nil, err
+	}
+	b.body = data
+	return data, nil
+}
+
+// zip compress src like zip, into the output buffer with a reused writer when comp implements
+// compressor.StreamCompressor
+func (b *scratch) zip(comp compressor.Compressor, src []byte) ([]byte, error) {
+	// 不压缩时直接使用原数据，无需拷贝
+	if _, raw := comp.(compressor.RawCompressor); raw {
+		return src, nil
+	}
+	sc, ok := comp.(compressor.StreamCompressor)
+	if !ok {
+		return comp.Zip(src)
+	}
+	b.out.buf = b.out.buf[:0]
+	zw := b.writer(sc, comp)
+	_, err := zw.Write(src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		// 写入失败的 writer 不再复用
+		b.zw = nil
+		return nil, err
+	}
+	return b.out.buf, nil
+}
+
+// writer get a compressing writer into the output buffer, reusing the previous one when
+// it was created by the same compressor
+func (b *scratch) writer(sc compressor.StreamCompressor, comp compressor.Compressor) io.WriteCloser {
+	if b.zw != nil && sameCompressor(b.comp, comp) {
+		b.zw.(resetter).Reset(&b.out)
+		return b.zw
+	}
+	zw := sc.ZipWriter(&b.out)
+	if _, ok := zw.(resetter); ok {
+		b.zw, b.comp = zw, comp
+	} else {
+		b.zw = nil
+	}
+	return zw
+}
+
+// sameCompressor report whether a and b are the same compressor, the compressors of a type
+// which is not comparable are told apart by their type only
+func sameCompressor(a, b compressor.Compressor) bool {
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) {
+		return false
+	}
+	// 同类型但配置不同的压缩器（如使用不同字典）创建的 writer 不能复用
+	return !t.Comparable() || a == b
+}
@@ -1,19 +1,39 @@
 package codec
 
 import (
-	"bufio"
-	"hash/crc32"
 	"io"
 	"net/rpc"
 	"sync"
+	"time"
+	"tiny_rpc/checksum"
 	"tiny_rpc/compressor"
 	"tiny_rpc/header"
 	"tiny_rpc/serializer"
 )
 
 type reqCtx struct {
-	requestId    uint64
-	compressType compressor.CompressType
+	requestId     uint64
+	compressType  compressor.CompressType
+	checksumType  checksum.ChecksumType
+	serializeType serializer.SerializeType // serializer requested for the response
+	maxRespSize   uint32                   // size limit of the compressed response, zero if unlimited
+	oneway        bool                     // the client wants no response
+
+	// 请求体的读取信息，只由读取协程访问
+	verifier        *verifier // verifies the MAC once the body is read, nil without a key
+	requestLen      uint32
+	flags           uint8  // flags of the request header
+	bodyLen         uint32 // length of the request body without its padding
+	checksum        uint64
+	bodyType        serializer.SerializeType // serializer of the request body, zero for the connection serializer
+	compressorFound bool                     // compressor was registered when the header was read
+
+	// 统计信息
+	method     string
+	start      time.Time
+	reqSize    int
+	reqRawSize int
+	fallback   bool // body decoded by the fallback serializer
 }
 
 type serverCodec struct {
@@ -21,120 +41,508 @@ type serverCodec struct {
 	writer io.Writer
 	closer io.Closer
 
-	request    header.RequestHeader
-	serializer serializer.Serializer
-	mutex      sync.Mutex // protects seq, pending
-	seq        uint64
-	pending    map[uint64]*reqCtx
+	counter *byteCounter // bytes read and written through reader and writer
+
+	serializer    serializer.Serializer
+	serializeType serializer.SerializeType // type of serializer, zero if it is not registered
+	encoder       *encoder                 // marshals the responses of serializer when it is resettable
+	seq           uint64                   // only accessed by the reading goroutine
+	pending       pendingMap[*reqCtx]
+	inflight      *pendingMap[struct{}] // request ids awaiting their response, nil unless duplicates are rejected
+
+	info           ConnInfo    // settings in effect
+	maxRequestSize uint32      // limit of the declared request body size, zero if unlimited
+	fallback       bool        // send bodies compression does not shrink raw
+	dict           *dictionary // preset dictionary of the compressors, empty if none configured
+	err            error       // handshake or read error, ends the connection
+	handshakeErr   error       // error of the handshake, nil if it succeeded or none was made
+	deadline       deadline
+	headers        HeaderCodec
+	signer         *signer               // signs the responses and verifies the requests, nil without a key
+	padder         *padder               // pads the response bodies, nil without padding
+	fallbackSer    serializer.Serializer // decodes the bodies serializer fails to, nil if none
+	verifyBuf      []byte                // scratch of the request headers being verified, only accessed by the reading goroutine
+	frames         *frameBuffer          // buffer the requests are read into, nil to use the pools
+
+	wmutex      sync.Mutex // protects writer, pongs are written by the reading goroutine
+	headerBuf   []byte     // scratch of the encoded response headers, protected by wmutex
+	batchWindow time.Duration
+	maxBatch    int
+	batched     int         // responses written since the last flush, protected by wmutex
+	timer       *time.Timer // flushes the current batch when the window ends, protected by wmutex
+
+	stats StatsHandler
+	name  string // name of the server in the request stats
 }
 
 // NewServerCodec Create a new server codec
-func NewServerCodec(conn io.ReadWriteCloser, serializer serializer.Serializer) rpc.ServerCodec {
-	return &serverCodec{
-		reader:     bufio.NewReader(conn),
-		writer:     bufio.NewWriter(conn),
-		closer:     conn,
-		serializer: serializer,
-		pending:    make(map[uint64]*reqCtx),
+func NewServerCodec(conn io.ReadWriteCloser, ser serializer.Serializer, opts ...Option) rpc.ServerCodec {
+	options := newOptions(opts)
+	counter := &byteCounter{}
+	s := &serverCodec{
+		reader:        options.newReader(counter.reader(conn)),
+		writer:        options.newWriter(counter.writer(conn)),
+		counter:       counter,
+		closer:        conn,
+		serializer:    ser,
+		serializeType: options.registry.typeOf(ser),
+		encoder:       newEncoder(ser),
+		info:          ConnInfo{MaxMessageSize: options.maxMessageSize},
+		deadline:      newDeadline(conn, options),
+
+		maxRequestSize: options.maxRequestSize,
+		fallback:       options.compressFallback,
+		dict:           newDictionary(options.registry, options.dictionary),
+		headers:        options.headerCodec,
+		signer:         newSigner(options.hmacKey),
+		padder:         newPadder(options.padding),
+		frames:         newFrameBuffer(options.frameBufferSize),
+		fallbackSer:    options.fallbackSerializer,
+		batchWindow:    options.respWindow,
+		maxBatch:       options.maxRespBatch,
+		stats:          options.stats,
+		name:           options.serverName,
+	}
+	if options.rejectDuplicateIDs {
+		s.inflight = &pendingMap[struct{}]{}
+	}
+	if options.handshake {
+		// 握手同样受超时限制，不发送握手的连接不会一直占用服务端
+		s.deadline.handshake()
+		s.info, s.handshakeErr = serverHandshake(s.reader, s.writer, s.info, options.registry)
+		s.err = s.handshakeErr
 	}
+	return s
+}
+
+// ConnInfo get the connection settings in effect
+func (s *serverCodec) ConnInfo() ConnInfo {
+	return s.info
+}
+
+// HandshakeErr get the error of the handshake, nil if it succeeded or no handshake was made
+func (s *serverCodec) HandshakeErr() error {
+	return s.handshakeErr
+}
+
+// Traffic get the bytes read from and written to the connection so far
+func (s *serverCodec) Traffic() Traffic {
+	return s.counter.traffic()
 }
 
 // ReadRequestHeader read the rpc request header from the io stream
 func (s *serverCodec) ReadRequestHeader(request *rpc.Request) error {
-	s.request.ResetHeader()
-	// 读取请求头
-	data, err := recvFrame(s.reader)
+	err := s.readRequestHeader(request)
 	if err != nil {
-		return err
+		s.codecError(err)
 	}
-	// 解码请求头
-	err = s.request.Unmarshal(data)
-	if err != nil {
-		return err
+	return err
+}
+
+func (s *serverCodec) readRequestHeader(request *rpc.Request) error {
+	if s.err != nil {
+		return s.err
+	}
+	// 请求头只在本次读取中使用，读取请求体所需的字段记录在请求的上下文中
+	h := header.RequestPool.Get().(*header.RequestHeader)
+	defer func() {
+		h.ResetHeader()
+		header.RequestPool.Put(h)
+	}()
+	var v *verifier
+	for {
+		h.ResetHeader()
+		// 读取请求头
+		s.deadline.waitHeader()
+		data, err := recvFrameInto(s.reader, s.info.MaxMessageSize, s.frames)
+		if err != nil {
+			return err
+		}
+		// 解码请求头，头部字段已拷贝出缓冲区
+		err = s.headers.UnmarshalRequest(*data, h)
+		s.frames.put(data)
+		if err != nil {
+			return err
+		}
+		if v, err = s.signer.verifyRequest(s.headers, &s.verifyBuf, h); err != nil {
+			return err
+		}
+		if h.GetFlags()&header.FlagPing == 0 {
+			break
+		}
+		if err = v.verify(nil); err != nil {
+			return err
+		}
+		// 心跳请求由编解码器直接应答，不交给服务端
+		if err = s.writePong(h.GetChecksumType()); err != nil {
+			return err
+		}
+	}
+	// 请求体需在读超时内到达
+	s.deadline.readBody()
+	oneway := h.GetFlags()&header.FlagOneway != 0
+	// 单向请求不会回复，客户端也不会等待其 ID
+	if s.inflight != nil && !oneway {
+		// 只有读取协程写入，检查与记录之间不会插入相同的 ID
+		if _, dup := s.inflight.load(h.ID); dup {
+			s.err = DuplicateRequestIDError
+			return s.err
+		}
+		s.inflight.store(h.ID, struct{}{})
 	}
 
-	s.mutex.Lock()
-	s.seq++                     // 序号自增
-	s.pending[s.seq] = &reqCtx{ // 自增序号和请求的上下文绑定
-		requestId:    s.request.ID,
-		compressType: s.request.GetCompressType(),
+	ctx := &reqCtx{
+		requestId:     h.ID,
+		compressType:  h.GetCompressType(),
+		checksumType:  h.GetChecksumType(),
+		serializeType: responseSerializeType(h.Metadata),
+		maxRespSize:   maxResponseSize(h.Metadata),
+		oneway:        oneway,
+		verifier:      v,
+		requestLen:    h.RequestLen,
+		flags:         h.GetFlags(),
+		bodyLen:       h.BodyLen,
+		checksum:      h.Checksum,
+		bodyType:      h.GetSerializeType(),
+		method:        h.Method,
+	}
+	_, ctx.compressorFound = s.dict.compressor(ctx.compressType)
+	if ctx.serializeType == 0 {
+		// 未指定时按请求的序列化格式回复
+		ctx.serializeType = ctx.bodyType
+	}
+	if s.stats != nil {
+		ctx.start = time.Now()
 	}
-	request.ServiceMethod = s.request.Method
+	s.seq++                     // 序号自增
+	s.pending.store(s.seq, ctx) // 自增序号和请求的上下文绑定
+	request.ServiceMethod = h.Method
 	request.Seq = s.seq
-	s.mutex.Unlock()
 	return nil
 }
 
 // ReadRequestBody read the rpc request body from the io stream
 func (s *serverCodec) ReadRequestBody(param any) error {
-	if param == nil {
-		if s.request.RequestLen != 0 {
-			if err := read(s.reader, make([]byte, s.request.RequestLen)); err != nil {
-				return err
-			}
+	// 请求体属于最近读取的请求头
+	ctx, ok := s.pending.load(s.seq)
+	if !ok {
+		return InvalidSequenceError
+	}
+	err := s.readRequestBody(ctx, param)
+	if s.stats == nil {
+		return err
+	}
+	if err != nil {
+		s.codecError(err)
+	}
+	ctx.reqSize = int(ctx.requestLen)
+	s.stats.RequestStart(ctx.stats(s.name))
+	return err
+}
+
+func (s *serverCodec) readRequestBody(ctx *reqCtx, param any) error {
+	// 超过限制的请求体不分配内存，直接丢弃，连接仍可继续使用。补齐的字节不计入请求体的大小限制
+	if exceeds(ctx.requestLen, s.info.MaxMessageSize) ||
+		exceeds(unpaddedLen(ctx.flags, ctx.requestLen, ctx.bodyLen), s.maxRequestSize) {
+		if err := s.skipBody(ctx); err != nil {
+			return err
 		}
-		return nil
+		return MessageTooLargeError
+	}
+	// 没有请求体时参数保持零值，丢弃的请求体同样需要验证，伪造的请求不会得到回复
+	if param == nil || ctx.requestLen == 0 {
+		return s.skipBody(ctx)
 	}
 
 	// 根据请求体长度，读取该长度的字节串
-	reqBody := make([]byte, s.request.RequestLen)
+	buf := s.frames.get(int(ctx.requestLen))
+	// 反序列化会拷贝出数据，返回后缓冲区即可归还
+	defer s.frames.put(buf)
+	reqBody := *buf
 	err := read(s.reader, reqBody)
 	if err != nil {
+		// 请求体读取失败后无法定位下一个请求，连接不可再用
+		s.err = err
+		return err
+	}
+	// 先验证签名，篡改的请求不再继续处理
+	if err = s.authenticate(ctx, reqBody); err != nil {
+		return err
+	}
+	// 去掉补齐的字节
+	if reqBody, err = unpad(ctx.flags, reqBody, ctx.bodyLen); err != nil {
 		return err
 	}
 
 	// 检查校验和
-	if s.request.Checksum != 0 {
-		if crc32.ChecksumIEEE(reqBody) != s.request.Checksum {
-			return UnexpectedChecksumError
-		}
+	if err = verify(ctx.checksumType, reqBody, ctx.checksum); err != nil {
+		return err
 	}
 	// 查看请求的压缩器是否已实现
-	if _, ok := compressor.Compressors[s.request.GetCompressType()]; !ok {
-		return NotFoundCompressorError
+	comp, ok := s.dict.compressor(ctx.compressType)
+	if !ok {
+		if ctx.compressorFound {
+			// 读取请求头后压缩器被注销，关闭连接
+			s.err = CompressorUnregisteredError
+			return CompressorUnregisteredError
+		}
+		// 回复客户端可改用的压缩格式
+		return &UnsupportedCompressorError{CompressType: ctx.compressType, Supported: s.dict.registry.compressTypes()}
 	}
 	// 解压请求体
-	req, err := compressor.Compressors[s.request.GetCompressType()].Unzip(reqBody)
+	req, unzipped, err := unzip(comp, reqBody)
+	if err != nil {
+		return &DecompressError{CompressType: ctx.compressType, Err: err}
+	}
+	defer putBuffer(unzipped)
+	ctx.reqRawSize = len(req)
+	// 按请求头声明的序列化格式反序列化
+	reqSerializer, err := serializerOf(s.dict.registry, ctx.bodyType, s.serializer)
 	if err != nil {
+		// 服务端没有注册客户端使用的序列化器
+		return SerializerMismatchError
+	}
+	err = reqSerializer.Unmarshal(req, param)
+	if err == nil || s.fallbackSer == nil {
 		return err
 	}
-	// 反序列化
-	return s.serializer.Unmarshal(req, param)
+	// 迁移期间客户端可能仍以旧的格式编码，改用备用序列化器解码
+	resetParam(param)
+	if ferr := s.fallbackSer.Unmarshal(req, param); ferr != nil {
+		return &FallbackError{Err: err, FallbackErr: ferr}
+	}
+	ctx.fallback = true
+	return nil
 
 }
 
 // WriteResponse Write the rpc response header and body to the io stream
 func (s *serverCodec) WriteResponse(response *rpc.Response, param any) error {
-	s.mutex.Lock()
-	reqCtx, ok := s.pending[response.Seq]
+	reqCtx, ok := s.pending.loadAndDelete(response.Seq)
 	if !ok {
-		s.mutex.Unlock()
 		return InvalidSequenceError
 	}
-	delete(s.pending, response.Seq)
-	s.mutex.Unlock()
+	if reqCtx.oneway {
+		// 单向请求不发送响应
+		if s.stats != nil {
+			stats := reqCtx.stats(s.name)
+			stats.Duration = time.Since(reqCtx.start)
+			stats.Error = response.Error
+			s.stats.RequestEnd(stats)
+		}
+		return nil
+	}
+	if s.inflight != nil {
+		// 客户端收到响应后即可复用 ID，需在发送前移除
+		s.inflight.loadAndDelete(reqCtx.requestId)
+	}
+	var err error
+	if r, ok := param.(*io.Reader); ok && response.Error == "" {
+		err = s.writeBodyStream(reqCtx, r)
+	} else {
+		err = s.writeResponse(reqCtx, response, param, 0)
+	}
+	if err != nil {
+		s.codecError(err)
+	}
+	return err
+}
 
-	if response.Error != "" {
-		param = nil
+// streamChunkSize the size of the raw bytes carried by a frame of a streamed body
+const streamChunkSize = 32 << 10
+
+// writeBodyStream write the reply of the reader in frames of at most streamChunkSize bytes,
+// each frame is compressed and verified on its own. A reader error ends the stream with an
+// error frame, only write errors are returned
+func (s *serverCodec) writeBodyStream(reqCtx *reqCtx, reply *io.Reader) error {
+	r := *reply
+	if r == nil {
+		r = eofReader{}
+	}
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+	h := &header.ResponseHeader{
+		ID:           reqCtx.requestId,
+		ChecksumType: reqCtx.checksumType,
+		CompressType: reqCtx.compressType,
+		Flags:        header.FlagStreamBody,
+	}
+	// 首帧不带数据，客户端收到后即交出 reader
+	if err := s.writeMessage(h, nil); err != nil {
+		return err
+	}
+	var (
+		size, rawSize int
+		ferr          error // error ending the stream
+	)
+	buf := getBuffer(streamChunkSize)
+	defer putBuffer(buf)
+	for {
+		n, err := io.ReadFull(r, *buf)
+		if n > 0 {
+			body, zipped, encErr := s.encodeStreamFrame(h, (*buf)[:n], s.info.MaxMessageSize)
+			if encErr != nil {
+				ferr = encErr
+				break
+			}
+			// 数据流的总大小同样不超过客户端为本次调用声明的上限
+			if reqCtx.maxRespSize != 0 && size+len(body) > int(reqCtx.maxRespSize) {
+				putBuffer(zipped)
+				ferr = ResponseTooLargeError
+				break
+			}
+			werr := s.writeMessage(h, body)
+			putBuffer(zipped)
+			if werr != nil {
+				return werr
+			}
+			size += len(body)
+			rawSize += n
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			ferr = err
+			break
+		}
+	}
+	// 空帧结束数据流，读取或编码失败时以错误帧结束
+	h.ResponseLen, h.Checksum = 0, 0
+	if ferr != nil {
+		h.Flags |= header.FlagError
+		h.Error = ferr.Error()
+	}
+	if err := s.writeMessage(h, nil); err != nil {
+		return err
 	}
-	// 检查压缩器
-	if _, ok := compressor.Compressors[reqCtx.compressType]; !ok {
-		return NotFoundCompressorError
+	if s.stats != nil {
+		stats := reqCtx.stats(s.name)
+		stats.ResponseSize = size
+		stats.ResponseRawSize = rawSize
+		stats.Duration = time.Since(reqCtx.start)
+		stats.Error = h.Error
+		s.stats.RequestEnd(stats)
 	}
+	return nil
+}
 
-	var respBody []byte
-	var err error
-	// 将参数编码为响应体
-	if param != nil {
-		respBody, err = s.serializer.Marshal(param)
+// encodeStreamFrame compress one frame of a streamed body and set its length and checksum in h,
+// the returned buffer backs the body and is put back by the caller
+func (s *serverCodec) encodeStreamFrame(h *header.ResponseHeader, data []byte, limit uint32) ([]byte, *[]byte, error) {
+	comp, ok := s.dict.compressor(h.GetCompressType())
+	if !ok {
+		return nil, nil, NotFoundCompressorError
+	}
+	body, zipped, err := zip(comp, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if limit != 0 && len(body) > int(limit) {
+		putBuffer(zipped)
+		return nil, nil, MessageTooLargeError
+	}
+	if h.Checksum, err = sum(h.GetChecksumType(), body); err != nil {
+		putBuffer(zipped)
+		return nil, nil, err
+	}
+	h.ResponseLen = uint32(len(body))
+	return body, zipped, nil
+}
+
+// eofReader the body of a nil *io.Reader reply
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// WriteChunk write one message of a streaming call, the call stays pending until WriteResponse
+// writes its final response
+func (s *serverCodec) WriteChunk(response *rpc.Response, param any) error {
+	reqCtx, ok := s.pending.load(response.Seq)
+	if !ok {
+		return InvalidSequenceError
+	}
+	if reqCtx.oneway {
+		return nil
+	}
+	err := s.writeResponse(reqCtx, response, param, header.FlagStreamChunk)
+	if err != nil {
+		s.codecError(err)
+	}
+	return err
+}
+
+// writeResponse write a response of the request with the given header flags
+func (s *serverCodec) writeResponse(reqCtx *reqCtx, response *rpc.Response, param any, flags uint8) error {
+	chunk := flags&header.FlagStreamChunk != 0
+	// 优先使用客户端要求的序列化格式，不支持时退回连接的序列化器
+	respSerializer, err := serializerOf(s.dict.registry, reqCtx.serializeType, s.serializer)
+	own := err != nil || reqCtx.serializeType == 0 || reqCtx.serializeType == s.serializeType
+	if own {
+		respSerializer = s.serializer
+	}
+	if reqCtx.fallback && reqCtx.serializeType == reqCtx.bodyType {
+		// 以备用序列化器解码的请求按同样的格式回复
+		respSerializer, own = s.fallbackSer, false
+	}
+	var respBody, compressedRespBody []byte
+	compressType := reqCtx.compressType
+	if response.Error == "" {
+		// 检查压缩器
+		comp, ok := s.dict.compressor(reqCtx.compressType)
+		if !ok {
+			return NotFoundCompressorError
+		}
+		// 将参数编码为响应体
+		if param != nil {
+			var enc *encoder
+			if own {
+				// 连接自身的序列化器可复用其编码状态
+				enc = s.encoder
+			}
+			var release func()
+			respBody, release, err = enc.marshal(respSerializer, param)
+			defer release()
+			if err != nil {
+				return err
+			}
+		}
+		// 压缩响应体
+		var zipped *[]byte
+		compressedRespBody, zipped, compressType, err = zipBody(comp, compressType, respBody, s.fallback)
 		if err != nil {
 			return err
 		}
+		defer putBuffer(zipped)
+		// 响应体超过限制时改为回复错误，避免客户端无法接收
+		if s.info.MaxMessageSize != 0 && len(compressedRespBody) > int(s.info.MaxMessageSize) {
+			if chunk {
+				return MessageTooLargeError
+			}
+			response.Error = MessageTooLargeError.Error()
+		}
+		// 超过客户端为本次调用声明的上限时同样回复错误，不发送响应体
+		if reqCtx.maxRespSize != 0 && len(compressedRespBody) > int(reqCtx.maxRespSize) {
+			if chunk {
+				return ResponseTooLargeError
+			}
+			response.Error = ResponseTooLargeError.Error()
+		}
 	}
-	// 压缩响应体
-	compressedRespBody, err := compressor.Compressors[reqCtx.compressType].Zip(respBody)
-	if err != nil {
-		return err
+	var digest uint64
+	if response.Error != "" {
+		// 错误响应不带响应体，无需压缩和校验，客户端可能不支持请求的压缩格式
+		compressedRespBody = nil
+		compressType = compressor.Raw
+		flags |= header.FlagError
+	} else {
+		// 计算校验和，响应沿用请求的校验算法
+		if digest, err = sum(reqCtx.checksumType, compressedRespBody); err != nil {
+			return err
+		}
 	}
 	// 从响应头部对象池取出响应头
 	h := header.ResponsePool.Get().(*header.ResponseHeader)
@@ -145,24 +553,140 @@ func (s *serverCodec) WriteResponse(response *rpc.Response, param any) error {
 
 	h.ID = reqCtx.requestId
 	h.Error = response.Error
+	if coder, ok := param.(interface{ ErrorCode() uint32 }); ok && response.Error != "" {
+		// 错误响应的参数只用于携带错误码
+		h.ErrorCode = coder.ErrorCode()
+	}
 	h.ResponseLen = uint32(len(compressedRespBody))
-	h.Checksum = crc32.ChecksumIEEE(compressedRespBody)
-	h.CompressType = reqCtx.compressType
+	h.Checksum = digest
+	h.ChecksumType = reqCtx.checksumType
+	h.SerializeType = s.dict.registry.typeOf(respSerializer)
+	h.CompressType = compressType
+	h.Flags = flags
+	if err = s.writeMessage(h, compressedRespBody); err != nil {
+		return err
+	}
+	if s.stats != nil && !chunk {
+		stats := reqCtx.stats(s.name)
+		stats.ResponseSize = len(compressedRespBody)
+		stats.ResponseRawSize = len(respBody)
+		stats.Duration = time.Since(reqCtx.start)
+		stats.Error = response.Error
+		s.stats.RequestEnd(stats)
+	}
+	return nil
 
-	// 发送响应头
-	if err = sendFrame(s.writer, h.Marshal()); err != nil {
+}
+
+// writeMessage write the header and the body of a response, the connection is closed
+// when the write fails since the client could not find the next response
+func (s *serverCodec) writeMessage(h *header.ResponseHeader, body []byte) error {
+	s.wmutex.Lock()
+	defer s.wmutex.Unlock()
+	// 补齐响应体，校验和只覆盖补齐前的响应体，签名覆盖发送的响应体。数据流的各帧共用响应头
+	h.Flags &^= header.FlagPadded
+	h.BodyLen = 0
+	if padded, buf := s.padder.pad(body, s.info.MaxMessageSize); buf != nil {
+		defer putBuffer(buf)
+		h.Flags |= header.FlagPadded
+		h.BodyLen = h.ResponseLen
+		h.ResponseLen = uint32(len(padded))
+		body = padded
+	}
+	if s.signer != nil {
+		// 签名覆盖不含 MAC 的响应头和响应体
+		var err error
+		if s.headerBuf, err = s.signer.signResponse(s.headers, s.headerBuf, h, body); err != nil {
+			return err
+		}
+	}
+	// 编码响应头，复用连接的缓冲区
+	data, err := marshalResponse(s.headers, s.headerBuf, h)
+	if err != nil {
 		return err
 	}
-	// 发送响应体
-	if err = write(s.writer, compressedRespBody); err != nil {
+	s.headerBuf = data
+	s.deadline.writeMessage()
+	err = s.sendMessage(data, body)
+	if err != nil {
+		// 关闭连接使读取协程退出
+		s.closer.Close()
+	}
+	return err
+}
+
+func (s *serverCodec) sendMessage(header, body []byte) error {
+	// 发送响应头和响应体
+	if err := sendMessage(s.writer, header, body); err != nil {
 		return err
 	}
+	return s.flush()
+}
 
-	s.writer.(*bufio.Writer).Flush()
+// flush send the buffered responses, or leave them for the batch of the window when batching.
+// The caller holds wmutex
+func (s *serverCodec) flush() error {
+	if s.batchWindow <= 0 {
+		return flush(s.writer)
+	}
+	s.batched++
+	if s.maxBatch > 0 && s.batched >= s.maxBatch {
+		return s.flushBatch()
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.batchWindow, func() {
+			s.wmutex.Lock()
+			defer s.wmutex.Unlock()
+			s.deadline.writeMessage()
+			if err := s.flushBatch(); err != nil {
+				// 与写入失败相同，关闭连接使读取协程退出
+				s.closer.Close()
+			}
+		})
+	}
 	return nil
+}
 
+// flushBatch 刷新当前批次，调用方需持有 wmutex
+func (s *serverCodec) flushBatch() error {
+	s.batched = 0
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	return flush(s.writer)
+}
+
+// skipBody read the body of the current request without decoding it and check its MAC
+func (s *serverCodec) skipBody(ctx *reqCtx) error {
+	if err := ctx.verifier.skip(s.reader, ctx.requestLen); err != nil {
+		s.err = err
+		return err
+	}
+	return s.authenticate(ctx, nil)
+}
+
+// authenticate verify the MAC of the request of ctx with its body, a request failing it
+// ends the connection since later requests can no longer be trusted either
+func (s *serverCodec) authenticate(ctx *reqCtx, body []byte) error {
+	if err := ctx.verifier.verify(body); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
 }
 
 func (s *serverCodec) Close() error {
+	// 发出尚未刷新的批次
+	s.wmutex.Lock()
+	if s.batched > 0 {
+		s.flushBatch()
+	}
+	s.wmutex.Unlock()
+	// 释放未回复请求的上下文，调用方不再为其写入响应
+	s.pending.clear()
+	if s.inflight != nil {
+		s.inflight.clear()
+	}
 	return s.closer.Close()
 }
@@ -0,0 +1,67 @@
+package codec
+
+import (
+	"io"
+	"time"
+	"tiny_rpc/compressor"
+)
+
+// RequestStats describes a request handled by a server codec
+type RequestStats struct {
+	Server          string // name of the server, see WithServerName
+	Method          string
+	RequestID       uint64 // id of the request header, echoed in the response
+	CompressType    compressor.CompressType
+	RequestSize     int           // request body size on the wire
+	RequestRawSize  int           // request body size after decompression
+	Fallback        bool          // request body decoded by the fallback serializer, see WithFallbackSerializer
+	ResponseSize    int           // response body size on the wire, set at RequestEnd
+	ResponseRawSize int           // response body size before compression, set at RequestEnd
+	Duration        time.Duration // from reading the request header to writing the response, set at RequestEnd
+	Error           string        // error sent in the response, set at RequestEnd
+}
+
+// StatsHandler observes the requests handled by a server codec,
+// it is called from several goroutines and must be safe for concurrent use
+type StatsHandler interface {
+	// RequestStart is called once the request body is read
+	RequestStart(stats RequestStats)
+	// RequestEnd is called once the response is written
+	RequestEnd(stats RequestStats)
+	// CodecError is called when reading or writing a message fails
+	CodecError(err error)
+}
+
+// WithServerName set the RequestStats.Server of the requests of server codecs
+func WithServerName(name string) Option {
+	return func(o *options) {
+		o.serverName = name
+	}
+}
+
+// WithStatsHandler set the handler observing the requests of server codecs
+func WithStatsHandler(h StatsHandler) Option {
+	return func(o *options) {
+		o.stats = h
+	}
+}
+
+// codecError report err unless it is the peer closing the connection
+func (s *serverCodec) codecError(err error) {
+	if s.stats != nil && err != io.EOF {
+		s.stats.CodecError(err)
+	}
+}
+
+// stats describe the request for the stats handler of the server named server
+func (r *reqCtx) stats(server string) RequestStats {
+	return RequestStats{
+		Server:         server,
+		Method:         r.method,
+		RequestID:      r.requestId,
+		CompressType:   r.compressType,
+		RequestSize:    r.reqSize,
+		RequestRawSize: r.reqRawSize,
+		Fallback:       r.fallback,
+	}
+}
@@ -0,0 +1,87 @@
+package codec
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultStreamBacklog the frames of a streamed body buffered for the caller by default
+	defaultStreamBacklog = 8
+	// defaultStreamTimeout how long the reading goroutine waits by default for the caller to
+	// make room in a full stream buffer
+	defaultStreamTimeout = 10 * time.Second
+)
+
+// bodyStream the reader of a streamed body handed to the caller, the reading goroutine queues
+// the frames without waiting as long as the buffer has room
+type bodyStream struct {
+	frames chan []byte   // frames not read yet, closed once the stream ends
+	closed chan struct{} // closed by Close
+	once   sync.Once
+	err    error  // error ending the stream, io.EOF after a complete body, set before frames is closed
+	cur    []byte // rest of the frame being read
+}
+
+func newBodyStream(backlog int) *bodyStream {
+	return &bodyStream{frames: make(chan []byte, backlog), closed: make(chan struct{})}
+}
+
+// Read read the body in the order of its frames
+func (s *bodyStream) Read(p []byte) (int, error) {
+	for len(s.cur) == 0 {
+		select {
+		case frame, ok := <-s.frames:
+			if !ok {
+				return 0, s.err
+			}
+			s.cur = frame
+		case <-s.closed:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	n := copy(p, s.cur)
+	s.cur = s.cur[n:]
+	return n, nil
+}
+
+// Close stop reading the body, the frames still arriving are discarded
+func (s *bodyStream) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+	return nil
+}
+
+// push queue a frame, waiting at most timeout for room once the buffer is full. It fails with
+// io.ErrClosedPipe if the caller closed the stream, or StreamAbandonedError if it did not read in time
+func (s *bodyStream) push(frame []byte, timeout time.Duration) error {
+	select {
+	case s.frames <- frame:
+		return nil
+	case <-s.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+	// 缓冲已满，调用方迟迟不读取时放弃该数据流，不再阻塞连接上的其他响应
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case s.frames <- frame:
+		return nil
+	case <-s.closed:
+		return io.ErrClosedPipe
+	case <-timer.C:
+		return StreamAbandonedError
+	}
+}
+
+// finish end the stream with err, nil for a complete body. It is called once by the reading goroutine
+func (s *bodyStream) finish(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	s.err = err
+	close(s.frames)
+}
@@ -0,0 +1,69 @@
+package codec
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// Traffic the bytes a codec read from and wrote to its connection, frame lengths, headers,
+// bodies and the handshake included
+type Traffic struct {
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// byteCounter count the bytes passing through the reader and the writer of a connection,
+// below the buffering of the codec
+type byteCounter struct {
+	read    atomic.Uint64
+	written atomic.Uint64
+}
+
+func (b *byteCounter) traffic() Traffic {
+	return Traffic{BytesRead: b.read.Load(), BytesWritten: b.written.Load()}
+}
+
+// reader count the bytes read from r
+func (b *byteCounter) reader(r io.Reader) io.Reader {
+	return &countingReader{Reader: r, n: &b.read}
+}
+
+// writer count the bytes written to w
+func (b *byteCounter) writer(w io.Writer) io.Writer {
+	return &countingWriter{Writer: w, n: &b.written}
+}
+
+type countingReader struct {
+	io.Reader
+	n *atomic.Uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n.Add(uint64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	io.Writer
+	n *atomic.Uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n.Add(uint64(n))
+	return n, err
+}
+
+// writeBuffers write the buffers to w, with a single vectored write when w is a network connection
+func writeBuffers(w io.Writer, buffers net.Buffers) error {
+	if cw, ok := w.(*countingWriter); ok {
+		// net.Buffers 只对连接本身使用 writev
+		n, err := buffers.WriteTo(cw.Writer)
+		cw.n.Add(uint64(n))
+		return err
+	}
+	_, err := buffers.WriteTo(w)
+	return err
+}
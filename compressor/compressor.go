@@ -1,5 +1,12 @@
 package compressor
 
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
 // CompressType type of compressions supported by rpc
 type CompressType uint16
 
@@ -9,16 +16,108 @@ type Compressor interface {
 	Unzip([]byte) ([]byte, error)
 }
 
+// Unzipper is implemented by compressors able to decompress into a caller-provided buffer,
+// UnzipInto appends the decompressed data to dst and returns the extended slice
+type Unzipper interface {
+	UnzipInto(dst, src []byte) ([]byte, error)
+}
+
+// StreamCompressor is implemented by compressors able to work on streams, the memory they use
+// does not grow with the size of the data. Data written to ZipWriter is complete once it is closed.
+// The codecs only use it to compress into pooled buffers, a body is still held whole in memory,
+// bodies too large for that are sent in frames as *io.Reader replies
+type StreamCompressor interface {
+	ZipWriter(w io.Writer) io.WriteCloser
+	UnzipReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// DictCompressor is implemented by compressors able to use a preset dictionary, which improves the
+// ratio of small and similar bodies. Both peers must use the same dictionary
+type DictCompressor interface {
+	WithDictionary(dict []byte) Compressor
+}
+
+// DictionaryMismatchError refers to data compressed with another dictionary than the one of the compressor
+var DictionaryMismatchError = errors.New("compressed with a different dictionary")
+
 const (
+	// Raw sends bodies uncompressed, it is the default of clients and needs no compression library.
+	// The codecs skip the registry for Raw, it is always the identity
 	Raw CompressType = iota
 	Gzip
 	Snappy
 	Zlib
+	Flate
 )
 
+// Compressors registered compressors, use Register and Unregister to change it at runtime
 var Compressors = map[CompressType]Compressor{
 	Raw:    RawCompressor{},
 	Gzip:   GzipCompressor{},
 	Snappy: SnappyCompressor{},
 	Zlib:   ZlibCompressor{},
+	Flate:  FlateCompressor{},
+}
+
+var mutex sync.RWMutex // protects Compressors
+
+// Register register the compressor of a compress type, replacing the previous one
+func Register(t CompressType, c Compressor) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	Compressors[t] = c
+}
+
+// Unregister remove the compressor of a compress type
+func Unregister(t CompressType) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(Compressors, t)
+}
+
+// Get look up the compressor of a compress type
+func Get(t CompressType) (Compressor, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	c, ok := Compressors[t]
+	return c, ok
+}
+
+// Types list the registered compress types in ascending order
+func Types() []CompressType {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	types := make([]CompressType, 0, len(Compressors))
+	for t := range Compressors {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// readInto append everything read from r to dst, like io.ReadAll it grows dst as needed
+func readInto(dst []byte, r io.Reader) ([]byte, error) {
+	for {
+		if len(dst) == cap(dst) {
+			dst = append(dst, 0)[:len(dst)]
+		}
+		n, err := r.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if err != nil {
+			// Zip 未关闭 writer，数据流可能缺少结尾
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return dst, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
 }
@@ -0,0 +1,248 @@
+package compressor
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRawCompressor .
+func TestRawCompressor(t *testing.T) {
+	c, ok := Get(Raw)
+	assert.True(t, ok)
+	for _, data := range [][]byte{nil, {}, []byte("tinyrpc")} {
+		zipped, err := c.Zip(data)
+		assert.Nil(t, err)
+		assert.Equal(t, data, zipped)
+		unzipped, err := c.Unzip(zipped)
+		assert.Nil(t, err)
+		assert.Equal(t, data, unzipped)
+	}
+}
+
+// TestUnzipInto .
+func TestUnzipInto(t *testing.T) {
+	data := bytes.Repeat([]byte("tinyrpc "), 1024)
+	cases := []struct {
+		name       string
+		compressor Compressor
+	}{
+		{"test-1", GzipCompressor{}},
+		{"test-2", SnappyCompressor{}},
+		{"test-3", ZlibCompressor{}},
+		{"test-4", FlateCompressor{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zipped, err := c.compressor.Zip(data)
+			assert.Nil(t, err)
+			expect, err := c.compressor.Unzip(zipped)
+			assert.Nil(t, err)
+
+			into, ok := c.compressor.(Unzipper)
+			assert.True(t, ok)
+			// 缓冲区不足时扩容
+			got, err := into.UnzipInto(make([]byte, 0, 16), zipped)
+			assert.Nil(t, err)
+			assert.Equal(t, expect, got)
+			// 追加到已有数据之后
+			got, err = into.UnzipInto([]byte("prefix"), zipped)
+			assert.Nil(t, err)
+			assert.Equal(t, append([]byte("prefix"), expect...), got)
+		})
+	}
+}
+
+var pattern = bytes.Repeat([]byte("tinyrpc streams "), 4096)
+
+// patternReader produces n bytes of a repeating pattern
+type patternReader struct {
+	n   int64
+	off int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.off >= r.n {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n-r.off {
+		p = p[:r.n-r.off]
+	}
+	n := 0
+	for n < len(p) {
+		offset := int((r.off + int64(n)) % int64(len(pattern)))
+		n += copy(p[n:], pattern[offset:])
+	}
+	r.off += int64(n)
+	return n, nil
+}
+
+// TestStreamCompressor .
+func TestStreamCompressor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams 100MB per compressor")
+	}
+	const size = 100 << 20
+	cases := []struct {
+		name       string
+		compressor Compressor
+	}{
+		{"test-1", RawCompressor{}},
+		{"test-2", GzipCompressor{}},
+		{"test-3", SnappyCompressor{}},
+		{"test-4", ZlibCompressor{}},
+		{"test-5", FlateCompressor{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sc, ok := c.compressor.(StreamCompressor)
+			assert.True(t, ok)
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+
+			pr, pw := io.Pipe()
+			go func() {
+				w := sc.ZipWriter(pw)
+				if _, err := io.Copy(w, &patternReader{n: size}); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				pw.CloseWithError(w.Close())
+			}()
+			r, err := sc.UnzipReader(pr)
+			assert.Nil(t, err)
+			expect, got := crc32.NewIEEE(), crc32.NewIEEE()
+			n, err := io.Copy(got, r)
+			assert.Nil(t, err)
+			assert.Nil(t, r.Close())
+			io.Copy(expect, &patternReader{n: size})
+
+			assert.Equal(t, int64(size), n)
+			assert.Equal(t, expect.Sum32(), got.Sum32())
+			// 内存占用与数据大小无关
+			runtime.ReadMemStats(&after)
+			assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(size/4))
+		})
+	}
+}
+
+// BenchmarkUnzip .
+func BenchmarkUnzip(b *testing.B) {
+	data := bytes.Repeat([]byte("tinyrpc "), 1024)
+	for name, c := range map[string]Compressor{"gzip": GzipCompressor{}, "snappy": SnappyCompressor{}, "zlib": ZlibCompressor{}, "flate": FlateCompressor{}} {
+		zipped, _ := c.Zip(data)
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = c.Unzip(zipped)
+			}
+		})
+		b.Run(name+"-into", func(b *testing.B) {
+			into := c.(Unzipper)
+			dst := make([]byte, 0, 2*len(data))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				dst, _ = into.UnzipInto(dst[:0], zipped)
+			}
+		})
+	}
+}
+
+// TestFlateCompressor .
+func TestFlateCompressor(t *testing.T) {
+	c, ok := Get(Flate)
+	assert.True(t, ok)
+	for _, data := range [][]byte{nil, {}, []byte("tinyrpc"), bytes.Repeat([]byte("tinyrpc "), 1024)} {
+		zipped, err := c.Zip(data)
+		assert.Nil(t, err)
+		unzipped, err := c.Unzip(zipped)
+		assert.Nil(t, err)
+		assert.Equal(t, len(data), len(unzipped))
+		assert.Equal(t, string(data), string(unzipped))
+	}
+
+	// 没有 gzip 的头部和尾部，小消息压缩后更短
+	small := []byte(`{"a":1,"b":2}`)
+	deflated, err := c.Zip(small)
+	assert.Nil(t, err)
+	gzipped, err := GzipCompressor{}.Zip(small)
+	assert.Nil(t, err)
+	assert.Equal(t, len(gzipped)-18, len(deflated))
+
+	// 并发使用共享的 writer 池
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			data := bytes.Repeat([]byte{byte(i)}, 1000+i)
+			for j := 0; j < 50; j++ {
+				zipped, err := c.Zip(data)
+				assert.Nil(t, err)
+				unzipped, err := c.Unzip(zipped)
+				assert.Nil(t, err)
+				assert.Equal(t, data, unzipped)
+			}
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+}
+
+// TestFlateCompressor_Dictionary .
+func TestFlateCompressor_Dictionary(t *testing.T) {
+	// 以典型消息的字段名训练字典
+	dict := []byte(`{"user_id":,"user_name":"","email":"@example.com","created_at":"2022-01-01T00:00:00Z"}`)
+	c := FlateCompressor{}.WithDictionary(dict)
+	messages := [][]byte{
+		[]byte(`{"user_id":1,"user_name":"alice","email":"alice@example.com","created_at":"2022-03-04T05:06:07Z"}`),
+		[]byte(`{"user_id":22,"user_name":"bob","email":"bob@example.com","created_at":"2022-08-09T10:11:12Z"}`),
+	}
+	for _, data := range messages {
+		zipped, err := c.Zip(data)
+		assert.Nil(t, err)
+		plain, err := FlateCompressor{}.Zip(data)
+		assert.Nil(t, err)
+		assert.Less(t, len(zipped), len(plain))
+
+		unzipped, err := c.Unzip(zipped)
+		assert.Nil(t, err)
+		assert.Equal(t, data, unzipped)
+		unzipped, err = c.(Unzipper).UnzipInto([]byte("prefix"), zipped)
+		assert.Nil(t, err)
+		assert.Equal(t, append([]byte("prefix"), data...), unzipped)
+
+		// 字典不一致时解压失败
+		_, err = FlateCompressor{}.WithDictionary([]byte("another dictionary")).Unzip(zipped)
+		assert.Equal(t, DictionaryMismatchError, err)
+		_, err = c.Unzip(plain)
+		assert.Equal(t, DictionaryMismatchError, err)
+		_, err = FlateCompressor{}.Unzip(zipped)
+		assert.NotNil(t, err)
+	}
+
+	// 复用的 writer 每个数据流都以字典 ID 开头
+	buf := &bytes.Buffer{}
+	zw := c.(StreamCompressor).ZipWriter(buf)
+	for _, data := range messages {
+		buf.Reset()
+		zw.(interface{ Reset(io.Writer) }).Reset(buf)
+		_, err := zw.Write(data)
+		assert.Nil(t, err)
+		assert.Nil(t, zw.Close())
+		r, err := c.(StreamCompressor).UnzipReader(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+		unzipped, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, data, unzipped)
+	}
+
+	// 空字典等同于不使用字典
+	assert.Equal(t, FlateCompressor{}, FlateCompressor{}.WithDictionary(nil))
+}
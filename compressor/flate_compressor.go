@@ -0,0 +1,168 @@
+package compressor
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/adler32"
+	"io"
+	"sync"
+)
+
+// flateWriters reuses the flate writers of Zip, each of them allocates several hundred KB
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+// FlateCompressor implements the Compressor interface with raw deflate, without the header
+// and trailer of gzip and zlib, which matters for small bodies. It is safe for concurrent use
+type FlateCompressor struct {
+	dict *flateDict // preset dictionary, nil if none
+}
+
+// flateDict a preset dictionary and the writers created with it. Like the zlib format, the
+// streams start with the adler32 of the dictionary so that a mismatch is detected
+type flateDict struct {
+	data    []byte
+	id      [4]byte
+	writers sync.Pool
+}
+
+// WithDictionary get a FlateCompressor using dict as its preset dictionary, only the last
+// 32KB of dict are used and the bodies are compressed at flate.BestCompression.
+// An empty dict gives a compressor without dictionary
+func (_ FlateCompressor) WithDictionary(dict []byte) Compressor {
+	if len(dict) == 0 {
+		return FlateCompressor{}
+	}
+	d := &flateDict{data: append([]byte(nil), dict...)}
+	binary.BigEndian.PutUint32(d.id[:], adler32.Checksum(d.data))
+	d.writers.New = func() any {
+		return d.newWriter(nil)
+	}
+	return FlateCompressor{dict: d}
+}
+
+// newWriter create a writer compressing with the dictionary into w
+func (d *flateDict) newWriter(w io.Writer) *dictWriter {
+	// 默认级别会跳过小消息中的匹配，字典发挥不了作用，小消息用最高级别压缩的开销可以忽略
+	fw, _ := flate.NewWriterDict(nil, flate.BestCompression, d.data)
+	dw := &dictWriter{Writer: fw, id: d.id}
+	dw.Reset(w)
+	return dw
+}
+
+// newReader check the dictionary id at the start of r and create a reader of the stream after it
+func (d *flateDict) newReader(r io.Reader) (io.ReadCloser, error) {
+	var id [4]byte
+	if _, err := io.ReadFull(r, id[:]); err != nil {
+		return nil, DictionaryMismatchError
+	}
+	if id != d.id {
+		return nil, DictionaryMismatchError
+	}
+	return flate.NewReaderDict(r, d.data), nil
+}
+
+// dictWriter a flate writer starting its streams with the dictionary id
+type dictWriter struct {
+	*flate.Writer
+	id  [4]byte
+	err error // error writing the id
+}
+
+// Reset .
+func (w *dictWriter) Reset(dst io.Writer) {
+	w.err = nil
+	if dst != nil {
+		_, w.err = dst.Write(w.id[:])
+	}
+	w.Writer.Reset(dst)
+}
+
+// Write .
+func (w *dictWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.Writer.Write(p)
+}
+
+// Close .
+func (w *dictWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.Writer.Close()
+}
+
+// Zip .
+func (c FlateCompressor) Zip(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	var w interface {
+		io.WriteCloser
+		Reset(io.Writer)
+	}
+	if c.dict != nil {
+		dw := c.dict.writers.Get().(*dictWriter)
+		defer c.dict.writers.Put(dw)
+		w = dw
+	} else {
+		fw := flateWriters.Get().(*flate.Writer)
+		defer flateWriters.Put(fw)
+		w = fw
+	}
+	w.Reset(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	// 关闭 writer 才会写出数据流的结尾
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unzip .
+func (c FlateCompressor) Unzip(data []byte) ([]byte, error) {
+	r, err := c.UnzipReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err = io.ReadAll(r)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// UnzipInto .
+func (c FlateCompressor) UnzipInto(dst, src []byte) ([]byte, error) {
+	r, err := c.UnzipReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readInto(dst, r)
+}
+
+// ZipWriter .
+func (c FlateCompressor) ZipWriter(w io.Writer) io.WriteCloser {
+	if c.dict != nil {
+		return c.dict.newWriter(w)
+	}
+	zw, _ := flate.NewWriter(w, flate.DefaultCompression)
+	return zw
+}
+
+// UnzipReader .
+func (c FlateCompressor) UnzipReader(r io.Reader) (io.ReadCloser, error) {
+	if c.dict != nil {
+		return c.dict.newReader(r)
+	}
+	return flate.NewReader(r), nil
+}
@@ -14,16 +14,14 @@ type GzipCompressor struct {
 func (_ GzipCompressor) Zip(data []byte) ([]byte, error) {
 	buf := bytes.NewBuffer(nil)
 	w := gzip.NewWriter(buf)
-	defer w.Close()
-	_, err := w.Write(data)
-	if err != nil {
+	if _, err := w.Write(data); err != nil {
 		return nil, err
 	}
-	err = w.Flush()
-	if err != nil {
+	// 关闭 writer 才会写出数据流的结尾
+	if err := w.Close(); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), err
+	return buf.Bytes(), nil
 }
 
 // Unzip .
@@ -39,3 +37,23 @@ func (_ GzipCompressor) Unzip(data []byte) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// UnzipInto .
+func (_ GzipCompressor) UnzipInto(dst, src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readInto(dst, r)
+}
+
+// ZipWriter .
+func (_ GzipCompressor) ZipWriter(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+// UnzipReader .
+func (_ GzipCompressor) UnzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
@@ -1,6 +1,8 @@
 package compressor
 
-// RawCompressor implements the Compressor interface
+import "io"
+
+// RawCompressor implements the Compressor interface without compressing, Zip and Unzip return data as is
 type RawCompressor struct {
 }
 
@@ -13,3 +15,13 @@ func (_ RawCompressor) Zip(data []byte) ([]byte, error) {
 func (_ RawCompressor) Unzip(data []byte) ([]byte, error) {
 	return data, nil
 }
+
+// ZipWriter .
+func (_ RawCompressor) ZipWriter(w io.Writer) io.WriteCloser {
+	return nopWriteCloser{w}
+}
+
+// UnzipReader .
+func (_ RawCompressor) UnzipReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
@@ -14,16 +14,14 @@ type SnappyCompressor struct {
 func (_ SnappyCompressor) Zip(data []byte) ([]byte, error) {
 	buf := bytes.NewBuffer(nil)
 	w := snappy.NewBufferedWriter(buf)
-	defer w.Close()
-	_, err := w.Write(data)
-	if err != nil {
+	if _, err := w.Write(data); err != nil {
 		return nil, err
 	}
-	err = w.Flush()
-	if err != nil {
+	// 关闭 writer 才会写出数据流的结尾
+	if err := w.Close(); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), err
+	return buf.Bytes(), nil
 }
 
 // Unzip .
@@ -35,3 +33,18 @@ func (_ SnappyCompressor) Unzip(data []byte) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// UnzipInto .
+func (_ SnappyCompressor) UnzipInto(dst, src []byte) ([]byte, error) {
+	return readInto(dst, snappy.NewReader(bytes.NewReader(src)))
+}
+
+// ZipWriter .
+func (_ SnappyCompressor) ZipWriter(w io.Writer) io.WriteCloser {
+	return snappy.NewBufferedWriter(w)
+}
+
+// UnzipReader .
+func (_ SnappyCompressor) UnzipReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(snappy.NewReader(r)), nil
+}
@@ -0,0 +1,178 @@
+package tiny_rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"tiny_rpc/header"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestServer_ReadTimeout .
+func TestServer_ReadTimeout(t *testing.T) {
+	events := make(chan ConnEvent, 8)
+	_, listener := startServer(t, WithReadTimeout(50*time.Millisecond), WithConnectionEvents(events))
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// 只发送声明了请求体长度的请求头，然后不再发送
+	h := &header.RequestHeader{Method: "ArithService.Add", ID: 1, RequestLen: 100}
+	data := h.Marshal()
+	_, err = conn.Write(append(binary.AppendUvarint(nil, uint64(len(data))), data...))
+	assert.Nil(t, err)
+
+	// 服务端超时后关闭连接
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadAll(conn)
+	assert.Nil(t, err)
+
+	for {
+		e := nextEvent(t, events)
+		if e.Type == ConnectionClosed {
+			assert.True(t, errors.Is(e.Err, os.ErrDeadlineExceeded), e.Err)
+			break
+		}
+	}
+}
+
+// TestServer_IdleTimeout .
+func TestServer_IdleTimeout(t *testing.T) {
+	_, listener := startServer(t, WithIdleTimeout(50*time.Millisecond))
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	start := time.Now()
+	_, err = io.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// 不发送握手的连接同样超时关闭
+	cases := []struct {
+		name string
+		opt  Option
+	}{
+		{"test-1", WithIdleTimeout(50 * time.Millisecond)},
+		{"test-2", WithReadTimeout(50 * time.Millisecond)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, listener := startServer(t, WithHandshake(), c.opt)
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			assert.Nil(t, err)
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			start := time.Now()
+			_, err = io.ReadAll(conn)
+			assert.Nil(t, err)
+			assert.Less(t, time.Since(start), time.Second)
+		})
+	}
+}
+
+// SlowService sleeps A milliseconds, Wait stops early when the call context is done
+type SlowService struct{}
+
+// Wait .
+func (_ *SlowService) Wait(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(args.A) * time.Millisecond):
+	}
+	reply.C = args.A
+	return nil
+}
+
+// Sleep .
+func (_ *SlowService) Sleep(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	time.Sleep(time.Duration(args.A) * time.Millisecond)
+	reply.C = args.A
+	return nil
+}
+
+// TestServer_RequestTimeout .
+func TestServer_RequestTimeout(t *testing.T) {
+	server, listener := startServer(t, WithRequestTimeout(50*time.Millisecond))
+	assert.Nil(t, server.Register(new(SlowService)))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		millis float64
+		err    error
+	}{
+		{"test-1", "SlowService.Wait", 1, nil},
+		{"test-2", "SlowService.Wait", 5000, DeadlineExceededError},
+		{"test-3", "SlowService.Sleep", 1, nil},
+		{"test-4", "SlowService.Sleep", 100, DeadlineExceededError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reply := &pb.ArithResponse{}
+			start := time.Now()
+			err := client.Call(c.method, &pb.ArithRequest{A: c.millis}, reply)
+			assert.Equal(t, c.err, err)
+			if c.err == nil {
+				assert.Equal(t, c.millis, reply.C)
+			} else {
+				// 观察上下文的方法在超时后立即返回
+				assert.Less(t, time.Since(start), time.Second)
+			}
+		})
+	}
+}
+
+// CancelService blocks its callers until the call context is done
+type CancelService struct {
+	started chan struct{}
+	done    chan error
+}
+
+// Block .
+func (b *CancelService) Block(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	b.started <- struct{}{}
+	<-ctx.Done()
+	b.done <- ctx.Err()
+	return ctx.Err()
+}
+
+// TestServer_CancelOnDisconnect .
+func TestServer_CancelOnDisconnect(t *testing.T) {
+	server, listener := startServer(t)
+	service := &CancelService{started: make(chan struct{}, 1), done: make(chan error, 1)}
+	assert.Nil(t, server.Register(service))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+
+	call := client.AsyncCall("CancelService.Block", &pb.ArithRequest{}, &pb.ArithResponse{})
+	select {
+	case <-service.started:
+	case <-time.After(time.Second):
+		t.Fatal("handler did not start")
+	}
+	// 调用进行中客户端断开连接
+	assert.Nil(t, client.Close())
+	select {
+	case err = <-service.done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not cancelled after the client disconnected")
+	}
+	assert.NotNil(t, (<-call).Error)
+}
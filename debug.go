@@ -0,0 +1,103 @@
+package tiny_rpc
+
+import (
+	"encoding/hex"
+	"io"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+// firstRequestDumpSize maximum number of received bytes dumped for an undecodable first request
+const firstRequestDumpSize = 256
+
+// WithDebugFirstRequest log a hex dump of the bytes received on a connection
+// whose first request cannot be decoded, before the connection is closed.
+// It is a debugging aid for new client implementations and off by default
+func WithDebugFirstRequest() Option {
+	return func(o *options) {
+		o.debugFirstRequest = true
+	}
+}
+
+// recorder keeps the first bytes read from the connection until it is stopped
+type recorder struct {
+	io.ReadWriteCloser
+	data    []byte
+	stopped bool
+}
+
+func (r *recorder) Read(p []byte) (int, error) {
+	n, err := r.ReadWriteCloser.Read(p)
+	if !r.stopped {
+		keep := n
+		if room := firstRequestDumpSize - len(r.data); room < keep {
+			keep = room
+		}
+		r.data = append(r.data, p[:keep]...)
+	}
+	return n, err
+}
+
+// SetReadDeadline forward the deadline to the connection, the codec applies its timeouts through it
+func (r *recorder) SetReadDeadline(t time.Time) error {
+	if c, ok := r.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return c.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// SetWriteDeadline forward the deadline to the connection
+func (r *recorder) SetWriteDeadline(t time.Time) error {
+	if c, ok := r.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return c.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+// firstRequestCodec dumps the recorded bytes when the header or the body of the first request cannot be decoded
+type firstRequestCodec struct {
+	rpc.ServerCodec
+	rec    *recorder
+	addr   net.Addr
+	logger Logger
+	label  string // prefix of the log lines of the server
+	done   bool
+}
+
+func (c *firstRequestCodec) ReadRequestHeader(request *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(request)
+	// 请求头正常时继续记录，直到请求体读取完毕
+	if !c.done && err != nil {
+		c.finish(err)
+	}
+	return err
+}
+
+func (c *firstRequestCodec) ReadRequestBody(body any) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+	if !c.done {
+		c.finish(err)
+	}
+	return err
+}
+
+// finish stop recording once the first request is read, the recorded bytes are dumped if reading it failed with err
+func (c *firstRequestCodec) finish(err error) {
+	// 请求头与读取均在同一协程中进行，无需加锁
+	c.done = true
+	c.rec.stopped = true
+	if err != nil && len(c.rec.data) > 0 {
+		c.logger.Infof("%s: cannot decode first request from %v: %v, received %d bytes:\n%s",
+			c.label, c.addr, err, len(c.rec.data), hex.Dump(c.rec.data))
+	}
+	c.rec.data = nil
+}
+
+// WriteChunk forward stream chunks to the wrapped codec
+func (c *firstRequestCodec) WriteChunk(response *rpc.Response, param any) error {
+	if w, ok := c.ServerCodec.(chunkWriter); ok {
+		return w.WriteChunk(response, param)
+	}
+	return StreamNotSupportedError
+}
@@ -0,0 +1,125 @@
+package tiny_rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"tiny_rpc/header"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestServer_DebugFirstRequest .
+func TestServer_DebugFirstRequest(t *testing.T) {
+	output := bytes.NewBuffer(nil)
+	log.SetOutput(output)
+	defer log.SetOutput(os.Stderr)
+
+	server := NewServer(WithDebugFirstRequest())
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(serverConn)
+		close(done)
+	}()
+
+	garbage := []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	go func() {
+		clientConn.Write(garbage)
+		clientConn.Close()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn did not return after the first request failed")
+	}
+	assert.Contains(t, output.String(), "cannot decode first request")
+	assert.Contains(t, output.String(), hex.Dump(garbage))
+}
+
+// TestServer_DebugFirstRequestBounded .
+func TestServer_DebugFirstRequestBounded(t *testing.T) {
+	output := bytes.NewBuffer(nil)
+	log.SetOutput(output)
+	defer log.SetOutput(os.Stderr)
+
+	server := NewServer(WithDebugFirstRequest())
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(serverConn)
+		close(done)
+	}()
+
+	// 帧长度远大于实际数据，读取时遇到 EOF
+	garbage := append([]byte{0xff, 0xff, 0x03}, bytes.Repeat([]byte{0xab}, 1024)...)
+	go func() {
+		clientConn.Write(garbage)
+		clientConn.Close()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn did not return after the first request failed")
+	}
+	assert.Contains(t, output.String(), "received 256 bytes")
+	assert.Contains(t, output.String(), hex.Dump(garbage[:firstRequestDumpSize]))
+}
+
+// TestServer_DebugFirstRequestBody .
+func TestServer_DebugFirstRequestBody(t *testing.T) {
+	output := bytes.NewBuffer(nil)
+	log.SetOutput(output)
+	defer log.SetOutput(os.Stderr)
+
+	server := NewServer(WithDebugFirstRequest())
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(serverConn)
+		close(done)
+	}()
+
+	// 请求头正常，请求体在声明的长度之前结束
+	h := &header.RequestHeader{Method: "ArithService.Add", ID: 1, RequestLen: 100}
+	data := h.Marshal()
+	request := append(binary.AppendUvarint(nil, uint64(len(data))), data...)
+	request = append(request, 0x0a, 0x0b)
+	go func() {
+		clientConn.Write(request)
+		clientConn.Close()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn did not return after the first request failed")
+	}
+	assert.Contains(t, output.String(), "cannot decode first request")
+	assert.Contains(t, output.String(), hex.Dump(request))
+}
+
+// TestServer_DebugFirstRequestTimeout .
+func TestServer_DebugFirstRequestTimeout(t *testing.T) {
+	// 记录请求的连接同样受超时限制
+	_, listener := startServer(t, WithDebugFirstRequest(), WithIdleTimeout(50*time.Millisecond))
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	start := time.Now()
+	_, err = io.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,94 @@
+package tiny_rpc
+
+import (
+	"errors"
+	"net/rpc"
+	"tiny_rpc/codec"
+)
+
+// error codes the server sets on the error responses it makes itself, application error codes
+// should stay below them
+const (
+	MethodNotFoundCode   uint32 = 0xffff0000 + iota // the service or the method is not registered
+	MethodNotAllowedCode                            // the server does not dispatch calls to the method
+
+	// UnsupportedCompressorCode the server has no compressor of the compress type of the request
+	UnsupportedCompressorCode = codec.UnsupportedCompressorCode
+)
+
+var (
+	ServerBusyError         = errors.New("rpc: server busy")
+	StreamNotSupportedError = errors.New("rpc: codec does not support streaming")
+	PoolClosedError         = errors.New("rpc: client pool closed")
+	NoBackendError          = errors.New("rpc: no backend available")
+	ServerClosedError       = errors.New("rpc: server closed")
+	RateLimitedError        = errors.New("rpc: rate limited")
+	DeadlineExceededError   = errors.New("rpc: request timeout exceeded")
+)
+
+// MethodNotFoundError a call to a service or a method the server has not registered,
+// the server sends it with MethodNotFoundCode
+type MethodNotFoundError struct {
+	Message string
+}
+
+func (e *MethodNotFoundError) Error() string {
+	return e.Message
+}
+
+// ErrorCode .
+func (e *MethodNotFoundError) ErrorCode() uint32 {
+	return MethodNotFoundCode
+}
+
+// MethodNotAllowedError a call to a method the server is configured not to dispatch,
+// the server sends it with MethodNotAllowedCode
+type MethodNotAllowedError struct {
+	Message string
+}
+
+func (e *MethodNotAllowedError) Error() string {
+	return e.Message
+}
+
+// ErrorCode .
+func (e *MethodNotAllowedError) ErrorCode() uint32 {
+	return MethodNotAllowedCode
+}
+
+// knownErrors errors the server sends by message which the client converts back
+var knownErrors = []error{
+	ServerBusyError,
+	RateLimitedError,
+	DeadlineExceededError,
+	codec.ResponseTooLargeError,
+	codec.MessageTooLargeError,
+	codec.SerializerMismatchError,
+}
+
+// convertError convert a server error carrying a known message into the typed error
+func convertError(err error) error {
+	se, ok := err.(rpc.ServerError)
+	if !ok {
+		return err
+	}
+	if ce, ok := codec.ParseCodeError(string(se)); ok {
+		switch ce.Code {
+		case MethodNotFoundCode:
+			return &MethodNotFoundError{Message: ce.Message}
+		case MethodNotAllowedCode:
+			return &MethodNotAllowedError{Message: ce.Message}
+		case UnsupportedCompressorCode:
+			if e, ok := codec.ParseUnsupportedCompressorError(ce.Message); ok {
+				return e
+			}
+		}
+		return ce
+	}
+	for _, known := range knownErrors {
+		if string(se) == known.Error() {
+			return known
+		}
+	}
+	return err
+}
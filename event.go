@@ -0,0 +1,64 @@
+package tiny_rpc
+
+import (
+	"net"
+	"tiny_rpc/codec"
+)
+
+// ConnEventType type of connection lifecycle events emitted by the server
+type ConnEventType int
+
+const (
+	ConnectionOpened ConnEventType = iota
+	HandshakeCompleted
+	ConnectionClosed
+)
+
+func (t ConnEventType) String() string {
+	switch t {
+	case ConnectionOpened:
+		return "ConnectionOpened"
+	case HandshakeCompleted:
+		return "HandshakeCompleted"
+	case ConnectionClosed:
+		return "ConnectionClosed"
+	}
+	return "Unknown"
+}
+
+// ConnEvent describes a connection lifecycle change on the server
+type ConnEvent struct {
+	Type       ConnEventType
+	ConnID     uint64
+	RemoteAddr net.Addr
+	Err        error          // reason of ConnectionClosed, io.EOF when the peer closed the connection, the handshake error when it failed
+	ConnInfo   codec.ConnInfo // settings in effect after HandshakeCompleted
+	Traffic    codec.Traffic  // bytes read and written on the connection, set on ConnectionClosed
+}
+
+// WithConnectionEvents set the channel receiving server connection events,
+// events are dropped when the channel is full
+func WithConnectionEvents(events chan<- ConnEvent) Option {
+	return func(o *options) {
+		o.connEvents = events
+	}
+}
+
+// WithStatsHandler set the handler observing the requests handled by the server,
+// it reports the method, body sizes, compress type and duration of each request
+func WithStatsHandler(h codec.StatsHandler) Option {
+	return func(o *options) {
+		o.statsHandler = h
+	}
+}
+
+// emit send the event without blocking the connection
+func (s *Server) emit(event ConnEvent) {
+	if s.options.connEvents == nil {
+		return
+	}
+	select {
+	case s.options.connEvents <- event:
+	default:
+	}
+}
@@ -2,11 +2,16 @@ module tiny_rpc
 
 go 1.19
 
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/golang/snappy v0.0.4
+	github.com/stretchr/testify v1.8.2
+	golang.org/x/time v0.3.0
+	google.golang.org/protobuf v1.28.1
+)
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/golang/snappy v0.0.4 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.8.2 // indirect
-	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,177 @@
+package header
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// HeaderError a header frame which does not decode as a header, errors.Is(err, MalformedHeaderError) holds
+type HeaderError struct {
+	Field  string // field which could not be decoded
+	Offset int    // offset of the field in the frame
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("malformed header: %s at offset %d: %s", e.Field, e.Offset, e.Reason)
+}
+
+func (e *HeaderError) Unwrap() error {
+	return MalformedHeaderError
+}
+
+// decoder reads the fields of a header frame, the first field which can not be read
+// is recorded and the later reads return zero values
+type decoder struct {
+	data []byte
+	idx  int
+	err  error
+}
+
+// fail record the first malformed field
+func (d *decoder) fail(field string, format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = &HeaderError{Field: field, Offset: d.idx, Reason: fmt.Sprintf(format, args...)}
+	}
+}
+
+// next return the following n bytes of the frame, nil if the frame is too short
+func (d *decoder) next(field string, n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if left := len(d.data) - d.idx; n > left {
+		d.fail(field, "truncated, need %d bytes, %d left", n, left)
+		return nil
+	}
+	b := d.data[d.idx : d.idx+n]
+	d.idx += n
+	return b
+}
+
+func (d *decoder) uint8(field string) uint8 {
+	if b := d.next(field, Uint8Size); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16(field string) uint16 {
+	if b := d.next(field, Uint16Size); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64(field string) uint64 {
+	if b := d.next(field, Uint64Size); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) uvarint(field string) uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, size := binary.Uvarint(d.data[d.idx:])
+	if size == 0 {
+		d.fail(field, "truncated varint")
+		return 0
+	}
+	if size < 0 {
+		d.fail(field, "varint overflows 64 bits")
+		return 0
+	}
+	d.idx += size
+	return v
+}
+
+// uint32 read a uvarint which must fit in 32 bits
+func (d *decoder) uint32(field string) uint32 {
+	start := d.idx
+	v := d.uvarint(field)
+	if v > 1<<32-1 {
+		d.idx = start
+		d.fail(field, "%d overflows 32 bits", v)
+		return 0
+	}
+	return uint32(v)
+}
+
+// string read a length prefixed string of at most limit bytes, limit is ignored when negative
+func (d *decoder) string(field string, limit int) string {
+	start := d.idx
+	length := d.uvarint(field)
+	if d.err != nil {
+		return ""
+	}
+	if limit >= 0 && length > uint64(limit) {
+		d.idx = start
+		d.fail(field, "length %d exceeds %d", length, limit)
+		return ""
+	}
+	if left := len(d.data) - d.idx; length > uint64(left) {
+		d.idx = start
+		d.fail(field, "declares %d bytes, %d left", length, left)
+		return ""
+	}
+	return string(d.next(field, int(length)))
+}
+
+// metadata read a count followed by key value pairs
+func (d *decoder) metadata(field string) map[string]string {
+	start := d.idx
+	count := d.uvarint(field)
+	if d.err != nil || count == 0 {
+		return nil
+	}
+	// 每个键值对至少占两个字节，拒绝声明了过多键值对的请求头
+	if left := len(d.data) - d.idx; count > uint64(left/2) {
+		d.idx = start
+		d.fail(field, "declares %d entries, %d bytes left", count, left)
+		return nil
+	}
+	metadata := make(map[string]string, count)
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		k := d.string(field, -1)
+		metadata[k] = d.string(field, -1)
+	}
+	return metadata
+}
+
+// bodyLen read the length of the body without its padding when flags has FlagPadded,
+// it may not exceed the length sent
+func (d *decoder) bodyLen(flags uint8, sent uint32) uint32 {
+	if flags&FlagPadded == 0 {
+		return 0
+	}
+	start := d.idx
+	n := d.uint32("BodyLen")
+	if d.err == nil && n > sent {
+		d.idx = start
+		d.fail("BodyLen", "%d exceeds the %d bytes sent", n, sent)
+		return 0
+	}
+	return n
+}
+
+// mac read the length prefixed MAC which ends the frame when flags has FlagSigned
+func (d *decoder) mac(flags uint8) []byte {
+	if flags&FlagSigned == 0 {
+		return nil
+	}
+	mac := d.string("MAC", MaxMACLength)
+	if d.err != nil {
+		return nil
+	}
+	return []byte(mac)
+}
+
+// end check that the whole frame was read
+func (d *decoder) end() error {
+	if d.err == nil && d.idx != len(d.data) {
+		d.fail("end", "%d trailing bytes", len(d.data)-d.idx)
+	}
+	return d.err
+}
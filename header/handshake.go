@@ -0,0 +1,95 @@
+package header
+
+import (
+	"encoding/binary"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+)
+
+// HandshakeVersion version of the handshake frame
+const HandshakeVersion = 1
+
+// Handshake the frame both peers send once when a connection is set up, it looks like:
+// +---------+----------------+-------------------+-----------------+
+// | Version | MaxMessageSize |    Compressors    |   Serializers   |
+// +---------+----------------+-------------------+-----------------+
+// |  uint8  |     uvarint    | uvarint+uvarint*n | uvarint+uint8*n |
+// +---------+----------------+-------------------+-----------------+
+// The lists were added later, a frame ending after MaxMessageSize comes from a peer which does not advertise them
+type Handshake struct {
+	Version        uint8
+	MaxMessageSize uint32                     // zero means unlimited
+	Compressors    []compressor.CompressType  // compress types the peer can decompress
+	Serializers    []serializer.SerializeType // serialize types the peer can decode
+}
+
+// Marshal will encode handshake into a byte slice
+func (h *Handshake) Marshal() []byte {
+	idx := 0
+	data := make([]byte, Uint8Size+3*binary.MaxVarintLen64+len(h.Compressors)*binary.MaxVarintLen16+len(h.Serializers))
+	data[idx] = h.Version
+	idx += Uint8Size
+	idx += binary.PutUvarint(data[idx:], uint64(h.MaxMessageSize))
+	idx += binary.PutUvarint(data[idx:], uint64(len(h.Compressors)))
+	for _, t := range h.Compressors {
+		idx += binary.PutUvarint(data[idx:], uint64(t))
+	}
+	idx += binary.PutUvarint(data[idx:], uint64(len(h.Serializers)))
+	for _, t := range h.Serializers {
+		data[idx] = byte(t)
+		idx += Uint8Size
+	}
+	return data[:idx]
+}
+
+// Unmarshal will decode handshake from a byte slice
+func (h *Handshake) Unmarshal(data []byte) (err error) {
+	if len(data) == 0 {
+		return UnmarshalError
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = UnmarshalError
+		}
+	}()
+
+	idx := 0
+	h.Version = data[idx]
+	idx += Uint8Size
+
+	size, n := binary.Uvarint(data[idx:])
+	if n <= 0 {
+		return UnmarshalError
+	}
+	h.MaxMessageSize = uint32(size)
+	idx += n
+
+	h.Compressors, h.Serializers = nil, nil
+	if idx == len(data) {
+		// 对端未声明支持的压缩和序列化格式
+		return
+	}
+	count, n := binary.Uvarint(data[idx:])
+	if n <= 0 || count > uint64(len(data)) {
+		return UnmarshalError
+	}
+	idx += n
+	for i := uint64(0); i < count; i++ {
+		t, n := binary.Uvarint(data[idx:])
+		if n <= 0 {
+			return UnmarshalError
+		}
+		h.Compressors = append(h.Compressors, compressor.CompressType(t))
+		idx += n
+	}
+	count, n = binary.Uvarint(data[idx:])
+	if n <= 0 || count > uint64(len(data)) {
+		return UnmarshalError
+	}
+	idx += n
+	for i := uint64(0); i < count; i++ {
+		h.Serializers = append(h.Serializers, serializer.SerializeType(data[idx]))
+		idx += Uint8Size
+	}
+	return
+}
@@ -0,0 +1,43 @@
+package header
+
+import (
+	"testing"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHandshake .
+func TestHandshake(t *testing.T) {
+	h := &Handshake{Version: HandshakeVersion, MaxMessageSize: 1024}
+	data := h.Marshal()
+	assert.Equal(t, []byte{0x1, 0x80, 0x8, 0x0, 0x0}, data)
+
+	decoded := &Handshake{}
+	assert.Nil(t, decoded.Unmarshal(data))
+	assert.Equal(t, h, decoded)
+
+	assert.Equal(t, UnmarshalError, decoded.Unmarshal(nil))
+	assert.Equal(t, UnmarshalError, decoded.Unmarshal([]byte{0x1}))
+}
+
+// TestHandshake_Capabilities .
+func TestHandshake_Capabilities(t *testing.T) {
+	h := &Handshake{
+		Version:        HandshakeVersion,
+		MaxMessageSize: 1024,
+		Compressors:    []compressor.CompressType{compressor.Raw, compressor.Gzip, 300},
+		Serializers:    []serializer.SerializeType{serializer.ProtoType, serializer.JSONType},
+	}
+	data := h.Marshal()
+	assert.Equal(t, []byte{0x1, 0x80, 0x8, 0x3, 0x0, 0x1, 0xac, 0x2, 0x2, 0x1, 0x2}, data)
+	decoded := &Handshake{}
+	assert.Nil(t, decoded.Unmarshal(data))
+	assert.Equal(t, h, decoded)
+
+	// 旧版本的对端不声明支持的格式
+	assert.Nil(t, decoded.Unmarshal([]byte{0x1, 0x80, 0x8}))
+	assert.Equal(t, &Handshake{Version: HandshakeVersion, MaxMessageSize: 1024}, decoded)
+	assert.Equal(t, UnmarshalError, decoded.Unmarshal(data[:len(data)-1]))
+}
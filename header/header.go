@@ -3,171 +3,307 @@ package header
 import (
 	"encoding/binary"
 	"errors"
+	"sort"
 	"sync"
+	"tiny_rpc/checksum"
 	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
 )
 
 const (
-	// MaxHeaderSize = 2 + 10 + 10 + 10 + 4 (10 refer to binary.MaxVarintLen64)
-	MaxHeaderSize = 36
+	// MaxHeaderSize = 2 + 1 + 1 + 1 + 10 + 10 + 10 + 10 + 10 + 8 (10 refer to binary.MaxVarintLen64)
+	MaxHeaderSize = 63
 
+	Uint64Size = 8
 	Uint32Size = 4
 	Uint16Size = 2
+	Uint8Size  = 1
 )
 
-var UnmarshalError = errors.New("an error occurred in Unmarshal")
+var (
+	UnmarshalError       = errors.New("an error occurred in Unmarshal")
+	MalformedHeaderError = errors.New("malformed header")
+)
+
+// MaxMethodLength the longest method name RequestHeader.Unmarshal accepts
+var MaxMethodLength = 4096
+
+// MaxMACLength the longest MAC the headers accept
+const MaxMACLength = 64
+
+// FlagSigned marks a request or a response whose header ends with the MAC of the message,
+// it is the same bit in both headers
+const FlagSigned uint8 = 1 << 7
+
+// FlagPadded marks a request or a response whose body is followed by padding, the header then
+// records the length of the body without it. It is the same bit in both headers
+const FlagPadded uint8 = 1 << 6
+
+// Request flags
+const (
+	// FlagPing marks a keepalive request without body, the server answers it with FlagPong
+	FlagPing uint8 = 1 << iota
+	// FlagOneway marks a request whose response is not wanted, the server sends none
+	FlagOneway
+)
 
 // RequestHeader request header structure looks like:
-// +--------------+----------------+----------+------------+----------+
-// | CompressType |      Method    |    ID    | RequestLen | Checksum |
-// +--------------+----------------+----------+------------+----------+
-// |    uint16    | uvarint+string |  uvarint |   uvarint  |  uint32  |
-// +--------------+----------------+----------+------------+----------+
+// +--------------+--------------+---------------+-------+----------------+----------+------------+------------------+----------+
+// | CompressType | ChecksumType | SerializeType | Flags |      Method    |    ID    | RequestLen |     Metadata     | Checksum |
+// +--------------+--------------+---------------+-------+----------------+----------+------------+------------------+----------+
+// |    uint16    |     uint8    |     uint8     | uint8 | uvarint+string |  uvarint |   uvarint  | uvarint+string*2n|  uint64  |
+// +--------------+--------------+---------------+-------+----------------+----------+------------+------------------+----------+
+// followed by BodyLen, uvarint, when Flags has FlagPadded and by the MAC, uvarint+bytes, when Flags has FlagSigned
 type RequestHeader struct {
 	sync.RWMutex
-	CompressType compressor.CompressType
-	Method       string
-	ID           uint64
-	RequestLen   uint32
-	Checksum     uint32
+	CompressType  compressor.CompressType
+	ChecksumType  checksum.ChecksumType
+	SerializeType serializer.SerializeType // serializer of the body, zero means the one configured on the server
+	Flags         uint8
+	Method        string
+	ID            uint64
+	RequestLen    uint32
+	Metadata      map[string]string
+	Checksum      uint64
+	BodyLen       uint32 // length of the body without its padding, only encoded with FlagPadded
+	MAC           []byte // authenticates the header and the body, only encoded with FlagSigned
 }
 
 // Marshal will encode request header into a byte slice
 func (r *RequestHeader) Marshal() []byte {
+	return r.MarshalTo(nil)
+}
+
+// MarshalTo encode request header into buf and return the encoded bytes, buf is reused
+// when it has enough capacity, otherwise a new slice is allocated
+func (r *RequestHeader) MarshalTo(buf []byte) []byte {
 	r.RLock()
 	defer r.RUnlock()
 
 	idx := 0
-	// MaxHeaderSize = 2 + 10 + len(string) + 10 + 10 + 4
-	header := make([]byte, MaxHeaderSize+len(r.Method))
+	// MaxHeaderSize = 2 + 1 + 1 + 1 + 10 + len(string) + 10 + 10 + 8, plus the metadata
+	header := grow(buf, MaxHeaderSize+len(r.Method)+metadataSize(r.Metadata)+bodyLenSize(r.Flags)+macSize(r.Flags, r.MAC))
 	binary.LittleEndian.PutUint16(header[idx:], uint16(r.CompressType))
 	idx += Uint16Size
 
+	header[idx] = byte(r.ChecksumType)
+	idx += Uint8Size
+
+	header[idx] = byte(r.SerializeType)
+	idx += Uint8Size
+
+	header[idx] = r.Flags
+	idx += Uint8Size
+
 	idx += writeString(header[idx:], r.Method)
 	idx += binary.PutUvarint(header[idx:], r.ID)
 	idx += binary.PutUvarint(header[idx:], uint64(r.RequestLen))
+	idx += writeMetadata(header[idx:], r.Metadata)
 
-	binary.LittleEndian.PutUint32(header[idx:], r.Checksum)
-	idx += Uint32Size
+	binary.LittleEndian.PutUint64(header[idx:], r.Checksum)
+	idx += Uint64Size
+	idx += writeBodyLen(header[idx:], r.Flags, r.BodyLen)
+	idx += writeMAC(header[idx:], r.Flags, r.MAC)
 
 	return header[:idx]
 }
 
-// Unmarshal will decode request header into a byte slice
-func (r *RequestHeader) Unmarshal(data []byte) (err error) {
+// Unmarshal will decode request header into a byte slice, frames which are truncated, too long
+// or declare fields beyond their end fail with a *HeaderError
+func (r *RequestHeader) Unmarshal(data []byte) error {
 	r.Lock()
 	defer r.Unlock()
 	if len(data) == 0 {
 		return UnmarshalError
 	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			err = UnmarshalError
-		}
-	}()
-
-	idx, size := 0, 0
-	r.CompressType = compressor.CompressType(binary.LittleEndian.Uint16(data[idx:]))
-	idx += Uint16Size
-
-	r.Method, size = readString(data[idx:])
-	idx += size
+	// 全部字段解码成功后才写入请求头
+	d := &decoder{data: data}
+	compressType := compressor.CompressType(d.uint16("CompressType"))
+	checksumType := checksum.ChecksumType(d.uint8("ChecksumType"))
+	serializeType := serializer.SerializeType(d.uint8("SerializeType"))
+	flags := d.uint8("Flags")
+	// 拒绝声明了超长方法名的请求头
+	method := d.string("Method", MaxMethodLength)
+	id := d.uvarint("ID")
+	requestLen := d.uint32("RequestLen")
+	metadata := d.metadata("Metadata")
+	digest := d.uint64("Checksum")
+	bodyLen := d.bodyLen(flags, requestLen)
+	mac := d.mac(flags)
+	if err := d.end(); err != nil {
+		return err
+	}
 
-	r.ID, size = binary.Uvarint(data[idx:])
-	idx += size
+	r.CompressType = compressType
+	r.ChecksumType = checksumType
+	r.SerializeType = serializeType
+	r.Flags = flags
+	r.Method = method
+	r.ID = id
+	r.RequestLen = requestLen
+	r.Metadata = metadata
+	r.Checksum = digest
+	r.BodyLen = bodyLen
+	r.MAC = mac
+	return nil
+}
 
-	length, sz := binary.Uvarint(data[idx:])
-	r.RequestLen = uint32(length)
-	idx += sz
+func (r *RequestHeader) GetCompressType() compressor.CompressType {
+	r.RLock()
+	defer r.RUnlock()
+	return r.CompressType
+}
 
-	r.Checksum = binary.LittleEndian.Uint32(data[idx:])
+// GetChecksumType get checksum type
+func (r *RequestHeader) GetChecksumType() checksum.ChecksumType {
+	r.RLock()
+	defer r.RUnlock()
+	return r.ChecksumType
+}
 
-	return
+// GetSerializeType get serialize type
+func (r *RequestHeader) GetSerializeType() serializer.SerializeType {
+	r.RLock()
+	defer r.RUnlock()
+	return r.SerializeType
 }
 
-func (r *RequestHeader) GetCompressType() compressor.CompressType {
+// GetFlags get request flags
+func (r *RequestHeader) GetFlags() uint8 {
 	r.RLock()
 	defer r.RUnlock()
-	return r.CompressType
+	return r.Flags
 }
 
 func (r *RequestHeader) ResetHeader() {
 	r.Lock()
 	defer r.Unlock()
 	r.ID = 0
+	r.Flags = 0
+	r.SerializeType = 0
 	r.Checksum = 0
+	r.ChecksumType = checksum.Crc32
 	r.Method = ""
 	r.CompressType = compressor.Raw
 	r.RequestLen = 0
+	r.Metadata = nil
+	r.BodyLen = 0
+	r.MAC = nil
 }
 
+// Response flags
+const (
+	// FlagStreamChunk marks a response carrying one message of a stream, the final response of the call follows it
+	FlagStreamChunk uint8 = 1 << iota
+	// FlagPong marks the answer to a FlagPing request
+	FlagPong
+	// FlagError marks a response carrying only an error, it has no body to decompress or verify
+	FlagError
+	// FlagStreamBody marks the frames of a body streamed from an io.Reader: the response opening
+	// the stream has an empty body, each following frame of the call carries raw bytes and the
+	// stream ends with an empty frame, or with a frame also marked FlagError
+	FlagStreamBody
+)
+
 // ResponseHeader request header structure looks like:
-// +--------------+---------+----------------+-------------+----------+
-// | CompressType |    ID   |      Error     | ResponseLen | Checksum |
-// +--------------+---------+----------------+-------------+----------+
-// |    uint16    | uvarint | uvarint+string |    uvarint  |  uint32  |
-// +--------------+---------+----------------+-------------+----------+
+// +--------------+--------------+---------------+-------+---------+----------------+-----------+-------------+----------+
+// | CompressType | ChecksumType | SerializeType | Flags |    ID   |      Error     | ErrorCode | ResponseLen | Checksum |
+// +--------------+--------------+---------------+-------+---------+----------------+-----------+-------------+----------+
+// |    uint16    |     uint8    |     uint8     | uint8 | uvarint | uvarint+string |  uvarint  |    uvarint  |  uint64  |
+// +--------------+--------------+---------------+-------+---------+----------------+-----------+-------------+----------+
+// followed by BodyLen, uvarint, when Flags has FlagPadded and by the MAC, uvarint+bytes, when Flags has FlagSigned
 type ResponseHeader struct {
 	sync.RWMutex
-	CompressType compressor.CompressType
-	ID           uint64
-	Error        string
-	ResponseLen  uint32
-	Checksum     uint32
+	CompressType  compressor.CompressType
+	ChecksumType  checksum.ChecksumType
+	SerializeType serializer.SerializeType
+	Flags         uint8
+	ID            uint64
+	Error         string
+	ErrorCode     uint32 // application error code of an error response, zero if none
+	ResponseLen   uint32
+	Checksum      uint64
+	BodyLen       uint32 // length of the body without its padding, only encoded with FlagPadded
+	MAC           []byte // authenticates the header and the body, only encoded with FlagSigned
 }
 
 // Marshal will encode response header into a byte slice
 func (r *ResponseHeader) Marshal() []byte {
+	return r.MarshalTo(nil)
+}
+
+// MarshalTo encode response header into buf and return the encoded bytes, buf is reused
+// when it has enough capacity, otherwise a new slice is allocated
+func (r *ResponseHeader) MarshalTo(buf []byte) []byte {
 	r.RLock()
 	defer r.RUnlock()
 
 	idx := 0
-	header := make([]byte, MaxHeaderSize+len(r.Error))
+	header := grow(buf, MaxHeaderSize+len(r.Error)+bodyLenSize(r.Flags)+macSize(r.Flags, r.MAC))
 
 	binary.LittleEndian.PutUint16(header[idx:], uint16(r.CompressType))
 	idx += Uint16Size
 
+	header[idx] = byte(r.ChecksumType)
+	idx += Uint8Size
+
+	header[idx] = byte(r.SerializeType)
+	idx += Uint8Size
+
+	header[idx] = r.Flags
+	idx += Uint8Size
+
 	idx += binary.PutUvarint(header[idx:], r.ID)
 	idx += writeString(header[idx:], r.Error)
+	idx += binary.PutUvarint(header[idx:], uint64(r.ErrorCode))
 	idx += binary.PutUvarint(header[idx:], uint64(r.ResponseLen))
 
-	binary.LittleEndian.PutUint32(header[idx:], r.Checksum)
-	idx += Uint32Size
+	binary.LittleEndian.PutUint64(header[idx:], r.Checksum)
+	idx += Uint64Size
+	idx += writeBodyLen(header[idx:], r.Flags, r.BodyLen)
+	idx += writeMAC(header[idx:], r.Flags, r.MAC)
 	return header[:idx]
 }
 
-// Unmarshal will decode response header into a byte slice
-func (r *ResponseHeader) Unmarshal(data []byte) (err error) {
+// Unmarshal will decode response header into a byte slice, frames which are truncated, too long
+// or declare fields beyond their end fail with a *HeaderError
+func (r *ResponseHeader) Unmarshal(data []byte) error {
 	r.Lock()
 	defer r.Unlock()
-
 	if len(data) == 0 {
 		return UnmarshalError
 	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			err = UnmarshalError
-		}
-	}()
-
-	idx, size := 0, 0
-	r.CompressType = compressor.CompressType(binary.LittleEndian.Uint16(data[idx:]))
-	idx += Uint16Size
-
-	r.ID, size = binary.Uvarint(data[idx:])
-	idx += size
-
-	r.Error, size = readString(data[idx:])
-	idx += size
-
-	length, size := binary.Uvarint(data[idx:])
-	r.ResponseLen = uint32(length)
-	idx += size
+	// 全部字段解码成功后才写入响应头
+	d := &decoder{data: data}
+	compressType := compressor.CompressType(d.uint16("CompressType"))
+	checksumType := checksum.ChecksumType(d.uint8("ChecksumType"))
+	serializeType := serializer.SerializeType(d.uint8("SerializeType"))
+	flags := d.uint8("Flags")
+	id := d.uvarint("ID")
+	errmsg := d.string("Error", -1)
+	code := d.uint32("ErrorCode")
+	responseLen := d.uint32("ResponseLen")
+	digest := d.uint64("Checksum")
+	bodyLen := d.bodyLen(flags, responseLen)
+	mac := d.mac(flags)
+	if err := d.end(); err != nil {
+		return err
+	}
 
-	r.Checksum = binary.LittleEndian.Uint32(data[idx:])
-	return
+	r.CompressType = compressType
+	r.ChecksumType = checksumType
+	r.SerializeType = serializeType
+	r.Flags = flags
+	r.ID = id
+	r.Error = errmsg
+	r.ErrorCode = code
+	r.ResponseLen = responseLen
+	r.Checksum = digest
+	r.BodyLen = bodyLen
+	r.MAC = mac
+	return nil
 }
 
 // GetCompressType get compress type
@@ -177,24 +313,50 @@ func (r *ResponseHeader) GetCompressType() compressor.CompressType {
 	return r.CompressType
 }
 
+// GetChecksumType get checksum type
+func (r *ResponseHeader) GetChecksumType() checksum.ChecksumType {
+	r.RLock()
+	defer r.RUnlock()
+	return r.ChecksumType
+}
+
+// GetSerializeType get serialize type
+func (r *ResponseHeader) GetSerializeType() serializer.SerializeType {
+	r.RLock()
+	defer r.RUnlock()
+	return r.SerializeType
+}
+
+// GetFlags get response flags
+func (r *ResponseHeader) GetFlags() uint8 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.Flags
+}
+
 // ResetHeader reset response header
 func (r *ResponseHeader) ResetHeader() {
 	r.Lock()
 	defer r.Unlock()
 	r.Error = ""
+	r.ErrorCode = 0
 	r.ID = 0
 	r.CompressType = compressor.Raw
+	r.ChecksumType = checksum.Crc32
+	r.SerializeType = 0
+	r.Flags = 0
 	r.Checksum = 0
 	r.ResponseLen = 0
+	r.BodyLen = 0
+	r.MAC = nil
 }
 
-func readString(data []byte) (string, int) {
-	idx := 0
-	length, size := binary.Uvarint(data)
-	idx += size
-	str := string(data[idx : idx+int(length)])
-	idx += len(str)
-	return str, idx
+// grow return buf resliced to size, or a new slice when its capacity is too small
+func grow(buf []byte, size int) []byte {
+	if cap(buf) < size {
+		return make([]byte, size)
+	}
+	return buf[:size]
 }
 
 func writeString(data []byte, str string) int {
@@ -204,3 +366,61 @@ func writeString(data []byte, str string) int {
 	idx += len(str)
 	return idx
 }
+
+// metadataSize the max encoded size of metadata
+func metadataSize(metadata map[string]string) int {
+	size := 0
+	for k, v := range metadata {
+		size += 2*binary.MaxVarintLen64 + len(k) + len(v)
+	}
+	return size
+}
+
+// writeMetadata encode metadata as a count followed by key value pairs sorted by key
+func writeMetadata(data []byte, metadata map[string]string) int {
+	idx := binary.PutUvarint(data, uint64(len(metadata)))
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		idx += writeString(data[idx:], k)
+		idx += writeString(data[idx:], metadata[k])
+	}
+	return idx
+}
+
+// bodyLenSize the max encoded size of BodyLen, zero unless flags has FlagPadded
+func bodyLenSize(flags uint8) int {
+	if flags&FlagPadded == 0 {
+		return 0
+	}
+	return binary.MaxVarintLen32
+}
+
+// writeBodyLen encode the length of the body without its padding when flags has FlagPadded
+func writeBodyLen(data []byte, flags uint8, n uint32) int {
+	if flags&FlagPadded == 0 {
+		return 0
+	}
+	return binary.PutUvarint(data, uint64(n))
+}
+
+// macSize the max encoded size of the MAC, zero unless flags has FlagSigned
+func macSize(flags uint8, mac []byte) int {
+	if flags&FlagSigned == 0 {
+		return 0
+	}
+	return binary.MaxVarintLen64 + len(mac)
+}
+
+// writeMAC encode the length prefixed MAC when flags has FlagSigned
+func writeMAC(data []byte, flags uint8, mac []byte) int {
+	if flags&FlagSigned == 0 {
+		return 0
+	}
+	idx := binary.PutUvarint(data, uint64(len(mac)))
+	idx += copy(data[idx:], mac)
+	return idx
+}
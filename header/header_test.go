@@ -5,9 +5,14 @@
 package header
 
 import (
+	"bytes"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
+	"tiny_rpc/checksum"
 	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,8 +27,55 @@ func TestRequestHeader_Marshal(t *testing.T) {
 		Checksum:     3845236589,
 	}
 
-	assert.Equal(t, []byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
-		0xa7, 0x61, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5}, header.Marshal())
+	assert.Equal(t, []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+		0xa7, 0x61, 0x8a, 0x2, 0x0, 0x6d, 0xa7, 0x31, 0xe5, 0x0, 0x0, 0x0, 0x0}, header.Marshal())
+}
+
+// TestRequestHeader_MarshalMetadata .
+func TestRequestHeader_MarshalMetadata(t *testing.T) {
+	header := &RequestHeader{
+		Method:   "Add",
+		ID:       1,
+		Metadata: map[string]string{"b": "2", "a": "1"},
+	}
+
+	data := header.Marshal()
+	assert.Equal(t, []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x41, 0x64, 0x64, 0x1, 0x0, 0x2,
+		0x1, 0x61, 0x1, 0x31, 0x1, 0x62, 0x1, 0x32,
+		0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}, data)
+
+	h := &RequestHeader{}
+	assert.Nil(t, h.Unmarshal(data))
+	assert.Equal(t, header.Metadata, h.Metadata)
+}
+
+// TestHeader_MarshalMAC .
+func TestHeader_MarshalMAC(t *testing.T) {
+	mac := bytes.Repeat([]byte{0xab}, 32)
+	request := &RequestHeader{Method: "Add", ID: 1, Flags: FlagSigned, MAC: mac}
+	data := request.Marshal()
+	// MAC 以长度前缀编码在末尾
+	assert.Equal(t, append([]byte{32}, mac...), data[len(data)-33:])
+	h := &RequestHeader{}
+	assert.Nil(t, h.Unmarshal(data))
+	assert.Equal(t, mac, h.MAC)
+
+	// 未设置 FlagSigned 时不编码 MAC
+	request.Flags = 0
+	assert.Nil(t, h.Unmarshal(request.Marshal()))
+	assert.Nil(t, h.MAC)
+
+	response := &ResponseHeader{ID: 1, Flags: FlagSigned | FlagError, Error: "boom", MAC: mac}
+	r := &ResponseHeader{}
+	assert.Nil(t, r.Unmarshal(response.Marshal()))
+	assert.Equal(t, mac, r.MAC)
+	assert.Equal(t, "boom", r.Error)
+
+	// 超长的 MAC 被拒绝
+	response.MAC = make([]byte, MaxMACLength+1)
+	var he *HeaderError
+	assert.True(t, errors.As(r.Unmarshal(response.Marshal()), &he))
+	assert.Equal(t, "MAC", he.Field)
 }
 
 // TestRequestHeader_Unmarshal .
@@ -39,8 +91,8 @@ func TestRequestHeader_Unmarshal(t *testing.T) {
 	}{
 		{
 			"test-1",
-			[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
-				0xa7, 0x61, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5},
+			[]byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+				0xa7, 0x61, 0x8a, 0x2, 0x0, 0x6d, 0xa7, 0x31, 0xe5, 0x0, 0x0, 0x0, 0x0},
 			expect{&RequestHeader{
 				CompressType: 0,
 				Method:       "Add",
@@ -59,7 +111,46 @@ func TestRequestHeader_Unmarshal(t *testing.T) {
 			"test-3",
 			[]byte{0x0},
 			expect{&RequestHeader{},
-				UnmarshalError},
+				MalformedHeaderError},
+		},
+		{
+			"test-4",
+			[]byte{0x1, 0x0, 0x2, 0x1, 0x0, 0x3, 0x41, 0x64, 0x64, 0xa7, 0x61, 0x8a, 0x2, 0x0,
+				0x6d, 0xa7, 0x31, 0xe5, 0x6d, 0xa7, 0x31, 0xe5},
+			expect{&RequestHeader{
+				CompressType:  compressor.Gzip,
+				ChecksumType:  checksum.XXHash64,
+				SerializeType: serializer.ProtoType,
+				Method:        "Add",
+				ID:            12455,
+				RequestLen:    266,
+				Checksum:      0xe531a76de531a76d,
+			}, nil},
+		},
+		{
+			"test-5",
+			[]byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x41, 0x64, 0x64, 0x1, 0x0, 0x1, 0x1, 0x6b, 0x1, 0x76,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+			expect{&RequestHeader{
+				Method:   "Add",
+				ID:       1,
+				Metadata: map[string]string{"k": "v"},
+			}, nil},
+		},
+		{
+			"test-6",
+			[]byte{0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+			expect{&RequestHeader{
+				Flags: FlagPing,
+			}, nil},
+		},
+		{
+			"test-7",
+			[]byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x1,
+				0x41, 0x64, 0x64, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+			expect{&RequestHeader{},
+				MalformedHeaderError},
 		},
 	}
 	for _, c := range cases {
@@ -67,11 +158,22 @@ func TestRequestHeader_Unmarshal(t *testing.T) {
 			h := &RequestHeader{}
 			err := h.Unmarshal(c.data)
 			assert.Equal(t, true, reflect.DeepEqual(c.expect.header, h))
-			assert.Equal(t, err, c.expect.err)
+			assert.True(t, errors.Is(err, c.expect.err))
 		})
 	}
 }
 
+// TestRequestHeader_MaxMethodLength .
+func TestRequestHeader_MaxMethodLength(t *testing.T) {
+	defer func(length int) { MaxMethodLength = length }(MaxMethodLength)
+	data := (&RequestHeader{Method: strings.Repeat("a", 100)}).Marshal()
+
+	h := &RequestHeader{}
+	assert.Nil(t, h.Unmarshal(data))
+	MaxMethodLength = 99
+	assert.True(t, errors.Is(h.Unmarshal(data), MalformedHeaderError))
+}
+
 // TestRequestHeader_ResetHeader .
 func TestRequestHeader_ResetHeader(t *testing.T) {
 	header := &RequestHeader{
@@ -95,8 +197,8 @@ func TestResponseHeader_Marshal(t *testing.T) {
 		Checksum:     3845236589,
 	}
 
-	assert.Equal(t, []byte{0x0, 0x0, 0xa7, 0x61, 0x5, 0x65, 0x72,
-		0x72, 0x6f, 0x72, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5}, header.Marshal())
+	assert.Equal(t, []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0xa7, 0x61, 0x5, 0x65,
+		0x72, 0x72, 0x6f, 0x72, 0x0, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5, 0x0, 0x0, 0x0, 0x0}, header.Marshal())
 }
 
 // TestResponseHeader_Unmarshal .
@@ -112,8 +214,8 @@ func TestResponseHeader_Unmarshal(t *testing.T) {
 	}{
 		{
 			"test-1",
-			[]byte{0x0, 0x0, 0xa7, 0x61, 0x5, 0x65, 0x72,
-				0x72, 0x6f, 0x72, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5},
+			[]byte{0x0, 0x0, 0x0, 0x0, 0x0, 0xa7, 0x61, 0x5, 0x65,
+				0x72, 0x72, 0x6f, 0x72, 0x0, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5, 0x0, 0x0, 0x0, 0x0},
 			expect{&ResponseHeader{
 				CompressType: 0,
 				Error:        "error",
@@ -132,7 +234,36 @@ func TestResponseHeader_Unmarshal(t *testing.T) {
 			"test-3",
 			[]byte{0x0},
 			expect{&ResponseHeader{},
-				UnmarshalError},
+				MalformedHeaderError},
+		},
+		{
+			"test-4",
+			[]byte{0x0, 0x0, 0x0, 0x2, 0x0, 0x1, 0x0, 0x0, 0x0,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+			expect{&ResponseHeader{
+				SerializeType: serializer.JSONType,
+				ID:            1,
+			}, nil},
+		},
+		{
+			"test-5",
+			[]byte{0x0, 0x0, 0x0, 0x0, 0x1, 0x2, 0x0, 0x0, 0x0,
+				0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+			expect{&ResponseHeader{
+				Flags: FlagStreamChunk,
+				ID:    2,
+			}, nil},
+		},
+		{
+			"test-6",
+			[]byte{0x0, 0x0, 0x0, 0x0, 0x4, 0x3, 0x5, 0x65, 0x72, 0x72, 0x6f, 0x72,
+				0x91, 0x4e, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+			expect{&ResponseHeader{
+				Flags:     FlagError,
+				ID:        3,
+				Error:     "error",
+				ErrorCode: 10001,
+			}, nil},
 		},
 	}
 	for _, c := range cases {
@@ -140,7 +271,7 @@ func TestResponseHeader_Unmarshal(t *testing.T) {
 			h := &ResponseHeader{}
 			err := h.Unmarshal(c.data)
 			assert.Equal(t, true, reflect.DeepEqual(c.expect.header, h))
-			assert.Equal(t, err, c.expect.err)
+			assert.True(t, errors.Is(err, c.expect.err))
 		})
 	}
 }
@@ -183,3 +314,147 @@ func TestRequestHeader_GetCompressType(t *testing.T) {
 
 	assert.Equal(t, true, reflect.DeepEqual(compressor.CompressType(0), header.GetCompressType()))
 }
+
+// TestHeader_MarshalTo .
+func TestHeader_MarshalTo(t *testing.T) {
+	request := &RequestHeader{
+		CompressType:  compressor.Gzip,
+		ChecksumType:  checksum.XXHash64,
+		SerializeType: serializer.JSONType,
+		Method:        "ArithService.Add",
+		ID:            12455,
+		RequestLen:    266,
+		Metadata:      map[string]string{"b": "2", "a": "1"},
+		Checksum:      3845236589,
+	}
+	response := &ResponseHeader{
+		CompressType: compressor.Snappy,
+		ID:           12455,
+		Error:        "failed",
+		ResponseLen:  266,
+		Checksum:     3845236589,
+	}
+	cases := []struct {
+		name string
+		buf  []byte
+	}{
+		{"test-1", nil},
+		{"test-2", make([]byte, 4)},
+		{"test-3", []byte(strings.Repeat("\xff", 256))},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, request.Marshal(), request.MarshalTo(bytes.Clone(c.buf)))
+			assert.Equal(t, response.Marshal(), response.MarshalTo(bytes.Clone(c.buf)))
+		})
+	}
+
+	// 容量足够时复用缓冲区
+	buf := make([]byte, 0, 256)
+	data := request.MarshalTo(buf)
+	assert.Same(t, &buf[:1][0], &data[0])
+}
+
+// BenchmarkRequestHeader_Marshal .
+func BenchmarkRequestHeader_Marshal(b *testing.B) {
+	h := &RequestHeader{Method: "ArithService.Add", ID: 12455, RequestLen: 266, Checksum: 3845236589}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = h.Marshal()
+	}
+}
+
+// BenchmarkRequestHeader_MarshalTo .
+func BenchmarkRequestHeader_MarshalTo(b *testing.B) {
+	h := &RequestHeader{Method: "ArithService.Add", ID: 12455, RequestLen: 266, Checksum: 3845236589}
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = h.MarshalTo(buf)
+	}
+}
+
+// BenchmarkResponseHeader_Marshal .
+func BenchmarkResponseHeader_Marshal(b *testing.B) {
+	h := &ResponseHeader{ID: 12455, ResponseLen: 266, Checksum: 3845236589}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = h.Marshal()
+	}
+}
+
+// BenchmarkResponseHeader_MarshalTo .
+func BenchmarkResponseHeader_MarshalTo(b *testing.B) {
+	h := &ResponseHeader{ID: 12455, ResponseLen: 266, Checksum: 3845236589}
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = h.MarshalTo(buf)
+	}
+}
+
+// TestHeader_Malformed .
+func TestHeader_Malformed(t *testing.T) {
+	request := (&RequestHeader{Method: "Add", ID: 1, Metadata: map[string]string{"k": "v"}}).Marshal()
+	response := (&ResponseHeader{ID: 1, Error: "error"}).Marshal()
+	cases := []struct {
+		name     string
+		response bool
+		data     []byte
+		field    string
+	}{
+		{"test-1", false, request[:4], "Flags"},
+		{"test-2", false, request[:7], "Method"},
+		{"test-3", false, request[:len(request)-1], "Checksum"},
+		{"test-4", false, append(bytes.Clone(request), 0x0), "end"},
+		{"test-5", false, []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x41, 0x64, 0x64, 0x1, 0x0, 0x7f}, "Metadata"},
+		{"test-6", false, []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x80, 0x80, 0x80, 0x80, 0x10}, "RequestLen"},
+		{"test-7", true, response[:6], "Error"},
+		{"test-8", true, response[:len(response)-8], "Checksum"},
+		{"test-9", true, append(bytes.Clone(response), 0x0, 0x0), "end"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var err error
+			if c.response {
+				h := &ResponseHeader{}
+				err = h.Unmarshal(c.data)
+				assert.Equal(t, &ResponseHeader{}, h)
+			} else {
+				h := &RequestHeader{}
+				err = h.Unmarshal(c.data)
+				assert.Equal(t, &RequestHeader{}, h)
+			}
+			assert.True(t, errors.Is(err, MalformedHeaderError))
+			var he *HeaderError
+			assert.True(t, errors.As(err, &he))
+			assert.Equal(t, c.field, he.Field)
+		})
+	}
+}
+
+// TestHeader_MarshalBodyLen .
+func TestHeader_MarshalBodyLen(t *testing.T) {
+	request := &RequestHeader{Method: "Add", ID: 1, Flags: FlagPadded | FlagSigned, RequestLen: 64, BodyLen: 20,
+		MAC: []byte{0x1}}
+	h := &RequestHeader{}
+	assert.Nil(t, h.Unmarshal(request.Marshal()))
+	assert.Equal(t, uint32(20), h.BodyLen)
+	assert.Equal(t, []byte{0x1}, h.MAC)
+
+	// 未设置 FlagPadded 时不编码补齐前的长度
+	request.Flags = 0
+	assert.Nil(t, h.Unmarshal(request.Marshal()))
+	assert.Zero(t, h.BodyLen)
+
+	response := &ResponseHeader{ID: 1, Flags: FlagPadded, ResponseLen: 32, BodyLen: 32}
+	r := &ResponseHeader{}
+	assert.Nil(t, r.Unmarshal(response.Marshal()))
+	assert.Equal(t, uint32(32), r.BodyLen)
+
+	// 补齐前的长度不能超过发送的长度
+	response.BodyLen = 33
+	var he *HeaderError
+	assert.True(t, errors.As(r.Unmarshal(response.Marshal()), &he))
+	assert.Equal(t, "BodyLen", he.Field)
+}
@@ -0,0 +1,97 @@
+package tiny_rpc
+
+import (
+	"sync"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// HealthServiceName the name the built-in health service is registered under
+const HealthServiceName = "Health"
+
+// ServingStatus the health of the server or of one of its services
+type ServingStatus int32
+
+const (
+	StatusUnknown  ServingStatus = iota
+	Serving                      // ready to handle calls
+	NotServing                   // calls should go to another server
+	ServiceUnknown               // no status was set for the service and it is not registered
+)
+
+func (s ServingStatus) String() string {
+	switch s {
+	case Serving:
+		return "SERVING"
+	case NotServing:
+		return "NOT_SERVING"
+	case ServiceUnknown:
+		return "SERVICE_UNKNOWN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// WithHealthCheck register the built-in Health service, Health.Check reports the status of the
+// service named by its argument, or of the whole server when the name is empty
+func WithHealthCheck() Option {
+	return func(o *options) {
+		o.healthCheck = true
+	}
+}
+
+// healthService answers Health.Check, its types are protobuf messages so that it can be
+// called with the proto and the json serializer alike
+type healthService struct {
+	server   *Server
+	mutex    sync.RWMutex
+	statuses map[string]ServingStatus
+}
+
+// Check report the serving status of the service args names
+func (h *healthService) Check(args *wrapperspb.StringValue, reply *wrapperspb.Int32Value) error {
+	reply.Value = int32(h.status(args.GetValue()))
+	return nil
+}
+
+func (h *healthService) status(service string) ServingStatus {
+	h.mutex.RLock()
+	status, ok := h.statuses[service]
+	h.mutex.RUnlock()
+	if ok {
+		return status
+	}
+	// 未设置状态时，服务端本身和已注册的服务视为可用
+	if service == "" {
+		return Serving
+	}
+	if _, ok = h.server.serviceMap.Load(service); ok {
+		return Serving
+	}
+	return ServiceUnknown
+}
+
+func (h *healthService) setStatus(service string, status ServingStatus) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.statuses == nil {
+		h.statuses = make(map[string]ServingStatus)
+	}
+	h.statuses[service] = status
+}
+
+// SetServingStatus set the status Health.Check reports for service, the empty name stands
+// for the whole server
+func (s *Server) SetServingStatus(service string, status ServingStatus) {
+	s.health.setStatus(service, status)
+}
+
+// HealthCheck call Health.Check on the server, service is empty to check the whole server
+func (c *Client) HealthCheck(service string) (ServingStatus, error) {
+	reply := &wrapperspb.Int32Value{}
+	err := c.Call(HealthServiceName+".Check", wrapperspb.String(service), reply)
+	if err != nil {
+		return StatusUnknown, err
+	}
+	return ServingStatus(reply.Value), nil
+}
@@ -0,0 +1,66 @@
+package tiny_rpc
+
+import (
+	"testing"
+	"tiny_rpc/serializer"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// TestServer_HealthCheck .
+func TestServer_HealthCheck(t *testing.T) {
+	server, listener := startServer(t, WithHealthCheck())
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	cases := []struct {
+		name    string
+		service string
+		set     bool
+		status  ServingStatus
+		expect  ServingStatus
+	}{
+		{"test-1", "", false, 0, Serving},
+		{"test-2", "ArithService", false, 0, Serving},
+		{"test-3", "NoService", false, 0, ServiceUnknown},
+		{"test-4", "", true, NotServing, NotServing},
+		{"test-5", "", true, Serving, Serving},
+		{"test-6", "ArithService", true, NotServing, NotServing},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.set {
+				server.SetServingStatus(c.service, c.status)
+			}
+			status, err := client.HealthCheck(c.service)
+			assert.Nil(t, err)
+			assert.Equal(t, c.expect, status)
+		})
+	}
+}
+
+// TestServer_HealthCheckJSON .
+func TestServer_HealthCheckJSON(t *testing.T) {
+	server, listener := startServer(t, WithHealthCheck(), WithSerializer(serializer.JSON))
+	server.SetServingStatus("", NotServing)
+	client, err := Dial("tcp", listener.Addr().String(), WithSerializer(serializer.JSON))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &wrapperspb.Int32Value{}
+	assert.Nil(t, client.Call("Health.Check", wrapperspb.String(""), reply))
+	assert.Equal(t, NotServing, ServingStatus(reply.Value))
+}
+
+// TestServer_HealthCheckDisabled .
+func TestServer_HealthCheckDisabled(t *testing.T) {
+	_, listener := startServer(t)
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	_, err = client.HealthCheck("")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,74 @@
+package tiny_rpc
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+const (
+	// DefaultRPCPath the path HandleHTTP and DialHTTP use when none is given
+	DefaultRPCPath = "/_tinyRPC_"
+
+	// connected the status line answering a successful CONNECT
+	connected = "200 Connected to tinyrpc"
+)
+
+// ServeHTTP implement an http.Handler answering CONNECT requests, the connection is hijacked
+// and served like the ones accepted by Serve
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		s.options.logger.Errorf("%s: hijacking %s: %v", s.label(), req.RemoteAddr, err)
+		return
+	}
+	conn = s.options.wrapConn(conn)
+	io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
+	s.ServeConn(conn)
+}
+
+// HandleHTTP register the server on rpcPath of http.DefaultServeMux,
+// DefaultRPCPath is used when rpcPath is empty
+func (s *Server) HandleHTTP(rpcPath string) {
+	if rpcPath == "" {
+		rpcPath = DefaultRPCPath
+	}
+	http.Handle(rpcPath, s)
+}
+
+// DialHTTP connect to an http server serving rpc on rpcPath and creates a client on
+// the connection, DefaultRPCPath is used when rpcPath is empty
+func DialHTTP(network, address, rpcPath string, opts ...Option) (*Client, error) {
+	if rpcPath == "" {
+		rpcPath = DefaultRPCPath
+	}
+	conn, err := dial(network, address, opts)
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(conn, "CONNECT "+rpcPath+" HTTP/1.0\n\n")
+
+	// 与 net/rpc 一致，收到 CONNECT 成功的应答后才切换到 rpc 协议
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err == nil && resp.Status == connected {
+		return NewClient(conn, opts...), nil
+	}
+	if err == nil {
+		err = errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	conn.Close()
+	return nil, &net.OpError{
+		Op:   "dial-http",
+		Net:  network + " " + address,
+		Addr: nil,
+		Err:  err,
+	}
+}
@@ -0,0 +1,51 @@
+package tiny_rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDialHTTP .
+func TestDialHTTP(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	// 使用独立的 mux，重复运行时不会在 DefaultServeMux 上重复注册
+	mux := http.NewServeMux()
+	mux.Handle("/rpc", server)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	client, err := DialHTTP("tcp", ts.Listener.Addr().String(), "/rpc")
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// TestDialHTTP_Errors .
+func TestDialHTTP_Errors(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// 非 CONNECT 请求被拒绝
+	resp, err := http.Get(ts.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	// 未注册的路径
+	notFound := httptest.NewServer(http.NewServeMux())
+	defer notFound.Close()
+	_, err = DialHTTP("tcp", notFound.Listener.Addr().String(), "")
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "404"))
+}
@@ -0,0 +1,40 @@
+package tiny_rpc
+
+import "context"
+
+// Handler invokes the rpc method with the decoded request and returns its reply
+type Handler func(ctx context.Context, req interface{}) (interface{}, error)
+
+// Interceptor runs around each dispatched call, it calls next to continue the chain
+// or returns an error to short-circuit the call
+type Interceptor func(ctx context.Context, method string, req interface{}, next Handler) (interface{}, error)
+
+// WithInterceptors append server interceptors, the first one runs outermost
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// PanicHandler observes the panics recovered from rpc methods and interceptors, stack is the stack
+// trace of the panicking goroutine. The call is answered with an error once the handler returns
+type PanicHandler func(method string, recovered interface{}, stack []byte)
+
+// WithPanicHandler run h on each recovered panic, to count them or report them elsewhere,
+// in place of logging them with the server logger
+func WithPanicHandler(h PanicHandler) Option {
+	return func(o *options) {
+		o.panicHandler = h
+	}
+}
+
+// chain wrap handler with the interceptors of method
+func chain(method string, interceptors []Interceptor, handler Handler) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, method, req, next)
+		}
+	}
+	return handler
+}
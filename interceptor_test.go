@@ -0,0 +1,71 @@
+package tiny_rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestServer_Interceptors .
+func TestServer_Interceptors(t *testing.T) {
+	var mutex sync.Mutex
+	var trace []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, method string, req interface{}, next Handler) (interface{}, error) {
+			mutex.Lock()
+			trace = append(trace, name+" before "+method)
+			mutex.Unlock()
+			reply, err := next(ctx, req)
+			mutex.Lock()
+			trace = append(trace, name+" after "+method)
+			mutex.Unlock()
+			return reply, err
+		}
+	}
+	deny := func(ctx context.Context, method string, req interface{}, next Handler) (interface{}, error) {
+		if method == "ArithService.Div" {
+			return nil, errors.New("permission denied")
+		}
+		return next(ctx, req)
+	}
+	_, listener := startServer(t, WithInterceptors(record("first"), record("second")), WithInterceptors(deny))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+	assert.Equal(t, []string{
+		"first before ArithService.Add",
+		"second before ArithService.Add",
+		"second after ArithService.Add",
+		"first after ArithService.Add",
+	}, trace)
+
+	err = client.Call("ArithService.Div", &pb.ArithRequest{A: 1, B: 2}, reply)
+	assert.Equal(t, "permission denied", err.Error())
+}
+
+// TestServer_InterceptorReplacesRequest .
+func TestServer_InterceptorReplacesRequest(t *testing.T) {
+	override := func(ctx context.Context, method string, req interface{}, next Handler) (interface{}, error) {
+		args := req.(*pb.ArithRequest)
+		args.B = 10
+		return next(ctx, args)
+	}
+	_, listener := startServer(t, WithInterceptors(override))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 11.0, reply.C)
+}
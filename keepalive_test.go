@@ -0,0 +1,75 @@
+package tiny_rpc
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestKeepalive .
+func TestKeepalive(t *testing.T) {
+	cases := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"test-1", 100 * time.Millisecond},
+		{"test-2", 0}, // 超时为零时使用心跳间隔
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			recorder := &statsRecorder{}
+			_, listener := startServer(t, WithStatsHandler(recorder), WithIdleTimeout(50*time.Millisecond))
+
+			client, err := Dial("tcp", listener.Addr().String(), WithKeepalive(10*time.Millisecond, c.timeout))
+			assert.Nil(t, err)
+			defer client.Close()
+
+			// 心跳使连接在服务端空闲超时之后仍然可用
+			time.Sleep(150 * time.Millisecond)
+			assert.False(t, client.Closed())
+			reply := &pb.ArithResponse{}
+			assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+			assert.Equal(t, 3.0, reply.C)
+
+			// 心跳请求不会交给服务端处理
+			recorder.mutex.Lock()
+			defer recorder.mutex.Unlock()
+			assert.Len(t, recorder.starts, 1)
+			assert.Empty(t, recorder.errs)
+		})
+	}
+}
+
+// startSilentServer start a peer which reads requests but never answers them
+func startSilentServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}()
+	return listener
+}
+
+// TestKeepalive_MissedPong .
+func TestKeepalive_MissedPong(t *testing.T) {
+	listener := startSilentServer(t)
+	defer listener.Close()
+
+	client, err := Dial("tcp", listener.Addr().String(), WithKeepalive(10*time.Millisecond, 30*time.Millisecond))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	assert.Eventually(t, client.Closed, time.Second, 10*time.Millisecond)
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.Equal(t, rpc.ErrShutdown, err)
+}
@@ -0,0 +1,183 @@
+package tiny_rpc
+
+import (
+	"io"
+	"net/rpc"
+	"sync/atomic"
+	"time"
+	"tiny_rpc/codec"
+
+	"golang.org/x/time/rate"
+)
+
+// WithMaxPendingRequests fail client calls with codec.TooManyPendingError while n calls await their response
+func WithMaxPendingRequests(n int) Option {
+	return func(o *options) {
+		o.maxPendingRequests = n
+	}
+}
+
+// WithMaxRequestSize fail server calls whose request body is larger than size bytes with
+// codec.MessageTooLargeError, the body is skipped without being read into memory
+func WithMaxRequestSize(size uint32) Option {
+	return func(o *options) {
+		o.maxRequestSize = size
+	}
+}
+
+// WithMaxResponseSize fail client calls whose response body is larger than size bytes with
+// codec.MessageTooLargeError, the body is skipped without being read into memory
+func WithMaxResponseSize(size uint32) Option {
+	return func(o *options) {
+		o.maxResponseSize = size
+	}
+}
+
+// WithMaxConns limit the connections served at the same time, requests on the connections
+// beyond the limit are answered with ServerBusyError and the connections closed
+func WithMaxConns(n int) Option {
+	return func(o *options) {
+		o.maxConns = n
+	}
+}
+
+// WithServeWorkers serve at most n connections accepted by Serve and ServeContext at the same time,
+// further connections wait until a served connection closes instead of spawning more goroutines.
+// Use WithMaxConns to reject them instead
+func WithServeWorkers(n int) Option {
+	return func(o *options) {
+		o.serveWorkers = n
+	}
+}
+
+// WithMaxConcurrentRequests limit the calls running at the same time across all connections,
+// calls beyond the limit are answered with ServerBusyError
+func WithMaxConcurrentRequests(n int) Option {
+	return func(o *options) {
+		o.maxConcurrentRequests = n
+	}
+}
+
+// WithRequestTimeout limit the time a call may run, the context handed to interceptors and to
+// methods taking one is done after timeout. A call still running then is answered with
+// DeadlineExceededError once it returns, methods must watch the context to stop early
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = timeout
+	}
+}
+
+// rateLimit the rate and burst of a method limiter
+type rateLimit struct {
+	limit rate.Limit
+	burst int
+}
+
+// WithMethodRateLimit limit the calls to the method, "Service.Method", to limit per second with
+// bursts of burst calls. Calls beyond the limit are answered with RateLimitedError without
+// decoding their arguments, calls whose body can not be read do not use up the limit. The other
+// methods are not affected
+func WithMethodRateLimit(method string, limit rate.Limit, burst int) Option {
+	return func(o *options) {
+		if o.methodRateLimits == nil {
+			o.methodRateLimits = make(map[string]rateLimit)
+		}
+		o.methodRateLimits[method] = rateLimit{limit: limit, burst: burst}
+	}
+}
+
+// newLimiters create the limiters of a server, each server gets its own
+func newLimiters(limits map[string]rateLimit) map[string]*rate.Limiter {
+	if len(limits) == 0 {
+		return nil
+	}
+	limiters := make(map[string]*rate.Limiter, len(limits))
+	for method, l := range limits {
+		limiters[method] = rate.NewLimiter(l.limit, l.burst)
+	}
+	return limiters
+}
+
+// reserve take a token for a call to method, false if the call exceeds its rate limit. The
+// returned cancel gives the token back when the call is not dispatched
+func (s *Server) reserve(method string) (cancel func(), ok bool) {
+	limiter, ok := s.limiters[method]
+	if !ok {
+		return func() {}, true
+	}
+	r := limiter.Reserve()
+	if !r.OK() || r.Delay() > 0 {
+		r.Cancel()
+		return nil, false
+	}
+	return r.Cancel, true
+}
+
+// acquireConn count a new connection, false if the connection limit is reached
+func (s *Server) acquireConn() bool {
+	n := atomic.AddInt64(&s.conns, 1)
+	return s.options.maxConns <= 0 || n <= int64(s.options.maxConns)
+}
+
+func (s *Server) releaseConn() {
+	atomic.AddInt64(&s.conns, -1)
+}
+
+// acquireWorker take a worker slot for an accepted connection, waiting until one is free.
+// False if the server is closed or stop is closed first
+func (s *Server) acquireWorker(stop <-chan struct{}) bool {
+	if s.workers == nil {
+		return true
+	}
+	select {
+	case s.workers <- struct{}{}:
+		return true
+	case <-s.done:
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+func (s *Server) releaseWorker() {
+	if s.workers != nil {
+		<-s.workers
+	}
+}
+
+// acquireRequest take a request slot without blocking, false if the server is saturated
+func (s *Server) acquireRequest() bool {
+	if s.requests == nil {
+		return true
+	}
+	select {
+	case s.requests <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseRequest() {
+	if s.requests != nil {
+		<-s.requests
+	}
+}
+
+// rejectConn answer the first request of a connection beyond the limit with ServerBusyError
+func (s *Server) rejectConn(conn io.ReadWriteCloser) error {
+	c := codec.NewServerCodec(conn, s.Serializer, s.options.codecOptions()...)
+	defer c.Close()
+	req := &rpc.Request{}
+	if err := c.ReadRequestHeader(req); err != nil {
+		return err
+	}
+	if err := c.ReadRequestBody(nil); err != nil {
+		return err
+	}
+	resp := &rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq, Error: ServerBusyError.Error()}
+	if err := c.WriteResponse(resp, nil); err != nil {
+		return err
+	}
+	return ServerBusyError
+}
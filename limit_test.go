@@ -0,0 +1,265 @@
+package tiny_rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tiny_rpc/codec"
+	"tiny_rpc/serializer"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+// BlockService blocks its callers until released
+type BlockService struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newBlockService() *BlockService {
+	return &BlockService{entered: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+// Wait .
+func (b *BlockService) Wait(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	b.entered <- struct{}{}
+	<-b.release
+	reply.C = args.A
+	return nil
+}
+
+// WaitContext wait like Wait unless ctx is done first
+func (b *BlockService) WaitContext(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	b.entered <- struct{}{}
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	reply.C = args.A
+	return nil
+}
+
+// TestServer_MaxConcurrentRequests .
+func TestServer_MaxConcurrentRequests(t *testing.T) {
+	block := newBlockService()
+	server, listener := startServer(t, WithMaxConcurrentRequests(1))
+	assert.Nil(t, server.Register(block))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	done := client.AsyncCall("BlockService.Wait", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	<-block.entered
+
+	// 唯一的请求槽位被占用
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.Equal(t, ServerBusyError, err)
+
+	close(block.release)
+	call := <-done
+	assert.Nil(t, call.Error)
+
+	// 请求完成后释放槽位
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// TestServer_MaxConns .
+func TestServer_MaxConns(t *testing.T) {
+	server, listener := startServer(t, WithMaxConns(1))
+
+	first, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, first.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+
+	second, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	err = second.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply)
+	assert.Equal(t, ServerBusyError, err)
+	second.Close()
+
+	// 第一个连接关闭后可以建立新的连接
+	assert.Nil(t, first.Close())
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&server.conns) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	third, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer third.Close()
+	assert.Nil(t, third.Call("ArithService.Add", &pb.ArithRequest{A: 2, B: 2}, reply))
+	assert.Equal(t, 4.0, reply.C)
+}
+
+// TestServer_ServeWorkers .
+func TestServer_ServeWorkers(t *testing.T) {
+	const workers, n = 2, 6
+	events := make(chan ConnEvent, 4*n)
+	server, listener := startServer(t, WithServeWorkers(workers), WithConnectionEvents(events))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, err := Dial("tcp", listener.Addr().String())
+			assert.Nil(t, err)
+			defer client.Close()
+			reply := &pb.ArithResponse{}
+			assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 1}, reply))
+			assert.Equal(t, float64(i+1), reply.C)
+		}(i)
+	}
+	// 所有连接都得到服务，同时服务的连接不超过工作槽位数
+	open, maxOpen := 0, 0
+	for closed := 0; closed < n; {
+		switch e := nextEvent(t, events); e.Type {
+		case ConnectionOpened:
+			if open++; open > maxOpen {
+				maxOpen = open
+			}
+		case ConnectionClosed:
+			open--
+			closed++
+		}
+	}
+	wg.Wait()
+	assert.Equal(t, workers, maxOpen)
+
+	// 排队的连接不占用协程
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+	}
+	opened := 0
+	for timeout := time.After(100 * time.Millisecond); ; {
+		select {
+		case e := <-events:
+			if e.Type == ConnectionOpened {
+				opened++
+			}
+			continue
+		case <-timeout:
+		}
+		break
+	}
+	assert.Equal(t, workers, opened)
+	assert.Less(t, runtime.NumGoroutine()-before, 10*workers)
+}
+
+// TestClient_MaxPendingRequests .
+func TestClient_MaxPendingRequests(t *testing.T) {
+	listener := startSilentServer(t)
+	defer listener.Close()
+	client, err := Dial("tcp", listener.Addr().String(), WithMaxPendingRequests(3))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		client.AsyncCall("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	}
+	// 对端不应答，超过上限的调用立即失败
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.Equal(t, codec.TooManyPendingError, err)
+}
+
+// TestServer_MaxRequestSize .
+func TestServer_MaxRequestSize(t *testing.T) {
+	_, listener := startServer(t, WithMaxRequestSize(16))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.True(t, errors.Is(err, codec.MessageTooLargeError))
+
+	// 连接仍可继续使用
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1}, reply))
+	assert.Equal(t, 1.0, reply.C)
+}
+
+// TestServer_MethodRateLimit .
+func TestServer_MethodRateLimit(t *testing.T) {
+	_, listener := startServer(t, WithMethodRateLimit("ArithService.Mul", rate.Every(time.Hour), 2))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// 突发额度内的调用成功，之后的调用被拒绝
+	for i := 0; i < 2; i++ {
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.Call("ArithService.Mul", &pb.ArithRequest{A: 2, B: 3}, reply))
+		assert.Equal(t, 6.0, reply.C)
+	}
+	err = client.Call("ArithService.Mul", &pb.ArithRequest{A: 2, B: 3}, &pb.ArithResponse{})
+	assert.Equal(t, RateLimitedError, err)
+
+	// 其他方法不受影响
+	for i := 0; i < 5; i++ {
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 2, B: 3}, reply))
+		assert.Equal(t, 5.0, reply.C)
+	}
+}
+
+// countingSerializer counts the bodies it unmarshals
+type countingSerializer struct {
+	serializer.Serializer
+	unmarshals int64
+}
+
+func (c *countingSerializer) Unmarshal(data []byte, message interface{}) error {
+	atomic.AddInt64(&c.unmarshals, 1)
+	return c.Serializer.Unmarshal(data, message)
+}
+
+// TestServer_MethodRateLimitNoDecode .
+func TestServer_MethodRateLimitNoDecode(t *testing.T) {
+	s := &countingSerializer{Serializer: serializer.Proto}
+	registry := codec.DefaultRegistry().RegisterSerializer(serializer.ProtoType, s)
+	_, listener := startServer(t, WithRegistry(registry), WithMethodRateLimit("ArithService.Mul", rate.Every(time.Hour), 1))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	assert.Nil(t, client.Call("ArithService.Mul", &pb.ArithRequest{A: 2, B: 3}, &pb.ArithResponse{}))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.unmarshals))
+	// 被拒绝的调用不解码参数
+	for i := 0; i < 3; i++ {
+		err = client.Call("ArithService.Mul", &pb.ArithRequest{A: 2, B: 3}, &pb.ArithResponse{})
+		assert.Equal(t, RateLimitedError, err)
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.unmarshals))
+}
+
+// TestServer_MethodRateLimitRefill .
+func TestServer_MethodRateLimitRefill(t *testing.T) {
+	_, listener := startServer(t, WithMethodRateLimit("ArithService.Add", rate.Every(50*time.Millisecond), 1))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{}))
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.Equal(t, RateLimitedError, err)
+
+	// 令牌补充后调用恢复
+	assert.Eventually(t, func() bool {
+		return client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{}) == nil
+	}, time.Second, 20*time.Millisecond)
+}
@@ -0,0 +1,34 @@
+package tiny_rpc
+
+import "log"
+
+// Logger receives the log messages of the server
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// WithLogger set the logger of the server, the standard log package is used by default
+// and debug messages are dropped
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// stdLogger a Logger writing to the standard log package
+type stdLogger struct{}
+
+// Debugf .
+func (stdLogger) Debugf(format string, args ...interface{}) {}
+
+// Infof .
+func (stdLogger) Infof(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// Errorf .
+func (stdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
@@ -0,0 +1,143 @@
+package tiny_rpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLogger a Logger recording the messages with their level
+type captureLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (l *captureLogger) logf(level, format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.messages = append(l.messages, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *captureLogger) Debugf(format string, args ...interface{}) {
+	l.logf("debug", format, args...)
+}
+
+func (l *captureLogger) Infof(format string, args ...interface{}) {
+	l.logf("info", format, args...)
+}
+
+func (l *captureLogger) Errorf(format string, args ...interface{}) {
+	l.logf("error", format, args...)
+}
+
+// contains report whether a message starting with prefix was logged
+func (l *captureLogger) contains(prefix string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, message := range l.messages {
+		if strings.HasPrefix(message, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// failingListener a listener whose first Accept fails with err
+type failingListener struct {
+	net.Listener
+	once sync.Once
+	err  error
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	var err error
+	l.once.Do(func() {
+		err = l.err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l.Listener.Accept()
+}
+
+// TestServer_Logger .
+func TestServer_Logger(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	logger := &captureLogger{}
+	server := NewServer(WithLogger(logger))
+	go server.Serve(&failingListener{Listener: listener, err: errors.New("too many open files")})
+
+	assert.Eventually(t, func() bool {
+		return logger.contains("info tinyrpc started on: " + listener.Addr().String())
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return logger.contains("error tinyrpc: accept: too many open files")
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestServer_LoggerServerName .
+func TestServer_LoggerServerName(t *testing.T) {
+	logger := &captureLogger{}
+	recorder := &statsRecorder{}
+	_, listener := startServer(t, WithLogger(logger), WithStatsHandler(recorder), WithServerName("orders"))
+	assert.Eventually(t, func() bool {
+		return logger.contains("info tinyrpc[orders] started on: " + listener.Addr().String())
+	}, time.Second, 10*time.Millisecond)
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Nil(t, client.Close())
+	// 连接日志同样带有服务名
+	assert.Eventually(t, func() bool {
+		return logger.contains("debug tinyrpc[orders]: connection from ")
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		return len(recorder.ends) == 1
+	}, time.Second, 10*time.Millisecond)
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	assert.Equal(t, "orders", recorder.starts[0].Server)
+	assert.Equal(t, "orders", recorder.ends[0].Server)
+}
+
+// TestServer_LoggerClosedConnection .
+func TestServer_LoggerClosedConnection(t *testing.T) {
+	logger := &captureLogger{}
+	events := make(chan ConnEvent, 8)
+	_, listener := startServer(t, WithLogger(logger), WithConnectionEvents(events))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	// 空闲时正常断开连接
+	assert.Nil(t, client.Close())
+	for e := nextEvent(t, events); e.Type != ConnectionClosed; e = nextEvent(t, events) {
+	}
+
+	assert.True(t, logger.contains("debug tinyrpc: connection from "))
+	assert.False(t, logger.contains("error "))
+
+	// 请求中途断开记录为错误
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	_, err = conn.Write([]byte{0x10, 0x01})
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Close())
+	for e := nextEvent(t, events); e.Type != ConnectionClosed; e = nextEvent(t, events) {
+	}
+	assert.True(t, logger.contains("error tinyrpc: connection from "))
+}
@@ -0,0 +1,6 @@
+//go:build !race
+
+package tiny_rpc
+
+// raceEnabled reports whether the tests run with the race detector, which makes sync.Pool drop values
+const raceEnabled = false
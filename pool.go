@@ -0,0 +1,92 @@
+package tiny_rpc
+
+import "sync"
+
+// ClientPool keeps up to size connections to one address and lends them to callers,
+// connections found shut down are dropped and dialed again
+type ClientPool struct {
+	network string
+	address string
+	opts    []Option
+	tokens  chan struct{} // one token per connection lent out
+	done    chan struct{} // closed by Close to release the waiting callers
+
+	mutex  sync.Mutex // protects idle, closed
+	idle   []*Client
+	closed bool
+}
+
+// NewClientPool create a pool of at most size connections dialed with Dial(network, address, opts...)
+func NewClientPool(network, address string, size int, opts ...Option) *ClientPool {
+	return &ClientPool{
+		network: network,
+		address: address,
+		opts:    opts,
+		tokens:  make(chan struct{}, size),
+		done:    make(chan struct{}),
+	}
+}
+
+// Get take an idle client or dial a new one, it blocks while all size clients are lent out
+func (p *ClientPool) Get() (*Client, error) {
+	select {
+	case p.tokens <- struct{}{}:
+	case <-p.done:
+		return nil, PoolClosedError
+	}
+
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		<-p.tokens
+		return nil, PoolClosedError
+	}
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if !c.Closed() {
+			p.mutex.Unlock()
+			return c, nil
+		}
+		// 空闲期间连接已断开，丢弃后继续查找
+		c.Close()
+	}
+	p.mutex.Unlock()
+
+	c, err := Dial(p.network, p.address, p.opts...)
+	if err != nil {
+		<-p.tokens
+		return nil, err
+	}
+	return c, nil
+}
+
+// Put return a client taken by Get, a client whose connection is shut down is closed
+// and a new connection is dialed by a later Get
+func (p *ClientPool) Put(c *Client) {
+	p.mutex.Lock()
+	if p.closed || c.Closed() {
+		p.mutex.Unlock()
+		c.Close()
+	} else {
+		p.idle = append(p.idle, c)
+		p.mutex.Unlock()
+	}
+	<-p.tokens
+}
+
+// Close close the idle clients, clients lent out are closed when they are put back
+func (p *ClientPool) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return PoolClosedError
+	}
+	p.closed = true
+	close(p.done)
+	for _, c := range p.idle {
+		c.Close()
+	}
+	p.idle = nil
+	return nil
+}
@@ -0,0 +1,92 @@
+package tiny_rpc
+
+import (
+	"net/rpc"
+	"testing"
+	"time"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClientPool .
+func TestClientPool(t *testing.T) {
+	_, listener := startServer(t)
+	pool := NewClientPool("tcp", listener.Addr().String(), 2)
+	defer pool.Close()
+
+	c1, err := pool.Get()
+	assert.Nil(t, err)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, c1.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+	pool.Put(c1)
+
+	// 归还的连接被再次借出
+	c2, err := pool.Get()
+	assert.Nil(t, err)
+	assert.Same(t, c1, c2)
+	pool.Put(c2)
+}
+
+// TestClientPool_MaxSize .
+func TestClientPool_MaxSize(t *testing.T) {
+	_, listener := startServer(t)
+	pool := NewClientPool("tcp", listener.Addr().String(), 2)
+
+	c1, err := pool.Get()
+	assert.Nil(t, err)
+	c2, err := pool.Get()
+	assert.Nil(t, err)
+	assert.NotSame(t, c1, c2)
+
+	got := make(chan *Client)
+	go func() {
+		c, _ := pool.Get()
+		got <- c
+	}()
+	select {
+	case <-got:
+		t.Fatal("Get returned while every client was lent out")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pool.Put(c1)
+	select {
+	case c := <-got:
+		assert.Same(t, c1, c)
+		pool.Put(c)
+	case <-time.After(time.Second):
+		t.Fatal("Get did not return after a client was put back")
+	}
+	pool.Put(c2)
+
+	// 关闭后不再借出，等待中的调用随之返回
+	assert.Nil(t, pool.Close())
+	_, err = pool.Get()
+	assert.Equal(t, PoolClosedError, err)
+}
+
+// TestClientPool_ReplaceShutdown .
+func TestClientPool_ReplaceShutdown(t *testing.T) {
+	// 服务端关闭空闲连接
+	_, listener := startServer(t, WithIdleTimeout(50*time.Millisecond))
+	pool := NewClientPool("tcp", listener.Addr().String(), 1)
+	defer pool.Close()
+
+	c1, err := pool.Get()
+	assert.Nil(t, err)
+	assert.Nil(t, c1.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{}))
+	pool.Put(c1)
+
+	assert.Eventually(t, c1.Closed, time.Second, 10*time.Millisecond)
+	assert.Equal(t, rpc.ErrShutdown, c1.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{}))
+
+	c2, err := pool.Get()
+	assert.Nil(t, err)
+	assert.NotSame(t, c1, c2)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, c2.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+	pool.Put(c2)
+}
@@ -0,0 +1,138 @@
+package tiny_rpc
+
+import (
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReconnectBackoff    = 100 * time.Millisecond
+	defaultReconnectMaxBackoff = 5 * time.Second
+	defaultReconnectAttempts   = 5
+)
+
+// WithReconnectBackoff set the wait between the redials of a ReconnectingClient, the first
+// redial is immediate and the wait doubles after each failed attempt up to max
+func WithReconnectBackoff(initial, max time.Duration) Option {
+	return func(o *options) {
+		o.reconnectBackoff = initial
+		o.reconnectMaxBackoff = max
+	}
+}
+
+// WithReconnectAttempts set how many times a ReconnectingClient dials before failing the call
+func WithReconnectAttempts(n int) Option {
+	return func(o *options) {
+		o.reconnectAttempts = n
+	}
+}
+
+// ReconnectingClient a client which dials its address again once the connection is shut down.
+// Only calls failing with rpc.ErrShutdown are retried, since they never reached the server
+type ReconnectingClient struct {
+	network string
+	address string
+	opts    []Option
+	options options
+
+	redial sync.Mutex // serializes the redials
+	mutex  sync.Mutex // protects client and closed
+	client *Client
+	closed bool
+	done   chan struct{} // closed by Close, stops the backoff of a redial
+}
+
+// DialReconnecting connect to the address and return a client reconnecting with the same options
+func DialReconnecting(network, address string, opts ...Option) (*ReconnectingClient, error) {
+	client, err := Dial(network, address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectingClient{
+		network: network,
+		address: address,
+		opts:    opts,
+		options: newOptions(opts),
+		client:  client,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Call synchronously calls the rpc function, reconnecting first if the connection is shut down
+func (r *ReconnectingClient) Call(serviceMethod string, args interface{}, reply interface{}, opts ...CallOption) error {
+	r.mutex.Lock()
+	client, closed := r.client, r.closed
+	r.mutex.Unlock()
+	if closed {
+		return rpc.ErrShutdown
+	}
+
+	err := client.Call(serviceMethod, args, reply, opts...)
+	if err != rpc.ErrShutdown {
+		return err
+	}
+	if client, err = r.reconnect(client); err != nil {
+		return err
+	}
+	return client.Call(serviceMethod, args, reply, opts...)
+}
+
+// reconnect replace the shut down client, callers failing together share a single redial.
+// Close interrupts the backoff, the client is not locked while redialing
+func (r *ReconnectingClient) reconnect(broken *Client) (*Client, error) {
+	r.redial.Lock()
+	defer r.redial.Unlock()
+	r.mutex.Lock()
+	client, closed := r.client, r.closed
+	r.mutex.Unlock()
+	if closed {
+		return nil, rpc.ErrShutdown
+	}
+	// 其他调用已完成重连
+	if client != broken {
+		return client, nil
+	}
+	broken.Close()
+
+	backoff := r.options.reconnectBackoff
+	err := rpc.ErrShutdown
+	for attempt := 0; attempt < r.options.reconnectAttempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-r.done:
+				timer.Stop()
+				return nil, rpc.ErrShutdown
+			}
+			if backoff *= 2; backoff > r.options.reconnectMaxBackoff {
+				backoff = r.options.reconnectMaxBackoff
+			}
+		}
+		if client, err = Dial(r.network, r.address, r.opts...); err == nil {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			// 拨号期间已关闭，新连接不再保留
+			if r.closed {
+				client.Close()
+				return nil, rpc.ErrShutdown
+			}
+			r.client = client
+			return client, nil
+		}
+	}
+	return nil, err
+}
+
+// Close close the connection, later calls fail with rpc.ErrShutdown
+func (r *ReconnectingClient) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return rpc.ErrShutdown
+	}
+	r.closed = true
+	close(r.done)
+	return r.client.Close()
+}
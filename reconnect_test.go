@@ -0,0 +1,170 @@
+package tiny_rpc
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// restartableServer serves ArithService on a fixed address and can be stopped and started again
+type restartableServer struct {
+	t        *testing.T
+	server   *Server
+	addr     string
+	accepted int64
+
+	mutex    sync.Mutex
+	listener net.Listener
+	conns    []net.Conn
+}
+
+func newRestartableServer(t *testing.T) *restartableServer {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	s := &restartableServer{t: t, server: server, addr: "127.0.0.1:0"}
+	s.start()
+	s.addr = s.listener.Addr().String()
+	return s
+}
+
+func (s *restartableServer) start() {
+	listener, err := net.Listen("tcp", s.addr)
+	assert.Nil(s.t, err)
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&s.accepted, 1)
+			s.mutex.Lock()
+			s.conns = append(s.conns, conn)
+			s.mutex.Unlock()
+			go s.server.ServeConn(conn)
+		}
+	}()
+}
+
+// stop close the listener and every connection
+func (s *restartableServer) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listener.Close()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// TestReconnectingClient .
+func TestReconnectingClient(t *testing.T) {
+	server := newRestartableServer(t)
+	defer server.stop()
+
+	client, err := DialReconnecting("tcp", server.addr,
+		WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond), WithReconnectAttempts(50))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+
+	server.stop()
+	assert.Eventually(t, func() bool {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		return client.client.Closed()
+	}, time.Second, 10*time.Millisecond)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.start()
+	}()
+
+	// 并发调用共享同一次重连
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply := &pb.ArithResponse{}
+			assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 1}, reply))
+			assert.Equal(t, float64(i+1), reply.C)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(2), atomic.LoadInt64(&server.accepted))
+}
+
+// TestReconnectingClient_Attempts .
+func TestReconnectingClient_Attempts(t *testing.T) {
+	server := newRestartableServer(t)
+
+	client, err := DialReconnecting("tcp", server.addr,
+		WithReconnectBackoff(time.Millisecond, 2*time.Millisecond), WithReconnectAttempts(3))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	server.stop()
+	assert.Eventually(t, func() bool {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		return client.client.Closed()
+	}, time.Second, 10*time.Millisecond)
+
+	// 重试次数用尽后返回拨号错误
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.NotNil(t, err)
+	_, ok := err.(*net.OpError)
+	assert.True(t, ok)
+}
+
+// TestReconnectingClient_CloseDuringBackoff .
+func TestReconnectingClient_CloseDuringBackoff(t *testing.T) {
+	server := newRestartableServer(t)
+
+	client, err := DialReconnecting("tcp", server.addr,
+		WithReconnectBackoff(time.Hour, time.Hour), WithReconnectAttempts(3))
+	assert.Nil(t, err)
+
+	server.stop()
+	assert.Eventually(t, func() bool {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		return client.client.Closed()
+	}, time.Second, 10*time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	}()
+	// 等待第一次重连失败，进入退避
+	time.Sleep(50 * time.Millisecond)
+
+	// 退避期间关闭不被阻塞，并中断重连
+	closed := make(chan struct{})
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by the redial")
+	}
+	select {
+	case err = <-done:
+		assert.Equal(t, rpc.ErrShutdown, err)
+	case <-time.After(time.Second):
+		t.Fatal("redial not interrupted by Close")
+	}
+}
@@ -0,0 +1,74 @@
+package tiny_rpc
+
+import (
+	"errors"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// ReflectionServiceName the name the built-in reflection service is registered under
+const ReflectionServiceName = "Reflection"
+
+// WithReflection register the built-in Reflection service, Reflection.List describes the
+// services of the server so that clients can discover the methods without compiled stubs
+func WithReflection() Option {
+	return func(o *options) {
+		o.reflection = true
+	}
+}
+
+// reflectionService answers Reflection.List, the services are described with the descriptor
+// messages of protobuf so that it can be called with the proto and the json serializer alike
+type reflectionService struct {
+	server *Server
+}
+
+// List describe the service args names, or every registered service when the name is empty
+func (r *reflectionService) List(args *wrapperspb.StringValue, reply *descriptorpb.FileDescriptorProto) error {
+	name := args.GetValue()
+	for _, info := range r.server.Services() {
+		if name != "" && info.Name != name {
+			continue
+		}
+		reply.Service = append(reply.Service, describeService(info))
+	}
+	if name != "" && len(reply.Service) == 0 {
+		return errors.New("rpc: can't find service " + name)
+	}
+	return nil
+}
+
+func describeService(info ServiceInfo) *descriptorpb.ServiceDescriptorProto {
+	svc := &descriptorpb.ServiceDescriptorProto{Name: proto.String(info.Name)}
+	for _, m := range info.Methods {
+		svc.Method = append(svc.Method, &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(m.Name),
+			InputType:       proto.String(typeName(m.ArgType)),
+			OutputType:      proto.String(typeName(m.ReplyType)),
+			ServerStreaming: proto.Bool(m.Stream),
+		})
+	}
+	return svc
+}
+
+// typeName the full name of protobuf messages, the go type of any other value
+func typeName(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		if m, ok := reflect.New(t.Elem()).Interface().(proto.Message); ok {
+			return string(m.ProtoReflect().Descriptor().FullName())
+		}
+	}
+	return t.String()
+}
+
+// ListServices call Reflection.List on the server, service is empty to describe every service
+func (c *Client) ListServices(service string) ([]*descriptorpb.ServiceDescriptorProto, error) {
+	reply := &descriptorpb.FileDescriptorProto{}
+	if err := c.Call(ReflectionServiceName+".List", wrapperspb.String(service), reply); err != nil {
+		return nil, err
+	}
+	return reply.Service, nil
+}
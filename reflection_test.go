@@ -0,0 +1,76 @@
+package tiny_rpc
+
+import (
+	"testing"
+	"tiny_rpc/serializer"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestServer_Reflection .
+func TestServer_Reflection(t *testing.T) {
+	cases := []struct {
+		name       string
+		serializer serializer.Serializer
+	}{
+		{"test-1", serializer.Proto},
+		{"test-2", serializer.JSON},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server, listener := startServer(t, WithReflection(), WithSerializer(c.serializer))
+			assert.Nil(t, server.Register(new(EchoService)))
+			client, err := Dial("tcp", listener.Addr().String(), WithSerializer(c.serializer))
+			assert.Nil(t, err)
+			defer client.Close()
+
+			services, err := client.ListServices("")
+			assert.Nil(t, err)
+			names := make([]string, 0, len(services))
+			for _, svc := range services {
+				names = append(names, svc.GetName())
+			}
+			assert.Equal(t, []string{"ArithService", "EchoService", ReflectionServiceName}, names)
+
+			arith := services[0]
+			assert.Len(t, arith.Method, 4)
+			assert.Equal(t, "Add", arith.Method[0].GetName())
+			assert.Equal(t, "message.ArithRequest", arith.Method[0].GetInputType())
+			assert.Equal(t, "message.ArithResponse", arith.Method[0].GetOutputType())
+			assert.False(t, arith.Method[0].GetServerStreaming())
+
+			echo := services[1]
+			assert.Len(t, echo.Method, 1)
+			assert.Equal(t, "Echo", echo.Method[0].GetName())
+			assert.Equal(t, "*string", echo.Method[0].GetInputType())
+			assert.Equal(t, "*string", echo.Method[0].GetOutputType())
+		})
+	}
+}
+
+// TestServer_ReflectionService .
+func TestServer_ReflectionService(t *testing.T) {
+	_, listener := startServer(t, WithReflection())
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	services, err := client.ListServices("ArithService")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+	assert.Equal(t, "ArithService", services[0].GetName())
+
+	_, err = client.ListServices("NoService")
+	assert.NotNil(t, err)
+}
+
+// TestServer_ReflectionDisabled .
+func TestServer_ReflectionDisabled(t *testing.T) {
+	_, listener := startServer(t)
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	_, err = client.ListServices("")
+	assert.NotNil(t, err)
+}
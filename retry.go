@@ -0,0 +1,71 @@
+package tiny_rpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+// RetryPolicy how CallWithRetry retries a failed call. Retry idempotent methods only, a call
+// failing on a broken connection may have run on the server already
+type RetryPolicy struct {
+	MaxAttempts int           // calls made at most, the first included
+	Backoff     time.Duration // wait before the first retry, it doubles after each retry
+	MaxBackoff  time.Duration // upper bound of the wait, unbounded if zero
+	// Retryable reports whether the call failing with err is retried, IsRetryable if nil
+	Retryable func(err error) bool
+}
+
+// IsRetryable report whether err is transient: network errors, a shut down or broken
+// connection and a busy server. Errors returned by the method are not retryable
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, ServerBusyError) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// do run call until it succeeds, fails with an error which is not retryable or runs out of attempts
+func (p RetryPolicy) do(call func() error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	backoff := p.Backoff
+	var err error
+	for attempt := 0; attempt < p.MaxAttempts || attempt == 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			if backoff *= 2; p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+		}
+		if err = call(); err == nil || !retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// CallWithRetry call the rpc function and retry it as the policy allows, the connection is not
+// redialed, use a ReconnectingClient for that
+func (c *Client) CallWithRetry(serviceMethod string, args interface{}, reply interface{}, policy RetryPolicy, opts ...CallOption) error {
+	return policy.do(func() error {
+		return c.Call(serviceMethod, args, reply, opts...)
+	})
+}
+
+// CallWithRetry call the rpc function and retry it as the policy allows, the connection is
+// redialed before the retry once it is shut down
+func (r *ReconnectingClient) CallWithRetry(serviceMethod string, args interface{}, reply interface{}, policy RetryPolicy, opts ...CallOption) error {
+	return policy.do(func() error {
+		return r.Call(serviceMethod, args, reply, opts...)
+	})
+}
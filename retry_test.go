@@ -0,0 +1,129 @@
+package tiny_rpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// FailService counts its calls and always fails
+type FailService struct {
+	calls int64
+}
+
+// Fail .
+func (f *FailService) Fail(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	atomic.AddInt64(&f.calls, 1)
+	return errors.New("division by zero")
+}
+
+// TestIsRetryable .
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{"test-1", nil, false},
+		{"test-2", rpc.ErrShutdown, true},
+		{"test-3", io.ErrUnexpectedEOF, true},
+		{"test-4", ServerBusyError, true},
+		{"test-5", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"test-6", fmt.Errorf("call: %w", rpc.ErrShutdown), true},
+		{"test-7", rpc.ServerError("division by zero"), false},
+		{"test-8", errors.New("division by zero"), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expect, IsRetryable(c.err))
+		})
+	}
+}
+
+// TestClient_CallWithRetry .
+func TestClient_CallWithRetry(t *testing.T) {
+	block := newBlockService()
+	server, listener := startServer(t, WithMaxConcurrentRequests(1))
+	assert.Nil(t, server.Register(block))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	done := client.AsyncCall("BlockService.Wait", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	<-block.entered
+
+	// 第一次调用因服务端繁忙失败，释放槽位后重试成功
+	var (
+		once   sync.Once
+		failed []error
+	)
+	policy := RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     10 * time.Millisecond,
+		Retryable: func(err error) bool {
+			failed = append(failed, err)
+			once.Do(func() {
+				close(block.release)
+				<-done
+			})
+			return IsRetryable(err)
+		},
+	}
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.CallWithRetry("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply, policy))
+	assert.Equal(t, 3.0, reply.C)
+	assert.Equal(t, []error{ServerBusyError}, failed)
+}
+
+// TestClient_CallWithRetryApplicationError .
+func TestClient_CallWithRetryApplicationError(t *testing.T) {
+	fail := new(FailService)
+	server, listener := startServer(t)
+	assert.Nil(t, server.Register(fail))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	policy := RetryPolicy{MaxAttempts: 5, Backoff: time.Second}
+	start := time.Now()
+	err = client.CallWithRetry("FailService.Fail", &pb.ArithRequest{}, &pb.ArithResponse{}, policy)
+	assert.Equal(t, rpc.ServerError("division by zero"), err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&fail.calls))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// TestReconnectingClient_CallWithRetry .
+func TestReconnectingClient_CallWithRetry(t *testing.T) {
+	server := newRestartableServer(t)
+	defer server.stop()
+
+	client, err := DialReconnecting("tcp", server.addr, WithReconnectAttempts(1))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	server.stop()
+	assert.Eventually(t, func() bool {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		return client.client.Closed()
+	}, time.Second, 10*time.Millisecond)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.start()
+	}()
+
+	// 重连失败的调用在服务端恢复后重试成功
+	policy := RetryPolicy{MaxAttempts: 50, Backoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.CallWithRetry("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply, policy))
+	assert.Equal(t, 3.0, reply.C)
+}
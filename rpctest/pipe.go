@@ -0,0 +1,38 @@
+package rpctest
+
+import (
+	"net"
+	"tiny_rpc"
+)
+
+// Pipe a client connected to a server over net.Pipe, for testing services in process
+// without sockets. Register the services on Server, the connection is already served
+type Pipe struct {
+	Server *tiny_rpc.Server
+	Client *tiny_rpc.Client
+	done   chan struct{} // closed once the server stopped serving the connection
+}
+
+// NewPipe create a server and a client with the same options and connect them in memory
+func NewPipe(opts ...tiny_rpc.Option) *Pipe {
+	server := tiny_rpc.NewServer(opts...)
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.ServeConn(serverConn)
+	}()
+	return &Pipe{
+		Server: server,
+		Client: tiny_rpc.NewClient(clientConn, opts...),
+		done:   done,
+	}
+}
+
+// Close close the client and wait until the server answered the calls in flight and
+// stopped serving the connection
+func (p *Pipe) Close() error {
+	err := p.Client.Close()
+	<-p.done
+	return err
+}
@@ -0,0 +1,91 @@
+package rpctest
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"tiny_rpc"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+	jsonpb "tiny_rpc/test.data/json"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// GreeterService a sample service under test
+type GreeterService struct{}
+
+// Greet .
+func (_ *GreeterService) Greet(name *string, reply *string) error {
+	if strings.TrimSpace(*name) == "" {
+		return errors.New("greeter: empty name")
+	}
+	*reply = "hello, " + *name
+	return nil
+}
+
+// TestPipe .
+func TestPipe(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []tiny_rpc.Option
+	}{
+		{"test-1", nil},
+		{"test-2", []tiny_rpc.Option{tiny_rpc.WithCompress(compressor.Gzip), tiny_rpc.WithHandshake()}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			p := NewPipe(c.opts...)
+			assert.Nil(t, p.Server.Register(new(pb.ArithService)))
+
+			reply := &pb.ArithResponse{}
+			assert.Nil(t, p.Client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+			assert.Equal(t, 3.0, reply.C)
+
+			assert.Nil(t, p.Close())
+			// 关闭后服务端与客户端的协程全部退出，Eventually 自身会启动协程，需手动轮询
+			deadline := time.Now().Add(time.Second)
+			for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+		})
+	}
+}
+
+// TestPipe_Service .
+func TestPipe_Service(t *testing.T) {
+	p := NewPipe(tiny_rpc.WithSerializer(serializer.JSON))
+	defer p.Close()
+	assert.Nil(t, p.Server.Register(new(GreeterService)))
+
+	var reply string
+	assert.Nil(t, p.Client.Call("GreeterService.Greet", "tinyrpc", &reply))
+	assert.Equal(t, "hello, tinyrpc", reply)
+
+	err := p.Client.Call("GreeterService.Greet", " ", &reply)
+	assert.EqualError(t, err, "greeter: empty name")
+
+	resp := &jsonpb.Response{}
+	err = p.Client.Call("GreeterService.Missing", &jsonpb.Request{}, resp)
+	assert.NotNil(t, err)
+}
+
+func ExampleNewPipe() {
+	p := NewPipe(tiny_rpc.WithSerializer(serializer.JSON))
+	defer p.Close()
+	p.Server.Register(new(GreeterService))
+
+	var reply string
+	if err := p.Client.Call("GreeterService.Greet", "gopher", &reply); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(reply)
+	// Output: hello, gopher
+}
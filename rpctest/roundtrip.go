@@ -0,0 +1,64 @@
+package rpctest
+
+import (
+	"reflect"
+	"testing"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// AssertRoundTrip run msg through marshal, compress, decompress and unmarshal the same way
+// the codecs do, and assert the decoded message equals msg. msg must be a pointer.
+func AssertRoundTrip(t testing.TB, s serializer.Serializer, c compressor.CompressType, msg interface{}) bool {
+	t.Helper()
+	typ := reflect.TypeOf(msg)
+	if typ == nil || typ.Kind() != reflect.Pointer {
+		t.Errorf("rpctest: message must be a non-nil pointer, got %T", msg)
+		return false
+	}
+	comp, ok := compressor.Get(c)
+	if !ok {
+		t.Errorf("rpctest: compressor %d is not registered", c)
+		return false
+	}
+
+	data, err := s.Marshal(msg)
+	if err != nil {
+		t.Errorf("rpctest: marshal %T: %v", msg, err)
+		return false
+	}
+	zipped, err := comp.Zip(data)
+	if err != nil {
+		t.Errorf("rpctest: zip: %v", err)
+		return false
+	}
+	unzipped, err := comp.Unzip(zipped)
+	if err != nil {
+		t.Errorf("rpctest: unzip: %v", err)
+		return false
+	}
+	decoded := reflect.New(typ.Elem()).Interface()
+	if err = s.Unmarshal(unzipped, decoded); err != nil {
+		t.Errorf("rpctest: unmarshal %T: %v", msg, err)
+		return false
+	}
+
+	if !equal(msg, decoded) {
+		t.Errorf("rpctest: round trip mismatch:\n sent: %+v\n  got: %+v", msg, decoded)
+		return false
+	}
+	return true
+}
+
+// equal compare the messages, protobuf messages are compared with proto.Equal
+// since their internal state differs after a marshal
+func equal(expected, actual interface{}) bool {
+	if e, ok := expected.(proto.Message); ok {
+		if a, ok := actual.(proto.Message); ok {
+			return proto.Equal(e, a)
+		}
+	}
+	return reflect.DeepEqual(expected, actual)
+}
@@ -0,0 +1,60 @@
+package rpctest
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+	jsonpb "tiny_rpc/test.data/json"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder captures the failures reported by the helper
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// lossySerializer drops the B field of json.Request
+type lossySerializer struct{}
+
+func (_ lossySerializer) Marshal(message interface{}) ([]byte, error) {
+	r := *message.(*jsonpb.Request)
+	r.B = 0
+	return json.Marshal(r)
+}
+
+func (_ lossySerializer) Unmarshal(data []byte, message interface{}) error {
+	return json.Unmarshal(data, message)
+}
+
+func TestAssertRoundTrip(t *testing.T) {
+	for c := range compressor.Compressors {
+		AssertRoundTrip(t, serializer.Proto, c, &pb.ArithRequest{A: 1, B: 2})
+		AssertRoundTrip(t, serializer.JSON, c, &jsonpb.Request{A: 1, B: 2})
+	}
+}
+
+func TestAssertRoundTrip_BrokenSerializer(t *testing.T) {
+	r := &recorder{}
+	assert.False(t, AssertRoundTrip(r, lossySerializer{}, compressor.Gzip, &jsonpb.Request{A: 1, B: 2}))
+	assert.Len(t, r.errors, 1)
+	assert.Contains(t, r.errors[0], "round trip mismatch")
+}
+
+func TestAssertRoundTrip_InvalidArgs(t *testing.T) {
+	r := &recorder{}
+	assert.False(t, AssertRoundTrip(r, serializer.Proto, compressor.Raw, pb.ArithRequest{}))
+	assert.False(t, AssertRoundTrip(r, serializer.Proto, compressor.CompressType(100), &pb.ArithRequest{}))
+	assert.False(t, AssertRoundTrip(r, serializer.Proto, compressor.Raw, &jsonpb.Request{}))
+	assert.Len(t, r.errors, 3)
+}
@@ -0,0 +1,23 @@
+package serializer
+
+import "encoding/json"
+
+var JSON = JSONSerializer{}
+
+// JSONSerializer implements the Serializer interface
+type JSONSerializer struct {
+}
+
+func (_ JSONSerializer) Marshal(message any) ([]byte, error) {
+	if message == nil {
+		return []byte{}, nil
+	}
+	return json.Marshal(message)
+}
+
+func (_ JSONSerializer) Unmarshal(data []byte, message any) error {
+	if message == nil {
+		return nil
+	}
+	return json.Unmarshal(data, message)
+}
@@ -0,0 +1,29 @@
+package serializer
+
+import (
+	"testing"
+	"tiny_rpc/test.data/json"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONSerializer(t *testing.T) {
+	data, err := JSON.Marshal(&json.Request{A: 1, B: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":1,"b":2}`, string(data))
+
+	message := &json.Request{}
+	assert.Nil(t, JSON.Unmarshal(data, message))
+	assert.Equal(t, &json.Request{A: 1, B: 2}, message)
+
+	data, err = JSON.Marshal(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{}, data)
+	assert.Nil(t, JSON.Unmarshal(nil, nil))
+}
+
+func TestTypeOf(t *testing.T) {
+	assert.Equal(t, ProtoType, TypeOf(Proto))
+	assert.Equal(t, JSONType, TypeOf(JSONSerializer{}))
+	assert.Equal(t, SerializeType(0), TypeOf(nil))
+}
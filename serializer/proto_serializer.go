@@ -3,6 +3,7 @@ package serializer
 import (
 	"errors"
 	"google.golang.org/protobuf/proto"
+	"reflect"
 )
 
 // NotImplementProtoMessageError refers to param not implemented by proto.Message
@@ -22,11 +23,22 @@ func (_ ProtoSerializer) Marshal(message any) ([]byte, error) {
 
 	var ok bool
 	if body, ok = message.(proto.Message); !ok {
-		return nil, NotImplementProtoMessageError
+		return nil, &SerializerTypeError{Type: reflect.TypeOf(message), Err: NotImplementProtoMessageError}
 	}
 	return proto.Marshal(body)
 }
 
+func (_ ProtoSerializer) MarshalAppend(dst []byte, message any) ([]byte, error) {
+	if message == nil {
+		return dst, nil
+	}
+	body, ok := message.(proto.Message)
+	if !ok {
+		return nil, &SerializerTypeError{Type: reflect.TypeOf(message), Err: NotImplementProtoMessageError}
+	}
+	return proto.MarshalOptions{}.MarshalAppend(dst, body)
+}
+
 func (_ ProtoSerializer) Unmarshal(data []byte, message any) error {
 	var body proto.Message
 	if message == nil {
@@ -35,7 +47,7 @@ func (_ ProtoSerializer) Unmarshal(data []byte, message any) error {
 
 	var ok bool
 	if body, ok = message.(proto.Message); !ok {
-		return NotImplementProtoMessageError
+		return &SerializerTypeError{Type: reflect.TypeOf(message), Err: NotImplementProtoMessageError}
 	}
 	return proto.Unmarshal(data, body)
 }
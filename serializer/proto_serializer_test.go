@@ -6,6 +6,7 @@ package serializer
 import (
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"reflect"
 	"testing"
 	pb "tiny_rpc/test.data/message"
 )
@@ -37,7 +38,8 @@ func TestProtoSerializer_Marshal(t *testing.T) {
 			arg:  test{},
 			expect: expect{
 				data: nil,
-				err:  errors.New("param does not implement proto.Message"),
+				err: &SerializerTypeError{Type: reflect.TypeOf(test{}),
+					Err: errors.New("param does not implement proto.Message")},
 			},
 		},
 		{
@@ -104,3 +106,41 @@ func TestProtoSerializer_Unmarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestProtoSerializer_TypeError(t *testing.T) {
+	var message struct{ A int }
+	assert.NotPanics(t, func() {
+		_, err := Proto.Marshal(message)
+		var te *SerializerTypeError
+		assert.True(t, errors.As(err, &te))
+		assert.True(t, errors.Is(err, NotImplementProtoMessageError))
+		assert.Equal(t, "serializer: param does not implement proto.Message: got struct { A int }", err.Error())
+
+		err = Proto.Unmarshal([]byte{0x8, 0x1}, &message)
+		assert.True(t, errors.Is(err, NotImplementProtoMessageError))
+		assert.Equal(t, "serializer: param does not implement proto.Message: got *struct { A int }", err.Error())
+	})
+}
+
+func TestProtoSerializer_MarshalAppend(t *testing.T) {
+	expect, err := Proto.Marshal(&pb.ArithRequest{A: 1, B: 2})
+	assert.Nil(t, err)
+
+	dst := make([]byte, 0, 64)
+	data, err := Proto.MarshalAppend(dst, &pb.ArithRequest{A: 1, B: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, expect, data)
+	// 容量足够时写入调用方的缓冲区
+	assert.Same(t, &dst[:1][0], &data[0])
+
+	data, err = Proto.MarshalAppend([]byte("prefix"), &pb.ArithRequest{A: 1, B: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte("prefix"), expect...), data)
+
+	data, err = Proto.MarshalAppend(nil, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, data)
+
+	_, err = Proto.MarshalAppend(nil, test{})
+	assert.True(t, errors.Is(err, NotImplementProtoMessageError))
+}
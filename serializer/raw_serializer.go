@@ -0,0 +1,41 @@
+package serializer
+
+import "errors"
+
+// NotBytesError refers to param which is neither a []byte nor a *[]byte
+var NotBytesError = errors.New("param is not a []byte or *[]byte")
+
+var Raw = RawSerializer{}
+
+// RawSerializer implements the Serializer interface for messages which are already encoded,
+// Marshal returns the bytes of a []byte or *[]byte as is and Unmarshal copies them into a *[]byte
+type RawSerializer struct {
+}
+
+func (_ RawSerializer) Marshal(message any) ([]byte, error) {
+	switch body := message.(type) {
+	case nil:
+		return []byte{}, nil
+	case []byte:
+		return body, nil
+	case *[]byte:
+		if body == nil {
+			return []byte{}, nil
+		}
+		return *body, nil
+	}
+	return nil, NotBytesError
+}
+
+func (_ RawSerializer) Unmarshal(data []byte, message any) error {
+	if message == nil {
+		return nil
+	}
+	body, ok := message.(*[]byte)
+	if !ok {
+		return NotBytesError
+	}
+	// data 来自缓冲池，需拷贝
+	*body = append((*body)[:0], data...)
+	return nil
+}
@@ -0,0 +1,39 @@
+package serializer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawSerializer(t *testing.T) {
+	blob := []byte("pre-marshaled")
+	data, err := Raw.Marshal(blob)
+	assert.Nil(t, err)
+	assert.Equal(t, blob, data)
+	data, err = Raw.Marshal(&blob)
+	assert.Nil(t, err)
+	assert.Equal(t, blob, data)
+
+	var message []byte
+	assert.Nil(t, Raw.Unmarshal(data, &message))
+	assert.Equal(t, blob, message)
+	// 反序列化结果不引用输入
+	data[0] = 'P'
+	assert.Equal(t, []byte("pre-marshaled"), message)
+
+	data, err = Raw.Marshal(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{}, data)
+	assert.Nil(t, Raw.Unmarshal(nil, nil))
+	assert.Equal(t, RawType, TypeOf(Raw))
+}
+
+func TestRawSerializer_NotBytes(t *testing.T) {
+	_, err := Raw.Marshal("string")
+	assert.Equal(t, NotBytesError, err)
+	_, err = Raw.Marshal(test{})
+	assert.Equal(t, NotBytesError, err)
+	var message string
+	assert.Equal(t, NotBytesError, Raw.Unmarshal([]byte("data"), &message))
+}
@@ -1,6 +1,79 @@
 package serializer
 
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Serializer marshals rpc messages, Unmarshal must not retain data after it returns
+// since the codecs hand it pooled buffers
 type Serializer interface {
 	Marshal(message interface{}) ([]byte, error)
 	Unmarshal(data []byte, message interface{}) error
 }
+
+// SerializeType type of serializations supported by rpc,
+// zero means the serializer configured on the connection
+type SerializeType uint8
+
+const (
+	ProtoType SerializeType = iota + 1
+	JSONType
+	RawType
+)
+
+var Serializers = map[SerializeType]Serializer{
+	ProtoType: Proto,
+	JSONType:  JSON,
+	RawType:   Raw,
+}
+
+// Appender is implemented by serializers able to marshal into a caller-provided buffer,
+// MarshalAppend appends the encoding of message to dst and returns the extended slice
+type Appender interface {
+	MarshalAppend(dst []byte, message interface{}) ([]byte, error)
+}
+
+// Resettable is implemented by serializers keeping state between calls, such as a reused buffer.
+// Codecs marshal the bodies of each connection with an instance created by New, calling Reset
+// before each body, the bytes returned by Marshal are only used until the next Reset
+type Resettable interface {
+	Serializer
+	New() Resettable
+	Reset()
+}
+
+// TypeOf look up the registered type of serializer s, zero if s is not registered
+func TypeOf(s Serializer) SerializeType {
+	for t, registered := range Serializers {
+		if reflect.TypeOf(registered) == reflect.TypeOf(s) {
+			return t
+		}
+	}
+	return 0
+}
+
+// Types list the registered serialize types in ascending order
+func Types() []SerializeType {
+	types := make([]SerializeType, 0, len(Serializers))
+	for t := range Serializers {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// SerializerTypeError a message of a type the serializer can not handle
+type SerializerTypeError struct {
+	Type reflect.Type // type of the message
+	Err  error        // what the serializer expects, such as NotImplementProtoMessageError
+}
+
+func (e *SerializerTypeError) Error() string {
+	return fmt.Sprintf("serializer: %v: got %s", e.Err, e.Type)
+}
+
+func (e *SerializerTypeError) Unwrap() error {
+	return e.Err
+}
@@ -1,52 +1,690 @@
 package tiny_rpc
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"net/rpc"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"tiny_rpc/codec"
 	"tiny_rpc/serializer"
+
+	"golang.org/x/time/rate"
 )
 
-// Server rpc server based on net/rpc implementation
+// Server rpc server compatible with the net/rpc service conventions
 type Server struct {
-	*rpc.Server
+	serviceMap sync.Map // map[string]*service
 	serializer.Serializer
+	options  options
+	connID   uint64        // last assigned connection id
+	conns    int64         // connections being served
+	requests chan struct{} // semaphore of the running calls, nil if unlimited
+	workers  chan struct{} // semaphore of the connections served by Serve, nil if unlimited
+	done     chan struct{} // closed by Close
+	limiters map[string]*rate.Limiter
+	flights  *flightGroup // calls in flight of the methods of WithSingleflight, nil without them
+	health   *healthService
+
+	mutex     sync.Mutex // protects the fields below
+	closed    bool
+	drained   chan struct{} // closed once no connection is left after Drain, nil until Drain
+	listeners map[net.Listener]struct{}
+	active    map[io.Closer]struct{} // connections being served
 }
 
-// NewServer Create a new rpc server
-func NewServer(opts ...Option) *Server {
-	options := options{
-		serializer: serializer.Proto,
+// WithServerName label the server with name in its log lines and in the stats of its requests,
+// telling apart the servers of a process
+func WithServerName(name string) Option {
+	return func(o *options) {
+		o.serverName = name
+	}
+}
+
+// WithFallbackSerializer make the server decode the request bodies its serializer fails to unmarshal
+// with s, so that clients can move to another serializer gradually. The requests decoded by s are
+// counted in codec.RequestStats.Fallback
+func WithFallbackSerializer(s serializer.Serializer) Option {
+	return func(o *options) {
+		o.fallbackSerializer = s
 	}
+}
 
-	for _, option := range opts {
-		option(&options)
+// label prefix the log lines of the server with, it carries the name of the server if any
+func (s *Server) label() string {
+	if s.options.serverName == "" {
+		return "tinyrpc"
 	}
+	return "tinyrpc[" + s.options.serverName + "]"
+}
 
-	return &Server{
-		Server:     &rpc.Server{},
+// NewServer Create a new rpc server
+func NewServer(opts ...Option) *Server {
+	options := newOptions(opts)
+	s := &Server{
 		Serializer: options.serializer,
+		options:    options,
+		done:       make(chan struct{}),
+	}
+	if options.maxConcurrentRequests > 0 {
+		s.requests = make(chan struct{}, options.maxConcurrentRequests)
+	}
+	if options.serveWorkers > 0 {
+		s.workers = make(chan struct{}, options.serveWorkers)
+	}
+	s.limiters = newLimiters(options.methodRateLimits)
+	if options.singleflight != nil {
+		s.flights = newFlightGroup()
+	}
+	s.health = &healthService{server: s}
+	if options.healthCheck {
+		s.RegisterName(HealthServiceName, s.health)
 	}
+	if options.reflection {
+		s.RegisterName(ReflectionServiceName, &reflectionService{server: s})
+	}
+	return s
 }
 
 // Register register rpc function
 func (s *Server) Register(rcvr interface{}) error {
-	return s.Server.Register(rcvr)
+	return s.register(rcvr, "", false)
 }
 
 // RegisterName register the rpc function with the specified name
 func (s *Server) RegisterName(name string, rcvr interface{}) error {
-	return s.Server.RegisterName(name, rcvr)
+	return s.register(rcvr, name, true)
+}
+
+// RegisterAlias expose the service registered as name under alias too, calls to either name
+// dispatch to the same receiver. RegisterName may also be called again with another name
+func (s *Server) RegisterAlias(name, alias string) error {
+	svc, ok := s.serviceMap.Load(name)
+	if !ok {
+		return errors.New("rpc: can't find service " + name)
+	}
+	if alias == "" {
+		return errors.New("rpc.RegisterAlias: no alias for service " + name)
+	}
+	if _, dup := s.serviceMap.LoadOrStore(alias, svc); dup {
+		return errors.New("rpc: service already defined: " + alias)
+	}
+	return nil
+}
+
+// Services list the registered services sorted by name, a service registered under several
+// names or aliases is listed once per name
+func (s *Server) Services() []ServiceInfo {
+	var services []ServiceInfo
+	s.serviceMap.Range(func(key, value interface{}) bool {
+		services = append(services, value.(*service).info(key.(string)))
+		return true
+	})
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	return services
+}
+
+func (s *Server) register(rcvr interface{}, name string, useName bool) error {
+	svc, err := newService(rcvr, name, useName)
+	if err != nil {
+		return err
+	}
+	if _, dup := s.serviceMap.LoadOrStore(svc.name, svc); dup {
+		return errors.New("rpc: service already defined: " + svc.name)
+	}
+	return nil
+}
+
+// Serve accept connections on the listener and serve each of them in its own goroutine.
+// Temporary accept errors are logged and retried after a delay, any other error is logged
+// and returned, including the error of a closed listener. Serve returns ServerClosedError
+// once the server is closed
+func (s *Server) Serve(listener net.Listener) error {
+	return s.serve(listener, nil, func(conn net.Conn) {
+		go func() {
+			defer s.releaseWorker()
+			s.ServeConn(conn)
+		}()
+	})
+}
+
+// ServeContext serve the listener like Serve until ctx is done, the listener is closed then and
+// the connections it accepted stop reading requests. ServeContext returns ctx.Err() once the calls
+// in flight on those connections were answered and the connections closed
+func (s *Server) ServeContext(ctx context.Context, listener net.Listener) error {
+	var (
+		mutex sync.Mutex
+		conns = make(map[net.Conn]*atomic.Bool) // set once the connection stops reading
+		wg    sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// 关闭监听使 Accept 返回
+			listener.Close()
+		case <-stop:
+		}
+	}()
+
+	err := s.serve(listener, ctx.Done(), func(conn net.Conn) {
+		stopped := new(atomic.Bool)
+		mutex.Lock()
+		conns[conn] = stopped
+		mutex.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.releaseWorker()
+			s.serveConn(conn, stopped)
+			mutex.Lock()
+			delete(conns, conn)
+			mutex.Unlock()
+		}()
+	})
+	if ctx.Err() == nil {
+		return err
+	}
+	// 停止读取新的请求，已分发的请求回复后连接自行关闭
+	mutex.Lock()
+	for conn, stopped := range conns {
+		// 先标记再停止读取，读取失败时不取消仍在运行的调用
+		stopped.Store(true)
+		stopReading(conn)
+	}
+	mutex.Unlock()
+	wg.Wait()
+	return ctx.Err()
+}
+
+// stopReading make the pending and later reads of conn fail while writes still succeed
+func stopReading(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if c, ok := conn.(interface{ CloseRead() error }); ok {
+		c.CloseRead()
+		return
+	}
+	conn.SetReadDeadline(time.Now())
 }
 
-func (s *Server) Serve(listener net.Listener) {
-	log.Printf("tinyrpc started on: %s", listener.Addr().String())
+// Close close the listeners being served and the connections being served, Serve returns
+// ServerClosedError and later connections are closed at once. Calls in flight are not waited for
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	var err error
+	for listener := range s.listeners {
+		if e := listener.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for conn := range s.active {
+		conn.Close()
+	}
+	return err
+}
+
+// Drain stop accepting connections while the connections being served go on serving their calls,
+// Serve returns ServerClosedError and later connections are closed at once. Drain waits for the
+// connections to be closed by their clients and closes the server then. When ctx is done first
+// the remaining connections are closed like Close does and ctx.Err() is returned
+func (s *Server) Drain(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ServerClosedError
+	}
+	if s.drained == nil {
+		s.drained = make(chan struct{})
+		for listener := range s.listeners {
+			listener.Close()
+		}
+		if len(s.active) == 0 {
+			close(s.drained)
+		}
+	}
+	drained := s.drained
+	s.mutex.Unlock()
+
+	select {
+	case <-drained:
+		return s.Close()
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// track add c to the listeners or connections closed by Close, false if the server is closed or draining
+func (s *Server) track(c io.Closer) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed || s.drained != nil {
+		return false
+	}
+	if listener, ok := c.(net.Listener); ok {
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[listener] = struct{}{}
+		return true
+	}
+	if s.active == nil {
+		s.active = make(map[io.Closer]struct{})
+	}
+	s.active[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(c io.Closer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if listener, ok := c.(net.Listener); ok {
+		delete(s.listeners, listener)
+		return
+	}
+	delete(s.active, c)
+	// 排空时最后一个连接关闭后通知 Drain
+	if s.drained != nil && len(s.active) == 0 {
+		select {
+		case <-s.drained:
+		default:
+			close(s.drained)
+		}
+	}
+}
+
+// isClosed report whether the server stopped accepting connections, being closed or draining
+func (s *Server) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed || s.drained != nil
+}
+
+// serve accept connections on the listener and pass them to handle, which must release the worker
+// slot of the connection once it is served. Waiting for a slot ends when stop is closed
+func (s *Server) serve(listener net.Listener, stop <-chan struct{}, handle func(conn net.Conn)) error {
+	if !s.track(listener) {
+		return ServerClosedError
+	}
+	defer s.untrack(listener)
+	s.options.logger.Infof("%s started on: %s", s.label(), listener.Addr().String())
+	var delay time.Duration
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// 与 net/http 一致，临时错误按指数退避重试
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				s.options.logger.Errorf("%s: accept: %v; retrying in %v", s.label(), err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			if s.isClosed() {
+				return ServerClosedError
+			}
+			s.options.logger.Errorf("%s: accept: %v", s.label(), err)
+			return err
+		}
+		delay = 0
+		// 工作槽位已满时连接在此排队，后续连接留在监听队列中
+		if !s.acquireWorker(stop) {
+			conn.Close()
+			continue
+		}
+		conn = s.options.wrapConn(conn)
+		if s.options.tlsConfig != nil {
+			conn = tls.Server(conn, s.options.tlsConfig)
+		}
+		handle(conn)
+	}
+}
+
+// ServeConn serve a single connection accepted by the caller, blocking until the connection closes
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	s.serveConn(conn, nil)
+}
+
+// serveConn serve conn, stopped is set once the server stops reading it on purpose
+func (s *Server) serveConn(conn io.ReadWriteCloser, stopped *atomic.Bool) {
+	if !s.track(conn) {
+		conn.Close()
+		return
+	}
+	defer s.untrack(conn)
+	id := atomic.AddUint64(&s.connID, 1)
+	addr := remoteAddr(conn)
+	s.emit(ConnEvent{Type: ConnectionOpened, ConnID: id, RemoteAddr: addr})
+
+	if err := s.authorize(conn); err != nil {
+		s.options.logger.Infof("%s: rejected connection from %v: %v", s.label(), addr, err)
+		conn.Close()
+		s.emit(ConnEvent{Type: ConnectionClosed, ConnID: id, RemoteAddr: addr, Err: err})
+		return
+	}
+	defer s.releaseConn()
+	if !s.acquireConn() {
+		err := s.rejectConn(conn)
+		s.emit(ConnEvent{Type: ConnectionClosed, ConnID: id, RemoteAddr: addr, Err: err})
+		return
+	}
+
+	var rec *recorder
+	if s.options.debugFirstRequest {
+		rec = &recorder{ReadWriteCloser: conn}
+		conn = rec
+	}
+	c := codec.NewServerCodec(conn, s.Serializer, s.options.codecOptions()...)
+	// 握手失败的连接不再读取请求，关闭事件带上握手的错误
+	err := handshakeErr(c)
+	if err == nil {
+		s.emit(ConnEvent{Type: HandshakeCompleted, ConnID: id, RemoteAddr: addr, ConnInfo: connInfo(c)})
+		var sc rpc.ServerCodec = c
+		if rec != nil {
+			sc = &firstRequestCodec{ServerCodec: c, rec: rec, addr: addr, logger: s.options.logger, label: s.label()}
+		}
+		ctx := context.WithValue(context.Background(), remoteAddrKey{}, addr)
+		err = s.serveCodec(ctx, sc, stopped)
+	} else {
+		c.Close()
+	}
+	if closedByPeer(err) {
+		s.options.logger.Debugf("%s: connection from %v closed: %v", s.label(), addr, err)
+	} else {
+		s.options.logger.Errorf("%s: connection from %v: %v", s.label(), addr, err)
+	}
+	s.emit(ConnEvent{Type: ConnectionClosed, ConnID: id, RemoteAddr: addr, Err: err, Traffic: connTraffic(c)})
+}
+
+// handshakeErr get the error of the handshake of a server codec created by this package
+func handshakeErr(c interface{}) error {
+	if h, ok := c.(interface{ HandshakeErr() error }); ok {
+		return h.HandshakeErr()
+	}
+	return nil
+}
+
+// closedByPeer report whether the connection ended normally, the peer or the server closed it
+// between requests
+func closedByPeer(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed)
+}
+
+// authorize run the connection authorizer, connections which are not a net.Conn are not checked
+func (s *Server) authorize(conn io.ReadWriteCloser) error {
+	nc, ok := conn.(net.Conn)
+	if !ok || s.options.connAuthorizer == nil {
+		return nil
+	}
+	return s.options.connAuthorizer(nc)
+}
+
+// remoteAddrKey the context key of the peer address of a call
+type remoteAddrKey struct{}
+
+// RemoteAddrFromContext get the peer address of the connection a call arrived on, from the context
+// handed to interceptors and to methods taking one. It is nil for codecs served by ServeCodec
+func RemoteAddrFromContext(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(remoteAddrKey{}).(net.Addr)
+	return addr
+}
+
+// remoteAddr get the peer address of conn, nil if conn is not a network connection
+func remoteAddr(conn io.ReadWriteCloser) net.Addr {
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		return c.RemoteAddr()
+	}
+	return nil
+}
+
+// ServeCodec read requests from the codec and dispatch each of them in its own goroutine,
+// it blocks until the codec fails and returns the error which ended the connection.
+// The context of the calls still running is cancelled once the codec fails
+func (s *Server) ServeCodec(codec rpc.ServerCodec) error {
+	return s.serveCodec(context.Background(), codec, nil)
+}
+
+// serveCodec serve the codec, ctx is the parent context of its calls. The calls running when
+// reading fails are cancelled unless stopped is set, the server stopped reading to shut down then
+func (s *Server) serveCodec(ctx context.Context, codec rpc.ServerCodec, stopped *atomic.Bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sending := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	var err error
+	for {
+		var (
+			svc         *service
+			mtype       *methodType
+			req         *rpc.Request
+			argv        reflect.Value
+			keepReading bool
+		)
+		svc, mtype, req, argv, keepReading, err = s.readRequest(codec)
+		if err != nil {
+			if !keepReading {
+				break
+			}
+			// 请求头正常但无法处理，回复错误后继续读取下一个请求
+			if req != nil {
+				s.sendResponse(sending, req, errorReply(err), codec, err.Error())
+			}
 			continue
 		}
-		go s.Server.ServeCodec(codec.NewServerCodec(conn, s.Serializer))
+		if !s.acquireRequest() {
+			s.sendResponse(sending, req, nil, codec, ServerBusyError.Error())
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer s.releaseRequest()
+			s.call(ctx, sending, wg, svc, mtype, req, argv, codec)
+		}()
+	}
+	// 连接无法再读取，客户端已断开，通知仍在运行的调用提前结束；
+	// 优雅关闭时停止读取的连接仍会回复，调用照常完成
+	if stopped == nil || !stopped.Load() {
+		cancel()
+	}
+	// 等待已分发的请求全部回复后再关闭连接
+	wg.Wait()
+	codec.Close()
+	return err
+}
+
+// readRequest read a request header and body, keepReading reports whether the codec is still usable
+func (s *Server) readRequest(codec rpc.ServerCodec) (svc *service, mtype *methodType, req *rpc.Request, argv reflect.Value, keepReading bool, err error) {
+	req = &rpc.Request{}
+	if err = codec.ReadRequestHeader(req); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			err = fmt.Errorf("rpc: server cannot decode request: %w", err)
+		}
+		return nil, nil, nil, argv, false, err
+	}
+	// 请求头已读取，之后的错误不影响继续读取
+	keepReading = true
+
+	// 未放行的方法与是否注册无关，一律拒绝
+	if !s.permitted(req.ServiceMethod) {
+		err = &MethodNotAllowedError{Message: "rpc: method " + req.ServiceMethod + " not allowed"}
+	} else {
+		svc, mtype, err = s.lookup(req.ServiceMethod)
+	}
+	if err != nil {
+		keepReading, err = discardBody(codec, err)
+		return
+	}
+
+	// 超过限流的请求不解码参数，请求体仍需验证后丢弃
+	cancelReservation, ok := s.reserve(req.ServiceMethod)
+	if !ok {
+		keepReading, err = discardBody(codec, RateLimitedError)
+		return
+	}
+	var argIsValue bool
+	argv, argIsValue, err = s.getArgs(req.ServiceMethod, mtype)
+	if err != nil {
+		cancelReservation()
+		keepReading, err = discardBody(codec, err)
+		return
+	}
+	err = codec.ReadRequestBody(argv.Interface())
+	if argIsValue {
+		argv = argv.Elem()
+	}
+	if err != nil {
+		// 无法读取或伪造的请求归还令牌，不占用限流额度
+		cancelReservation()
+		s.putArgs(req.ServiceMethod, mtype, argv)
+		// 未通过验证的请求不回复，连接随之关闭
+		keepReading = !authFailed(err)
+	}
+	return
+}
+
+// discardBody skip the body of a request which is not dispatched because of err, a body failing
+// authentication ends the connection and is not answered
+func discardBody(sc rpc.ServerCodec, err error) (bool, error) {
+	if berr := sc.ReadRequestBody(nil); authFailed(berr) {
+		return false, berr
+	}
+	return true, err
+}
+
+// authFailed report whether err is a request body failing authentication
+func authFailed(err error) bool {
+	return errors.Is(err, codec.AuthenticationError)
+}
+
+// lookup find the service and method of "Service.Method"
+func (s *Server) lookup(serviceMethod string) (*service, *methodType, error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, nil, errors.New("rpc: service/method request ill-formed: " + serviceMethod)
+	}
+	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+
+	svci, ok := s.serviceMap.Load(serviceName)
+	if !ok {
+		return nil, nil, &MethodNotFoundError{Message: "rpc: can't find service " + serviceMethod}
+	}
+	svc := svci.(*service)
+	mtype := svc.method[methodName]
+	if mtype == nil {
+		return nil, nil, &MethodNotFoundError{Message: "rpc: can't find method " + serviceMethod}
+	}
+	return svc, mtype, nil
+}
+
+// call invoke the method through the interceptors and write its reply
+func (s *Server) call(ctx context.Context, sending *sync.Mutex, wg *sync.WaitGroup, svc *service, mtype *methodType, req *rpc.Request, argv reflect.Value, codec rpc.ServerCodec) {
+	defer wg.Done()
+	if s.options.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.options.requestTimeout)
+		defer cancel()
+	}
+	errmsg := ""
+	var stream *ServerStream
+	if mtype.stream {
+		stream = &ServerStream{sending: sending, codec: codec, req: req}
+	}
+	reply, err := s.invoke(ctx, req.ServiceMethod, svc, mtype, argv, stream)
+	if ctx.Err() == context.DeadlineExceeded && s.options.requestTimeout > 0 {
+		// 超时后返回的结果不再发送
+		reply, err = nil, DeadlineExceededError
+	}
+	if err != nil {
+		var pe *panicError
+		if errors.As(err, &pe) {
+			s.handlePanic(pe)
+		}
+		errmsg = err.Error()
+		reply = errorReply(err)
+	}
+	s.sendResponse(sending, req, reply, codec, errmsg)
+	s.putArgs(req.ServiceMethod, mtype, argv)
+}
+
+// handlePanic pass a recovered panic to the panic handler, or log it when there is none
+func (s *Server) handlePanic(pe *panicError) {
+	if s.options.panicHandler != nil {
+		s.options.panicHandler(pe.method, pe.recovered, pe.stack)
+		return
+	}
+	s.options.logger.Errorf("%s: recovered %v\n%s", s.label(), pe, pe.stack)
+}
+
+// invoke run the interceptor chain around the method, panics are recovered and returned as a *panicError,
+// stream is handed to streaming methods in place of the reply
+func (s *Server) invoke(ctx context.Context, method string, svc *service, mtype *methodType, argv reflect.Value, stream *ServerStream) (reply interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			reply, err = nil, &panicError{method: method, recovered: r, stack: debug.Stack()}
+		}
+	}()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if stream != nil {
+			// 流式方法的消息已经发出，最终响应不带响应体
+			return nil, svc.call(ctx, mtype, reflect.ValueOf(req), reflect.ValueOf(stream))
+		}
+		replyv := mtype.newReplyv()
+		if err := svc.call(ctx, mtype, reflect.ValueOf(req), replyv); err != nil {
+			return nil, err
+		}
+		return replyv.Interface(), nil
+	}
+	if stream == nil {
+		// 在拦截器之内合并相同的调用，每个调用仍各自经过拦截器
+		handler = s.shareCalls(method, handler)
+	}
+	return chain(method, s.options.interceptors, handler)(ctx, argv.Interface())
+}
+
+// errorReply the reply sent with an error response, nil unless err carries an error code
+func errorReply(err error) interface{} {
+	// 错误响应不带响应体，带错误码的错误交给编解码器写入响应头
+	var coder interface{ ErrorCode() uint32 }
+	if errors.As(err, &coder) {
+		return coder
+	}
+	return nil
+}
+
+func (s *Server) sendResponse(sending *sync.Mutex, req *rpc.Request, reply interface{}, codec rpc.ServerCodec, errmsg string) {
+	resp := &rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq}
+	if errmsg != "" {
+		resp.Error = errmsg
+		if _, ok := reply.(interface{ ErrorCode() uint32 }); !ok {
+			reply = nil
+		}
+	}
+	sending.Lock()
+	defer sending.Unlock()
+	if err := codec.WriteResponse(resp, reply); err != nil {
+		s.options.logger.Errorf("%s: writing response: %v", s.label(), err)
 	}
 }
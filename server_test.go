@@ -0,0 +1,672 @@
+package tiny_rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tiny_rpc/codec"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startServer start a server serving ArithService on a random local port
+func startServer(t testing.TB, opts ...Option) (*Server, net.Listener) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := NewServer(opts...)
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	go server.Serve(listener)
+	return server, listener
+}
+
+// nextEvent wait for the next connection event
+func nextEvent(t *testing.T, events <-chan ConnEvent) ConnEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for connection event")
+	}
+	return ConnEvent{}
+}
+
+// TestServer_ConnectionEvents .
+func TestServer_ConnectionEvents(t *testing.T) {
+	events := make(chan ConnEvent, 8)
+	_, listener := startServer(t, WithConnectionEvents(events))
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	client := NewClient(conn)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+	traffic := client.Traffic()
+	assert.Nil(t, client.Close())
+
+	opened := nextEvent(t, events)
+	assert.Equal(t, ConnectionOpened, opened.Type)
+	assert.Equal(t, conn.LocalAddr().String(), opened.RemoteAddr.String())
+
+	handshake := nextEvent(t, events)
+	assert.Equal(t, HandshakeCompleted, handshake.Type)
+	assert.Equal(t, opened.ConnID, handshake.ConnID)
+
+	closed := nextEvent(t, events)
+	assert.Equal(t, ConnectionClosed, closed.Type)
+	assert.Equal(t, opened.ConnID, closed.ConnID)
+	assert.Equal(t, io.EOF, closed.Err)
+	// 两端统计的字节数一致
+	assert.Equal(t, codec.Traffic{BytesRead: traffic.BytesWritten, BytesWritten: traffic.BytesRead}, closed.Traffic)
+	assert.Greater(t, traffic.BytesRead, uint64(0))
+}
+
+// TestServer_ConnectionEventsHandshakeFailed .
+func TestServer_ConnectionEventsHandshakeFailed(t *testing.T) {
+	events := make(chan ConnEvent, 8)
+	_, listener := startServer(t, WithHandshake(), WithConnectionEvents(events), WithLogger(&captureLogger{}))
+
+	// 握手帧不是合法的握手
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte{3, 0xff, 0xff, 0xff})
+	assert.Nil(t, err)
+
+	opened := nextEvent(t, events)
+	assert.Equal(t, ConnectionOpened, opened.Type)
+	// 握手失败时没有 HandshakeCompleted，关闭事件带上握手的错误
+	closed := nextEvent(t, events)
+	assert.Equal(t, ConnectionClosed, closed.Type)
+	assert.Equal(t, opened.ConnID, closed.ConnID)
+	assert.NotNil(t, closed.Err)
+}
+
+// TestServer_ConnectionEventsDropped .
+func TestServer_ConnectionEventsDropped(t *testing.T) {
+	events := make(chan ConnEvent) // 无缓冲且无人接收，事件应被丢弃
+	_, listener := startServer(t, WithConnectionEvents(events))
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	client := NewClient(conn)
+	defer client.Close()
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Mul", &pb.ArithRequest{A: 2, B: 3}, reply))
+	assert.Equal(t, 6.0, reply.C)
+}
+
+// TestServer_ServeConn .
+func TestServer_ServeConn(t *testing.T) {
+	server := NewServer()
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(serverConn)
+		close(done)
+	}()
+
+	client := NewClient(clientConn)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Sub", &pb.ArithRequest{A: 5, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+	assert.Nil(t, client.Close())
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn did not return after the connection closed")
+	}
+}
+
+// PanicService a service whose method panics
+type PanicService struct{}
+
+// Panic .
+func (_ *PanicService) Panic(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	panic("boom")
+}
+
+// TestServer_RecoverPanic .
+func TestServer_RecoverPanic(t *testing.T) {
+	server, listener := startServer(t)
+	assert.Nil(t, server.Register(new(PanicService)))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	err = client.Call("PanicService.Panic", &pb.ArithRequest{}, &pb.ArithResponse{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "boom")
+
+	// 服务端依然存活，同一连接可继续调用
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// TestServer_PanicHandler .
+func TestServer_PanicHandler(t *testing.T) {
+	type panicked struct {
+		method    string
+		recovered interface{}
+		stack     []byte
+	}
+	panics := make(chan panicked, 1)
+	logger := &captureLogger{}
+	server, listener := startServer(t, WithLogger(logger), WithPanicHandler(func(method string, recovered interface{}, stack []byte) {
+		panics <- panicked{method, recovered, stack}
+	}))
+	assert.Nil(t, server.Register(new(PanicService)))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	err = client.Call("PanicService.Panic", &pb.ArithRequest{}, &pb.ArithResponse{})
+	assert.EqualError(t, err, "rpc: PanicService.Panic panic: boom")
+	// 回调在响应发出前执行
+	select {
+	case p := <-panics:
+		assert.Equal(t, "PanicService.Panic", p.method)
+		assert.Equal(t, "boom", p.recovered)
+		assert.Contains(t, string(p.stack), "(*PanicService).Panic")
+	default:
+		t.Fatal("panic handler was not called before the response")
+	}
+	// 设置了回调时不再记录日志
+	assert.False(t, logger.contains("error tinyrpc: recovered"))
+}
+
+// TestServer_MethodNotFound .
+func TestServer_MethodNotFound(t *testing.T) {
+	_, listener := startServer(t)
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	err = client.Call("ArithService.Pow", &pb.ArithRequest{}, &pb.ArithResponse{})
+	assert.Equal(t, &MethodNotFoundError{Message: "rpc: can't find method ArithService.Pow"}, err)
+	err = client.Call("Unknown.Add", &pb.ArithRequest{}, &pb.ArithResponse{})
+	var nf *MethodNotFoundError
+	assert.True(t, errors.As(err, &nf))
+	assert.Equal(t, "rpc: can't find service Unknown.Add", nf.Error())
+	call := <-client.AsyncCall("ArithService.Pow", &pb.ArithRequest{}, &pb.ArithResponse{})
+	assert.Equal(t, &MethodNotFoundError{Message: "rpc: can't find method ArithService.Pow"}, call.Error)
+
+	// 方法自身返回的错误不是 MethodNotFoundError
+	err = client.Call("ArithService.Div", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	assert.NotNil(t, err)
+	assert.False(t, errors.As(err, &nf))
+
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// temporaryError a net.Error reporting itself temporary
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "accept: resource temporarily unavailable" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// TestServer_AcceptTemporaryError .
+func TestServer_AcceptTemporaryError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	logger := &captureLogger{}
+	server := NewServer(WithLogger(logger))
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	go server.Serve(&failingListener{Listener: listener, err: temporaryError{}})
+
+	// 临时错误被记录，之后继续接受连接
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+	assert.True(t, logger.contains("error tinyrpc: accept: accept: resource temporarily unavailable; retrying in"))
+}
+
+// TestServer_ServeClosedListener .
+func TestServer_ServeClosedListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	logger := &captureLogger{}
+	server := NewServer(WithLogger(logger))
+	errs := make(chan error)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	listener.Close()
+	select {
+	case err = <-errs:
+		assert.True(t, errors.Is(err, net.ErrClosed))
+		assert.True(t, logger.contains("error tinyrpc: accept: "))
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after the listener was closed")
+	}
+}
+
+// PeerService reports the address of its callers
+type PeerService struct{}
+
+// Addr .
+func (_ *PeerService) Addr(ctx context.Context, args *string, reply *string) error {
+	if addr := RemoteAddrFromContext(ctx); addr != nil {
+		*reply = *args + addr.String()
+	}
+	return nil
+}
+
+// TestServer_RemoteAddr .
+func TestServer_RemoteAddr(t *testing.T) {
+	var intercepted net.Addr
+	server, listener := startServer(t, WithSerializer(serializer.JSON), WithInterceptors(
+		func(ctx context.Context, method string, req interface{}, next Handler) (interface{}, error) {
+			intercepted = RemoteAddrFromContext(ctx)
+			return next(ctx, req)
+		}))
+	assert.Nil(t, server.Register(new(PeerService)))
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	client := NewClient(conn, WithSerializer(serializer.JSON))
+	defer client.Close()
+
+	var reply string
+	assert.Nil(t, client.Call("PeerService.Addr", "peer ", &reply))
+	assert.Equal(t, "peer "+conn.LocalAddr().String(), reply)
+	assert.Equal(t, conn.LocalAddr().String(), intercepted.String())
+
+	// ServeCodec 服务的连接没有对端地址
+	assert.Nil(t, RemoteAddrFromContext(context.Background()))
+}
+
+// TestServer_ServeContext .
+func TestServer_ServeContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := NewServer()
+	block := newBlockService()
+	assert.Nil(t, server.Register(block))
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- server.ServeContext(ctx, listener)
+	}()
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	done := client.AsyncCall("BlockService.Wait", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	<-block.entered
+	// 停止读取不会取消进行中调用的 context
+	waiting := client.AsyncCall("BlockService.WaitContext", &pb.ArithRequest{A: 2}, &pb.ArithResponse{})
+	<-block.entered
+
+	cancel()
+	// 等待进行中的调用完成
+	select {
+	case <-errs:
+		t.Fatal("ServeContext returned before the call in flight was answered")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+	assert.NotNil(t, err)
+
+	close(block.release)
+	call := <-done
+	assert.Nil(t, call.Error)
+	assert.Equal(t, 1.0, call.Reply.(*pb.ArithResponse).C)
+	call = <-waiting
+	assert.Nil(t, call.Error)
+	assert.Equal(t, 2.0, call.Reply.(*pb.ArithResponse).C)
+	select {
+	case err = <-errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("ServeContext did not return after the context was cancelled")
+	}
+}
+
+// CodeService fails its callers with an error code
+type CodeService struct{}
+
+// Find .
+func (_ *CodeService) Find(args *string, reply *string) error {
+	return fmt.Errorf("find %s: %w", *args, &codec.CodeError{Code: 404, Message: "not found"})
+}
+
+// TestServer_ErrorCode .
+func TestServer_ErrorCode(t *testing.T) {
+	server, listener := startServer(t, WithSerializer(serializer.JSON))
+	assert.Nil(t, server.Register(new(CodeService)))
+	client, err := Dial("tcp", listener.Addr().String(), WithSerializer(serializer.JSON))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var reply string
+	err = client.Call("CodeService.Find", "user", &reply)
+	var ce *codec.CodeError
+	assert.True(t, errors.As(err, &ce))
+	assert.Equal(t, uint32(404), ce.Code)
+	assert.Equal(t, "find user: not found", ce.Message)
+}
+
+// TestServer_ConnAuthorizer .
+func TestServer_ConnAuthorizer(t *testing.T) {
+	denied := errors.New("peer not allowed")
+	logger := &captureLogger{}
+	events := make(chan ConnEvent, 8)
+	_, listener := startServer(t, WithLogger(logger), WithConnectionEvents(events),
+		WithConnAuthorizer(func(conn net.Conn) error {
+			if conn.RemoteAddr().(*net.TCPAddr).IP.Equal(net.IPv4(127, 0, 0, 2)) {
+				return denied
+			}
+			return nil
+		}))
+
+	// 来自被拒绝地址的连接在读取请求前被关闭
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	rejected := NewClient(conn)
+	defer rejected.Close()
+	err = rejected.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+	assert.NotNil(t, err)
+	assert.Equal(t, ConnectionOpened, nextEvent(t, events).Type)
+	closed := nextEvent(t, events)
+	assert.Equal(t, ConnectionClosed, closed.Type)
+	assert.Equal(t, denied, closed.Err)
+	assert.True(t, logger.contains("info tinyrpc: rejected connection from 127.0.0.2"))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// TestServer_Close .
+func TestServer_Close(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	logger := &captureLogger{}
+	server := NewServer(WithLogger(logger))
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	errs := make(chan error)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+
+	assert.Nil(t, server.Close())
+	select {
+	case err = <-errs:
+		assert.Equal(t, ServerClosedError, err)
+		assert.False(t, logger.contains("error tinyrpc: accept: "))
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after the server was closed")
+	}
+	// 已建立的连接被关闭，新的连接被拒绝
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply)
+	assert.NotNil(t, err)
+	_, err = net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+	assert.NotNil(t, err)
+
+	// 重复关闭是安全的，关闭后不再服务
+	assert.Nil(t, server.Close())
+	assert.Equal(t, ServerClosedError, server.Serve(listener))
+	serverConn, clientConn := net.Pipe()
+	server.ServeConn(serverConn)
+	_, err = clientConn.Write([]byte{0x0})
+	assert.NotNil(t, err)
+}
+
+// TestServer_Drain .
+func TestServer_Drain(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := NewServer()
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	errs := make(chan error)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+
+	drained := make(chan error)
+	go func() {
+		drained <- server.Drain(context.Background())
+	}()
+	select {
+	case err = <-errs:
+		assert.Equal(t, ServerClosedError, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after the server was drained")
+	}
+	// 已建立的连接继续服务，新的连接被拒绝
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 3, B: 4}, reply))
+	assert.Equal(t, 7.0, reply.C)
+	_, err = net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+	assert.NotNil(t, err)
+	select {
+	case <-drained:
+		t.Fatal("Drain returned while a connection is open")
+	default:
+	}
+
+	// 客户端关闭连接后排空完成
+	client.Close()
+	select {
+	case err = <-drained:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not return after the connections were closed")
+	}
+	assert.Equal(t, ServerClosedError, server.Drain(context.Background()))
+}
+
+// TestServer_DrainTimeout .
+func TestServer_DrainTimeout(t *testing.T) {
+	server, listener := startServer(t)
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+
+	// 超时后剩余的连接被关闭
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, server.Drain(ctx))
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply)
+	assert.NotNil(t, err)
+}
+
+// startWriteCountingServer start a server counting the writes of each connection it accepts
+func startWriteCountingServer(t testing.TB, opts ...Option) (net.Listener, <-chan *countingConn) {
+	conns := make(chan *countingConn, 4)
+	opts = append(opts, WithConnWrapper(func(conn net.Conn) net.Conn {
+		counting := &countingConn{Conn: conn}
+		conns <- counting
+		return counting
+	}))
+	_, listener := startServer(t, opts...)
+	return listener, conns
+}
+
+// TestServer_ResponseBatching .
+func TestServer_ResponseBatching(t *testing.T) {
+	listener, conns := startWriteCountingServer(t, WithResponseBatching(20*time.Millisecond, 0))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	goCalls(t, client, 50)
+	assert.Less(t, atomic.LoadInt64(&(<-conns).writes), int64(50))
+
+	// 达到批量上限时立即发送，不等待窗口结束
+	listener, conns = startWriteCountingServer(t, WithResponseBatching(time.Hour, 10))
+	client, err = Dial("tcp", listener.Addr().String(), WithBatching(time.Hour, 50))
+	assert.Nil(t, err)
+	defer client.Close()
+	goCalls(t, client, 50)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&(<-conns).writes))
+}
+
+// BenchmarkServer_ResponseBatching .
+func BenchmarkServer_ResponseBatching(b *testing.B) {
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{"immediate", nil},
+		{"batched", []Option{WithResponseBatching(time.Millisecond, 64)}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			listener, conns := startWriteCountingServer(b, c.opts...)
+			client, err := Dial("tcp", listener.Addr().String(), WithBatching(time.Millisecond, 64))
+			assert.Nil(b, err)
+			defer client.Close()
+			conn := <-conns
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				goCalls(b, client, 64)
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&conn.writes))/float64(b.N), "writes/op")
+		})
+	}
+}
+
+// TestServer_UnsupportedCompressor .
+func TestServer_UnsupportedCompressor(t *testing.T) {
+	registry := codec.NewRegistry().RegisterSerializer(serializer.ProtoType, serializer.Proto).
+		RegisterCompressor(compressor.Snappy, compressor.SnappyCompressor{})
+	_, listener := startServer(t, WithRegistry(registry))
+	client, err := Dial("tcp", listener.Addr().String(), WithCompress(compressor.Gzip))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// 连接未被关闭，每次调用都得到同样的错误
+	for i := 0; i < 2; i++ {
+		err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, &pb.ArithResponse{})
+		var ue *codec.UnsupportedCompressorError
+		assert.True(t, errors.As(err, &ue))
+		assert.Equal(t, compressor.Gzip, ue.CompressType)
+		assert.Equal(t, []compressor.CompressType{compressor.Raw, compressor.Snappy}, ue.Supported)
+		assert.True(t, errors.Is(err, codec.NotFoundCompressorError))
+	}
+}
+
+// TestServer_RegisterAlias .
+func TestServer_RegisterAlias(t *testing.T) {
+	server, listener := startServer(t)
+	arith := new(pb.ArithService)
+	assert.Nil(t, server.RegisterName("Calc", arith))
+	assert.Nil(t, server.RegisterName("Calculator", arith))
+	assert.Nil(t, server.RegisterAlias("ArithService", "Arith"))
+	assert.NotNil(t, server.RegisterAlias("NoService", "Alias"))
+	assert.NotNil(t, server.RegisterAlias("ArithService", "Calc"))
+	assert.NotNil(t, server.RegisterAlias("ArithService", ""))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	for _, name := range []string{"ArithService", "Arith", "Calc", "Calculator"} {
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.Call(name+".Mul", &pb.ArithRequest{A: 2, B: 3}, reply))
+		assert.Equal(t, 6.0, reply.C)
+	}
+}
+
+// TestServer_Services .
+func TestServer_Services(t *testing.T) {
+	server := NewServer(WithHealthCheck())
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	assert.Nil(t, server.Register(new(EchoService)))
+	assert.Nil(t, server.RegisterAlias("EchoService", "Echo"))
+
+	services := server.Services()
+	names := make([]string, 0, len(services))
+	for _, svc := range services {
+		names = append(names, svc.Name)
+	}
+	assert.Equal(t, []string{"ArithService", "Echo", "EchoService", HealthServiceName}, names)
+
+	arith := services[0]
+	methods := make([]string, 0, len(arith.Methods))
+	for _, m := range arith.Methods {
+		methods = append(methods, m.Name)
+		assert.Equal(t, reflect.TypeOf(&pb.ArithRequest{}), m.ArgType)
+		assert.Equal(t, reflect.TypeOf(&pb.ArithResponse{}), m.ReplyType)
+		assert.False(t, m.Stream)
+	}
+	assert.Equal(t, []string{"Add", "Div", "Mul", "Sub"}, methods)
+
+	echo := services[2]
+	assert.Len(t, echo.Methods, 1)
+	assert.Equal(t, "Echo", echo.Methods[0].Name)
+	assert.Equal(t, reflect.TypeOf(new(string)), echo.Methods[0].ArgType)
+	assert.Equal(t, reflect.TypeOf(new(string)), echo.Methods[0].ReplyType)
+}
+
+// TestServer_Registry .
+func TestServer_Registry(t *testing.T) {
+	registry := codec.NewRegistry().RegisterSerializer(serializer.ProtoType, serializer.Proto)
+	_, listener := startServer(t, WithRegistry(registry))
+
+	tests := []struct {
+		name string
+		opts []Option
+		err  error
+	}{
+		{"test-1", nil, nil},
+		// 包级注册表中存在，但服务端的注册表中没有
+		{"test-2", []Option{WithCompress(compressor.Gzip)}, codec.NotFoundCompressorError},
+		{"test-3", []Option{WithSerializer(serializer.JSON)}, codec.SerializerMismatchError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := Dial("tcp", listener.Addr().String(), tt.opts...)
+			assert.Nil(t, err)
+			defer client.Close()
+			reply := &pb.ArithResponse{}
+			err = client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply)
+			if tt.err == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, 3.0, reply.C)
+				return
+			}
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), tt.err.Error())
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package tiny_rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/token"
+	"reflect"
+	"sort"
+)
+
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// methodType a registered rpc method looks like
+// func (t *T) MethodName(args T1, reply *T2) error
+// or, to receive the call context,
+// func (t *T) MethodName(ctx context.Context, args T1, reply *T2) error
+type methodType struct {
+	method      reflect.Method
+	ArgType     reflect.Type
+	ReplyType   reflect.Type
+	stream      bool // the reply is a *ServerStream
+	withContext bool // the first argument is a context.Context
+}
+
+// service a registered receiver and its rpc methods
+type service struct {
+	name   string
+	rcvr   reflect.Value
+	typ    reflect.Type
+	method map[string]*methodType
+}
+
+// MethodInfo the signature of a registered rpc method
+type MethodInfo struct {
+	Name        string
+	ArgType     reflect.Type
+	ReplyType   reflect.Type
+	Stream      bool // the method streams its replies through a *ServerStream
+	WithContext bool // the method takes the call context
+}
+
+// ServiceInfo a registered service and its rpc methods sorted by name
+type ServiceInfo struct {
+	Name    string
+	Methods []MethodInfo
+}
+
+// info describe the service as registered under name
+func (s *service) info(name string) ServiceInfo {
+	info := ServiceInfo{Name: name, Methods: make([]MethodInfo, 0, len(s.method))}
+	for mname, mtype := range s.method {
+		info.Methods = append(info.Methods, MethodInfo{
+			Name:        mname,
+			ArgType:     mtype.ArgType,
+			ReplyType:   mtype.ReplyType,
+			Stream:      mtype.stream,
+			WithContext: mtype.withContext,
+		})
+	}
+	sort.Slice(info.Methods, func(i, j int) bool {
+		return info.Methods[i].Name < info.Methods[j].Name
+	})
+	return info
+}
+
+// newService build a service from rcvr, name overrides the receiver type name when useName is set
+func newService(rcvr interface{}, name string, useName bool) (*service, error) {
+	s := &service{
+		typ:  reflect.TypeOf(rcvr),
+		rcvr: reflect.ValueOf(rcvr),
+	}
+	sname := name
+	if !useName {
+		sname = reflect.Indirect(s.rcvr).Type().Name()
+	}
+	if sname == "" {
+		return nil, errors.New("rpc.Register: no service name for type " + s.typ.String())
+	}
+	if !useName && !token.IsExported(sname) {
+		return nil, errors.New("rpc.Register: type " + sname + " is not exported")
+	}
+	s.name = sname
+
+	s.method = suitableMethods(s.typ)
+	if len(s.method) == 0 {
+		return nil, errors.New("rpc.Register: type " + sname + " has no exported methods of suitable type")
+	}
+	return s, nil
+}
+
+// suitableMethods returns the rpc methods of typ
+func suitableMethods(typ reflect.Type) map[string]*methodType {
+	methods := make(map[string]*methodType)
+	for m := 0; m < typ.NumMethod(); m++ {
+		method := typ.Method(m)
+		mtype := method.Type
+		// 方法必须导出，且形如 (rcvr, [ctx,] args, *reply) error
+		if !method.IsExported() || mtype.NumOut() != 1 {
+			continue
+		}
+		withContext := mtype.NumIn() == 4 && mtype.In(1) == typeOfContext
+		if mtype.NumIn() != 3 && !withContext {
+			continue
+		}
+		in := 1
+		if withContext {
+			in++
+		}
+		argType := mtype.In(in)
+		if !isExportedOrBuiltinType(argType) {
+			continue
+		}
+		replyType := mtype.In(in + 1)
+		if replyType.Kind() != reflect.Pointer || !isExportedOrBuiltinType(replyType) {
+			continue
+		}
+		if mtype.Out(0) != typeOfError {
+			continue
+		}
+		methods[method.Name] = &methodType{
+			method:      method,
+			ArgType:     argType,
+			ReplyType:   replyType,
+			stream:      replyType == typeOfServerStream,
+			withContext: withContext,
+		}
+	}
+	return methods
+}
+
+func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return token.IsExported(t.Name()) || t.PkgPath() == ""
+}
+
+// newArgv allocate the value the request body decodes into
+func (m *methodType) newArgv() (argv reflect.Value, argIsValue bool) {
+	if m.ArgType.Kind() == reflect.Pointer {
+		return reflect.New(m.ArgType.Elem()), false
+	}
+	return reflect.New(m.ArgType), true
+}
+
+// newReplyv allocate the reply value handed to the method
+func (m *methodType) newReplyv() reflect.Value {
+	replyv := reflect.New(m.ReplyType.Elem())
+	switch m.ReplyType.Elem().Kind() {
+	case reflect.Map:
+		replyv.Elem().Set(reflect.MakeMap(m.ReplyType.Elem()))
+	case reflect.Slice:
+		replyv.Elem().Set(reflect.MakeSlice(m.ReplyType.Elem(), 0, 0))
+	}
+	return replyv
+}
+
+// panicError a panic recovered from an rpc method or interceptor
+type panicError struct {
+	method    string
+	recovered interface{}
+	stack     []byte // stack trace of the panicking goroutine
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("rpc: %s panic: %v", e.method, e.recovered)
+}
+
+// call invoke the method with the decoded args and the reply to fill, ctx is passed to methods taking one
+func (s *service) call(ctx context.Context, mtype *methodType, argv, replyv reflect.Value) error {
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if mtype.withContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := mtype.method.Func.Call(in)
+	if errInter := returnValues[0].Interface(); errInter != nil {
+		return errInter.(error)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package tiny_rpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"tiny_rpc/serializer"
+)
+
+// flightPanicError answers the calls sharing the run of a method which panicked, the caller
+// whose call ran the method gets the panic itself
+var flightPanicError = errors.New("rpc: shared call panicked")
+
+// WithSingleflight run the concurrent calls of the listed methods, named "Service.Method", which carry
+// the same arguments once across the server and share the reply among them, like singleflight. The
+// methods must be idempotent, the call running them keeps the context of its own caller. The calls are
+// told apart by the arguments encoded with the server serializer, streaming methods are never shared
+func WithSingleflight(methods ...string) Option {
+	return func(o *options) {
+		if o.singleflight == nil {
+			o.singleflight = make(map[string]struct{})
+		}
+		for _, method := range methods {
+			o.singleflight[method] = struct{}{}
+		}
+	}
+}
+
+// flightGroup the calls in flight of the methods shared by WithSingleflight
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flight // by method and digest of the arguments
+}
+
+// flight a call whose reply is shared by the identical calls arriving while it runs
+type flight struct {
+	done  chan struct{} // closed once reply and err are set
+	reply interface{}
+	err   error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flight)}
+}
+
+// flightKey key the call of method with args, false if args can not be encoded
+func flightKey(s serializer.Serializer, method string, args interface{}) (string, bool) {
+	data, err := s.Marshal(args)
+	if err != nil {
+		return "", false
+	}
+	digest := sha256.Sum256(data)
+	return method + "\x00" + string(digest[:]), true
+}
+
+// do run handler unless an identical call is in flight, whose reply is returned then. A waiting
+// call gives up when its own ctx is done
+func (g *flightGroup) do(ctx context.Context, key string, req interface{}, handler Handler) (interface{}, error) {
+	g.mutex.Lock()
+	if f, ok := g.calls[key]; ok {
+		g.mutex.Unlock()
+		select {
+		case <-f.done:
+			return f.reply, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	f := &flight{done: make(chan struct{}), err: flightPanicError}
+	g.calls[key] = f
+	g.mutex.Unlock()
+
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		// handler 发生 panic 时等待的调用收到 flightPanicError
+		close(f.done)
+	}()
+	f.reply, f.err = handler(ctx, req)
+	return f.reply, f.err
+}
+
+// shareCalls make handler share the calls of method with identical arguments when it is listed by
+// WithSingleflight
+func (s *Server) shareCalls(method string, handler Handler) Handler {
+	if _, ok := s.options.singleflight[method]; !ok {
+		return handler
+	}
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		key, ok := flightKey(s.Serializer, method, req)
+		if !ok {
+			return handler(ctx, req)
+		}
+		return s.flights.do(ctx, key, req, handler)
+	}
+}
@@ -0,0 +1,125 @@
+package tiny_rpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitCounter counts the calls waiting on their context, which the calls sharing the reply of an
+// identical call in flight do while the method itself does not
+type waitCounter struct {
+	waiting int64
+}
+
+// interceptor hand the calls a context counting them once they wait on it
+func (w *waitCounter) interceptor(ctx context.Context, method string, req interface{}, next Handler) (interface{}, error) {
+	return next(&countingContext{Context: ctx, waiting: &w.waiting}, req)
+}
+
+func (w *waitCounter) load() int64 {
+	return atomic.LoadInt64(&w.waiting)
+}
+
+type countingContext struct {
+	context.Context
+	waiting *int64
+}
+
+func (c *countingContext) Done() <-chan struct{} {
+	atomic.AddInt64(c.waiting, 1)
+	return c.Context.Done()
+}
+
+// TestServer_Singleflight .
+func TestServer_Singleflight(t *testing.T) {
+	const n = 10
+	block := newBlockService()
+	waits := &waitCounter{}
+	server, listener := startServer(t, WithSingleflight("BlockService.Wait"), WithInterceptors(waits.interceptor))
+	assert.Nil(t, server.Register(block))
+
+	// 两个连接上的相同调用同样合并
+	var clients []*Client
+	for i := 0; i < 2; i++ {
+		client, err := Dial("tcp", listener.Addr().String())
+		assert.Nil(t, err)
+		defer client.Close()
+		clients = append(clients, client)
+	}
+	var wg sync.WaitGroup
+	replies := make([]*pb.ArithResponse, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			replies[i] = &pb.ArithResponse{}
+			errs[i] = clients[i%2].Call("BlockService.Wait", &pb.ArithRequest{A: 7}, replies[i])
+		}(i)
+	}
+	// 方法阻塞到其他调用都在等待它的结果
+	<-block.entered
+	assert.Eventually(t, func() bool {
+		return waits.load() == n-1
+	}, time.Second, time.Millisecond)
+
+	close(block.release)
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		assert.Nil(t, errs[i])
+		assert.Equal(t, 7.0, replies[i].C)
+	}
+	// 方法只运行了一次
+	assert.Len(t, block.entered, 0)
+
+	// 参数不同的调用各自运行
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, clients[0].Call("BlockService.Wait", &pb.ArithRequest{A: 8}, reply))
+	assert.Equal(t, 8.0, reply.C)
+	assert.Len(t, block.entered, 1)
+	assert.Nil(t, clients[0].Call("BlockService.Wait", &pb.ArithRequest{A: 7}, reply))
+	assert.Len(t, block.entered, 2)
+}
+
+// PanicOnceService panics on its first call
+type PanicOnceService struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+// Boom .
+func (p *PanicOnceService) Boom(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	p.entered <- struct{}{}
+	<-p.release
+	panic("boom")
+}
+
+// TestServer_SingleflightPanic .
+func TestServer_SingleflightPanic(t *testing.T) {
+	svc := &PanicOnceService{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	waits := &waitCounter{}
+	server, listener := startServer(t, WithSingleflight("PanicOnceService.Boom"), WithLogger(&captureLogger{}),
+		WithInterceptors(waits.interceptor))
+	assert.Nil(t, server.Register(svc))
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	first := client.AsyncCall("PanicOnceService.Boom", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	<-svc.entered
+	second := client.AsyncCall("PanicOnceService.Boom", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	assert.Eventually(t, func() bool {
+		return waits.load() == 1
+	}, time.Second, time.Millisecond)
+	close(svc.release)
+
+	// 等待的调用不会一直阻塞
+	assert.NotNil(t, (<-first).Error)
+	assert.Equal(t, flightPanicError.Error(), (<-second).Error.Error())
+}
@@ -0,0 +1,165 @@
+package tiny_rpc
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tiny_rpc/codec"
+	"tiny_rpc/compressor"
+	"tiny_rpc/serializer"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// statsRecorder a codec.StatsHandler recording every callback
+type statsRecorder struct {
+	mutex  sync.Mutex
+	starts []codec.RequestStats
+	ends   []codec.RequestStats
+	errs   []error
+}
+
+func (r *statsRecorder) RequestStart(stats codec.RequestStats) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.starts = append(r.starts, stats)
+}
+
+func (r *statsRecorder) RequestEnd(stats codec.RequestStats) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.ends = append(r.ends, stats)
+}
+
+func (r *statsRecorder) CodecError(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+// TestServer_StatsHandler .
+func TestServer_StatsHandler(t *testing.T) {
+	recorder := &statsRecorder{}
+	_, listener := startServer(t, WithStatsHandler(recorder))
+
+	client, err := Dial("tcp", listener.Addr().String(), WithCompress(compressor.Gzip))
+	assert.Nil(t, err)
+	args := &pb.ArithRequest{A: 20, B: 5}
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Div", args, reply))
+	assert.NotNil(t, client.Call("ArithService.Missing", args, reply))
+	assert.Nil(t, client.Close())
+
+	gzip := compressor.GzipCompressor{}
+	argsBody, _ := proto.Marshal(args)
+	zippedArgs, _ := gzip.Zip(argsBody)
+	replyBody, _ := proto.Marshal(&pb.ArithResponse{C: 4})
+	zippedReply, _ := gzip.Zip(replyBody)
+
+	// 响应发出后才回调 RequestEnd
+	assert.Eventually(t, func() bool {
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		return len(recorder.ends) == 2
+	}, time.Second, 10*time.Millisecond)
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	assert.Len(t, recorder.starts, 2)
+	assert.Empty(t, recorder.errs)
+
+	start := recorder.starts[0]
+	assert.Equal(t, "ArithService.Div", start.Method)
+	assert.Equal(t, compressor.Gzip, start.CompressType)
+	assert.Equal(t, len(zippedArgs), start.RequestSize)
+	assert.Equal(t, len(argsBody), start.RequestRawSize)
+
+	end := recorder.ends[0]
+	assert.Equal(t, "ArithService.Div", end.Method)
+	assert.Equal(t, len(zippedReply), end.ResponseSize)
+	assert.Equal(t, len(replyBody), end.ResponseRawSize)
+	assert.Equal(t, "", end.Error)
+	assert.Greater(t, int64(end.Duration), int64(0))
+
+	// 找不到方法时请求体被丢弃，响应携带错误
+	assert.Equal(t, "ArithService.Missing", recorder.starts[1].Method)
+	assert.Equal(t, 0, recorder.starts[1].RequestRawSize)
+	assert.Equal(t, "rpc: can't find method ArithService.Missing", recorder.ends[1].Error)
+}
+
+// TestServer_StatsHandlerCodecError .
+func TestServer_StatsHandlerCodecError(t *testing.T) {
+	recorder := &statsRecorder{}
+	server := NewServer(WithStatsHandler(recorder))
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(serverConn)
+		close(done)
+	}()
+
+	// 无法解码的请求头
+	clientConn.Write([]byte{0x1, 0x0})
+	clientConn.Close()
+	<-done
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	assert.Len(t, recorder.errs, 1)
+	assert.Empty(t, recorder.starts)
+}
+
+// TestServer_StatsHandlerRequestID .
+func TestServer_StatsHandlerRequestID(t *testing.T) {
+	recorder := &statsRecorder{}
+	_, listener := startServer(t, WithStatsHandler(recorder))
+
+	var next uint64 = 1 << 40
+	client, err := Dial("tcp", listener.Addr().String(), WithIDGenerator(func() uint64 {
+		return atomic.AddUint64(&next, 1)
+	}))
+	assert.Nil(t, err)
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 1}, reply))
+		assert.Equal(t, float64(i+1), reply.C)
+	}
+
+	// 服务端看到的是生成的 ID，响应发出后才记录结束
+	assert.Eventually(t, func() bool {
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		return len(recorder.ends) == 3
+	}, time.Second, 10*time.Millisecond)
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	for i, end := range recorder.ends {
+		assert.Equal(t, uint64(1<<40+i+1), end.RequestID)
+	}
+}
+
+// TestServer_FallbackSerializer .
+func TestServer_FallbackSerializer(t *testing.T) {
+	recorder := &statsRecorder{}
+	_, listener := startServer(t, WithStatsHandler(recorder), WithFallbackSerializer(serializer.JSON))
+
+	// 尚未迁移的客户端与使用服务端不认识的序列化器的客户端
+	for i, s := range []serializer.Serializer{serializer.Proto, struct{ serializer.JSONSerializer }{}} {
+		client, err := Dial("tcp", listener.Addr().String(), WithSerializer(s))
+		assert.Nil(t, err)
+		reply := &pb.ArithResponse{}
+		assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: float64(i), B: 2}, reply))
+		assert.Equal(t, float64(i+2), reply.C)
+		assert.Nil(t, client.Close())
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	assert.Len(t, recorder.starts, 2)
+	assert.False(t, recorder.starts[0].Fallback)
+	assert.True(t, recorder.starts[1].Fallback)
+}
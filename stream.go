@@ -0,0 +1,110 @@
+package tiny_rpc
+
+import (
+	"io"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"tiny_rpc/codec"
+)
+
+var typeOfServerStream = reflect.TypeOf((*ServerStream)(nil))
+
+// chunkWriter is implemented by server codecs able to send several responses for one request
+type chunkWriter interface {
+	WriteChunk(response *rpc.Response, param any) error
+}
+
+// ServerStream sends the messages of a streaming call back to the client.
+// A streaming method takes the stream in place of the reply:
+//
+//	func (t *T) MethodName(args T1, stream *ServerStream) error
+//
+// and the call ends with the final response once the method returns
+type ServerStream struct {
+	sending *sync.Mutex
+	codec   rpc.ServerCodec
+	req     *rpc.Request
+}
+
+// Send send a message to the client, it must not be called after the method returned
+func (s *ServerStream) Send(msg interface{}) error {
+	w, ok := s.codec.(chunkWriter)
+	if !ok {
+		return StreamNotSupportedError
+	}
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	return w.WriteChunk(&rpc.Response{ServiceMethod: s.req.ServiceMethod, Seq: s.req.Seq}, msg)
+}
+
+// A method may also stream one large reply in frames rather than buffering it, by taking
+// an *io.Reader as reply and setting it:
+//
+//	func (t *T) MethodName(args T1, reply *io.Reader) error
+//
+// The reader is closed after it is drained if it is an io.Closer. The caller passes an
+// *io.Reader reply as well and reads the body from it once the call returns. A few frames are
+// buffered for the caller, see WithStreamBuffer, a reader which is neither read nor closed through
+// its io.Closer holds up the other responses of the connection until it is abandoned
+
+// ClientStream receives the messages of a streaming call
+type ClientStream struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	chunks []codec.Chunk // messages not received yet
+	done   bool
+	err    error // error ending the stream, io.EOF when the call succeeded
+}
+
+// Stream start a streaming call, the messages sent by the method are received with Recv
+func (c *Client) Stream(serviceMethod string, args interface{}, opts ...CallOption) *ClientStream {
+	s := &ClientStream{}
+	s.cond = sync.NewCond(&s.mutex)
+	p := &codec.Param{Value: args, Metadata: make(map[string]string), OnChunk: s.push}
+	for _, option := range opts {
+		option(p)
+	}
+	call := c.Go(serviceMethod, p, nil, make(chan *rpc.Call, 1))
+	go s.wait(call)
+	return s
+}
+
+// push queue a message, called by the codec reading goroutine
+func (s *ClientStream) push(chunk codec.Chunk) {
+	s.mutex.Lock()
+	s.chunks = append(s.chunks, chunk)
+	s.mutex.Unlock()
+	s.cond.Signal()
+}
+
+// wait end the stream with the final response of the call
+func (s *ClientStream) wait(call *rpc.Call) {
+	<-call.Done
+	s.mutex.Lock()
+	s.done = true
+	s.err = io.EOF
+	if call.Error != nil {
+		s.err = convertError(call.Error)
+	}
+	s.mutex.Unlock()
+	s.cond.Broadcast()
+}
+
+// Recv receive the next message into msg, it returns io.EOF after the last message
+// of a successful call, or the error of the call
+func (s *ClientStream) Recv(msg interface{}) error {
+	s.mutex.Lock()
+	for len(s.chunks) == 0 && !s.done {
+		s.cond.Wait()
+	}
+	if len(s.chunks) == 0 {
+		err := s.err
+		s.mutex.Unlock()
+		return err
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	s.mutex.Unlock()
+	return chunk.Decode(msg)
+}
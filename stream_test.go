@@ -0,0 +1,259 @@
+package tiny_rpc
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+	"tiny_rpc/codec"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// CountService streams the numbers from A up to B
+type CountService struct{}
+
+// Count .
+func (_ *CountService) Count(args *pb.ArithRequest, stream *ServerStream) error {
+	for i := args.A; i < args.B; i++ {
+		if err := stream.Send(&pb.ArithResponse{C: i}); err != nil {
+			return err
+		}
+	}
+	if args.B < args.A {
+		return errors.New("count: B is less than A")
+	}
+	return nil
+}
+
+// TestStream .
+func TestStream(t *testing.T) {
+	server, listener := startServer(t)
+	assert.Nil(t, server.Register(new(CountService)))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	stream := client.Stream("CountService.Count", &pb.ArithRequest{A: 0, B: 100})
+	// 流式调用期间普通调用照常进行
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+
+	for i := 0; i < 100; i++ {
+		msg := &pb.ArithResponse{}
+		assert.Nil(t, stream.Recv(msg))
+		assert.Equal(t, float64(i), msg.C)
+	}
+	assert.Equal(t, io.EOF, stream.Recv(&pb.ArithResponse{}))
+	assert.Equal(t, io.EOF, stream.Recv(&pb.ArithResponse{}))
+}
+
+// TestStream_Error .
+func TestStream_Error(t *testing.T) {
+	server, listener := startServer(t)
+	assert.Nil(t, server.Register(new(CountService)))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	stream := client.Stream("CountService.Count", &pb.ArithRequest{A: 5, B: 1})
+	err = stream.Recv(&pb.ArithResponse{})
+	assert.EqualError(t, err, "count: B is less than A")
+}
+
+// DownloadService streams A bytes of a pattern back, failing after B bytes when B is set
+type DownloadService struct{}
+
+// Download .
+func (_ *DownloadService) Download(args *pb.ArithRequest, reply *io.Reader) error {
+	var r io.Reader = io.LimitReader(patternReader{}, int64(args.A))
+	if args.B > 0 {
+		r = io.MultiReader(io.LimitReader(patternReader{}, int64(args.B)), failingReader{})
+	}
+	*reply = r
+	return nil
+}
+
+// patternReader reads an endless repeating byte pattern
+type patternReader struct{}
+
+func (patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(i % 251)
+	}
+	return len(p), nil
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("disk failure")
+}
+
+// TestStream_Reader .
+func TestStream_Reader(t *testing.T) {
+	server, listener := startServer(t)
+	assert.Nil(t, server.Register(new(DownloadService)))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	const size = 16 << 20
+	expect := crc32.NewIEEE()
+	_, err = io.Copy(expect, io.LimitReader(patternReader{}, size))
+	assert.Nil(t, err)
+
+	var (
+		before runtime.MemStats
+		peak   uint64
+		stop   = make(chan struct{})
+		done   = make(chan struct{})
+	)
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	go func() {
+		defer close(done)
+		var m runtime.MemStats
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			runtime.ReadMemStats(&m)
+			if m.HeapAlloc > peak {
+				peak = m.HeapAlloc
+			}
+		}
+	}()
+
+	var body io.Reader
+	assert.Nil(t, client.Call("DownloadService.Download", &pb.ArithRequest{A: size}, &body))
+	got := crc32.NewIEEE()
+	n, err := io.Copy(got, body)
+	close(stop)
+	<-done
+	assert.Nil(t, err)
+	assert.Equal(t, int64(size), n)
+	assert.Equal(t, expect.Sum32(), got.Sum32())
+	// 数据分帧传输，内存占用远小于数据大小
+	assert.Less(t, peak, before.HeapAlloc+size/2)
+
+	// 数据流结束后连接照常使用
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// TestStream_ReaderError .
+func TestStream_ReaderError(t *testing.T) {
+	server, listener := startServer(t)
+	assert.Nil(t, server.Register(new(DownloadService)))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	cases := []struct {
+		name string
+		args *pb.ArithRequest
+		read int64
+		err  string
+	}{
+		{"test-1", &pb.ArithRequest{A: 0}, 0, ""},
+		{"test-2", &pb.ArithRequest{A: 100}, 100, ""},
+		{"test-3", &pb.ArithRequest{B: 100 << 10}, 100 << 10, "disk failure"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var body io.Reader
+			assert.Nil(t, client.Call("DownloadService.Download", c.args, &body))
+			n, err := io.Copy(io.Discard, body)
+			assert.Equal(t, c.read, n)
+			if c.err == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, c.err)
+			}
+		})
+	}
+
+	// 调用方关闭 reader 后剩余的帧被丢弃
+	var body io.Reader
+	assert.Nil(t, client.Call("DownloadService.Download", &pb.ArithRequest{A: 1 << 20}, &body))
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(body, buf)
+	assert.Nil(t, err)
+	assert.Nil(t, body.(io.Closer).Close())
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+
+	// 结果不是 *io.Reader 时调用失败
+	err = client.Call("DownloadService.Download", &pb.ArithRequest{A: 100}, &pb.ArithResponse{})
+	assert.EqualError(t, err, "reading body "+codec.StreamedBodyError.Error())
+}
+
+// TestStream_ReaderAbandoned .
+func TestStream_ReaderAbandoned(t *testing.T) {
+	server, listener := startServer(t)
+	assert.Nil(t, server.Register(new(DownloadService)))
+
+	client, err := Dial("tcp", listener.Addr().String(), WithStreamBuffer(1, 50*time.Millisecond))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// 不读取的数据流不会一直阻塞连接上的其他响应
+	var body io.Reader
+	assert.Nil(t, client.Call("DownloadService.Download", &pb.ArithRequest{A: 1 << 20}, &body))
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+
+	// 缓冲的帧读完后数据流以错误结束
+	n, err := io.Copy(io.Discard, body)
+	assert.Equal(t, codec.StreamAbandonedError, err)
+	assert.Less(t, n, int64(1<<20))
+}
+
+// TestStream_ReaderSizeLimit .
+func TestStream_ReaderSizeLimit(t *testing.T) {
+	server, listener := startServer(t)
+	assert.Nil(t, server.Register(new(DownloadService)))
+
+	client, err := Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	cases := []struct {
+		name  string
+		size  float64
+		limit uint32
+		err   error
+	}{
+		{"test-1", 100 << 10, 200 << 10, nil},
+		{"test-2", 100 << 10, 50 << 10, codec.ResponseTooLargeError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var body io.Reader
+			assert.Nil(t, client.Call("DownloadService.Download", &pb.ArithRequest{A: c.size}, &body,
+				WithResponseSizeLimit(c.limit)))
+			n, err := io.Copy(io.Discard, body)
+			if c.err == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, int64(c.size), n)
+				return
+			}
+			assert.EqualError(t, err, c.err.Error())
+			assert.LessOrEqual(t, n, int64(c.limit))
+		})
+	}
+}
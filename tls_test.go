@@ -0,0 +1,67 @@
+package tiny_rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// selfSignedCert create a self-signed certificate for 127.0.0.1
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tinyrpc"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// TestTLS .
+func TestTLS(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	_, listener := startServer(t, WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	client, err := Dial("tcp", listener.Addr().String(), WithTLSConfig(&tls.Config{RootCAs: pool}))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+}
+
+// TestTLS_UntrustedCert .
+func TestTLS_UntrustedCert(t *testing.T) {
+	cert, _ := selfSignedCert(t)
+	_, listener := startServer(t, WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	client, err := Dial("tcp", listener.Addr().String(), WithTLSConfig(&tls.Config{}))
+	assert.Nil(t, client)
+	var certErr *tls.CertificateVerificationError
+	assert.ErrorAs(t, err, &certErr)
+}
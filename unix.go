@@ -0,0 +1,32 @@
+package tiny_rpc
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+)
+
+// ListenUnix listen on the unix domain socket at path for Serve. A socket file left behind
+// by a previous process is removed first, and closing the listener removes the socket file
+func ListenUnix(path string) (net.Listener, error) {
+	// 仅删除遗留的套接字文件，不误删普通文件
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(true)
+	return listener, nil
+}
+
+// DialUnix connects to the rpc server listening on the unix domain socket at path
+func DialUnix(path string, opts ...Option) (*Client, error) {
+	return Dial("unix", path, opts...)
+}
@@ -0,0 +1,59 @@
+package tiny_rpc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	pb "tiny_rpc/test.data/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUnix .
+func TestUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+	listener, err := ListenUnix(path)
+	assert.Nil(t, err)
+	server := NewServer()
+	assert.Nil(t, server.Register(new(pb.ArithService)))
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	client, err := DialUnix(path)
+	assert.Nil(t, err)
+	reply := &pb.ArithResponse{}
+	assert.Nil(t, client.Call("ArithService.Add", &pb.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, 3.0, reply.C)
+	client.Close()
+
+	// 关闭监听后套接字文件被删除
+	assert.Nil(t, listener.Close())
+	select {
+	case err = <-served:
+		assert.NotNil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return")
+	}
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestListenUnix_StaleSocket .
+func TestListenUnix_StaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+	stale, err := ListenUnix(path)
+	assert.Nil(t, err)
+	// 模拟进程退出后遗留的套接字文件
+	stale.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	assert.Nil(t, stale.Close())
+
+	listener, err := ListenUnix(path)
+	assert.Nil(t, err)
+	assert.Nil(t, listener.Close())
+
+	// 不删除普通文件
+	assert.Nil(t, os.WriteFile(path, nil, 0o600))
+	_, err = ListenUnix(path)
+	assert.NotNil(t, err)
+}